@@ -0,0 +1,87 @@
+/**
+ * File: audit.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Monday, October 19th 2026, 9:41:20 am
+ * Last Modified: Monday, October 19th 2026, 9:41:20 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxAuditEntries is the number of the audit entries kept in memory
+const maxAuditEntries = 500
+
+// AuditEntry is an action of a user on the admin API, like a login
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Role    string    `json:"role,omitempty"`
+	Action  string    `json:"action"`
+	Remote  string    `json:"remote"`
+	Success bool      `json:"success"`
+	Detail  string    `json:"detail,omitempty"`
+}
+
+// AuditLog keeps the recent audit entries, also appends them into the file if configured
+type AuditLog struct {
+	lock    sync.Mutex
+	path    string
+	entries []AuditEntry
+}
+
+// Record to append the entry into the audit log
+func (a *AuditLog) Record(entry AuditEntry) {
+	entry.Time = time.Now()
+	log.Infof("[audit] %s %s from %s, success %v %s", entry.User, entry.Action, entry.Remote, entry.Success, entry.Detail)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.entries = append(a.entries, entry)
+	if len(a.entries) > maxAuditEntries {
+		a.entries = a.entries[len(a.entries)-maxAuditEntries:]
+	}
+
+	if a.path == "" {
+		return
+	}
+
+	if err := a.appendFile(entry); err != nil {
+		log.Errorf("write the audit log %s failed, %v", a.path, err)
+	}
+}
+
+// appendFile to append the entry as a json line into the audit file
+func (a *AuditLog) appendFile(entry AuditEntry) (err error) {
+	file, err := os.OpenFile(a.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(entry)
+}
+
+// Entries returns the recent audit entries, the latest is the last one
+func (a *AuditLog) Entries() []AuditEntry {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return append([]AuditEntry(nil), a.entries...)
+}
+
+// NewAuditLog returns the audit log, appends into the file if the path is not empty
+func NewAuditLog(path string) *AuditLog {
+	return &AuditLog{path: path}
+}
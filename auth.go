@@ -0,0 +1,413 @@
+/**
+ * File: auth.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Monday, October 19th 2026, 10:02:53 am
+ * Last Modified: Monday, October 19th 2026, 10:02:53 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+
+	sessionCookieName = "socks5lb_session"
+	csrfHeaderName    = "X-CSRF-Token"
+	sessionContextKey = "session"
+
+	defaultSessionTTL = 12 * time.Hour
+
+	totpPeriod = 30
+	totpDigits = 6
+)
+
+// Account is a local user of the admin API
+type Account struct {
+	Username string `yaml:"username"`
+	// PasswordHash is the bcrypt hash of the password
+	PasswordHash string `yaml:"password_hash"`
+	// TOTPSecret is the base32 secret of the time-based one-time password, optional
+	TOTPSecret string `yaml:"totp_secret"`
+	// Role is "viewer", "operator" or "admin"
+	Role string `yaml:"role"`
+}
+
+type AuthConfig struct {
+	Accounts []Account `yaml:"accounts"`
+	// SessionTTL is the lifetime of the sessions in seconds, 12 hours by default
+	SessionTTL uint `yaml:"session_ttl"`
+	// AuditFile to append the audit log as json lines, optional
+	AuditFile string `yaml:"audit_file"`
+}
+
+// Enabled returns true if the login is required by the admin API
+func (c AuthConfig) Enabled() bool {
+	return len(c.Accounts) > 0
+}
+
+// adminRoutes are the routes only for the admin role, the other
+// routes are for the viewers to read and the operators to write
+var adminRoutes = map[string]bool{
+	"PUT /api/add":         true,
+	"DELETE /api/delete":   true,
+	"GET /api/diagnostics": true,
+	"GET /api/audit":       true,
+	"GET /api/commands":    true,
+}
+
+// roleLevel returns the level of the role, zero for the unknown roles
+func roleLevel(role string) int {
+	switch role {
+	case RoleViewer:
+		return 1
+	case RoleOperator:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+
+	return 0
+}
+
+// requiredRole returns the minimal role to access the route
+func requiredRole(c *gin.Context) string {
+	if adminRoutes[c.Request.Method+" "+c.FullPath()] ||
+		(c.FullPath() == "/api/backends/:action" && c.Param("action") == "remove") {
+		return RoleAdmin
+	}
+
+	// the picks open the relay ports of the backends with the credentials
+	if isSafeMethod(c.Request.Method) && c.FullPath() != "/api/pick" {
+		return RoleViewer
+	}
+
+	return RoleOperator
+}
+
+// canViewSecrets returns true if the secrets of the backends are shown to the request,
+// only the admins see them if the login is required
+func canViewSecrets(c *gin.Context) bool {
+	session, ok := c.Get(sessionContextKey)
+	if !ok {
+		return true
+	}
+
+	return roleLevel(session.(*Session).Role) >= roleLevel(RoleAdmin)
+}
+
+// isSafeMethod returns true if the method does not change anything
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// Session is the login session of a user
+type Session struct {
+	ID        string    `json:"-"`
+	User      string    `json:"username"`
+	Role      string    `json:"role"`
+	CSRFToken string    `json:"csrf_token"`
+	Expires   time.Time `json:"expires"`
+}
+
+// sessionStore keeps the login sessions in memory
+type sessionStore struct {
+	lock     sync.Mutex
+	ttl      time.Duration
+	sessions map[string]*Session
+	// totpUsed is the last used time step of each user, to reject the replays
+	totpUsed map[string]int64
+}
+
+// randomToken returns a random hex token
+func randomToken() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(data), nil
+}
+
+// Create to create a new session for the account
+func (s *sessionStore) Create(account *Account) (session *Session, err error) {
+	session = &Session{User: account.Username, Role: account.Role, Expires: time.Now().Add(s.ttl)}
+	if session.ID, err = randomToken(); err != nil {
+		return
+	}
+
+	if session.CSRFToken, err = randomToken(); err != nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// forget the expired sessions
+	now := time.Now()
+	for id, v := range s.sessions {
+		if now.After(v.Expires) {
+			delete(s.sessions, id)
+		}
+	}
+
+	s.sessions[session.ID] = session
+	return
+}
+
+// Get returns the alive session by the id
+func (s *sessionStore) Get(id string) *Session {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	session := s.sessions[id]
+	if session == nil || time.Now().After(session.Expires) {
+		delete(s.sessions, id)
+		return nil
+	}
+
+	return session
+}
+
+// Delete to remove the session
+func (s *sessionStore) Delete(id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.sessions, id)
+}
+
+// UseTOTP returns false if the time step of the user is used already
+func (s *sessionStore) UseTOTP(user string, counter int64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if last, ok := s.totpUsed[user]; ok && counter <= last {
+		return false
+	}
+
+	s.totpUsed[user] = counter
+	return true
+}
+
+// newSessionStore returns the session store with the lifetime in seconds
+func newSessionStore(ttl uint) *sessionStore {
+	store := &sessionStore{
+		ttl:      time.Duration(ttl) * time.Second,
+		sessions: make(map[string]*Session),
+		totpUsed: make(map[string]int64),
+	}
+
+	if store.ttl <= 0 {
+		store.ttl = defaultSessionTTL
+	}
+
+	return store
+}
+
+// TOTPCode returns the one-time password of the time step, see RFC 6238
+func TOTPCode(secret []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, code%1000000)
+}
+
+// verifyTOTP returns the matched time step of the code, one step of clock skew is allowed
+func verifyTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(
+		strings.TrimRight(strings.ToUpper(strings.ReplaceAll(secret, " ", "")), "="))
+	if err != nil {
+		return 0, false
+	}
+
+	counter := now.Unix() / totpPeriod
+	for _, step := range []int64{counter - 1, counter, counter + 1} {
+		if subtle.ConstantTimeCompare([]byte(TOTPCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+var (
+	dummyPasswordHash     []byte
+	dummyPasswordHashOnce sync.Once
+)
+
+// checkPassword to compare the password with the hash, also spends the same
+// time for the unknown users to not tell whether the user exists
+func checkPassword(account *Account, password string) bool {
+	if account == nil {
+		dummyPasswordHashOnce.Do(func() {
+			dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+		})
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)) == nil
+}
+
+// account returns the configured account by the username
+func (s *Server) account(username string) *Account {
+	for i, account := range s.Config.HTTP.Auth.Accounts {
+		if account.Username == username {
+			return &s.Config.HTTP.Auth.Accounts[i]
+		}
+	}
+
+	return nil
+}
+
+// login to check the credentials, returns the reason for the audit log if failed
+func (s *Server) login(username, password, otp string) (*Account, string) {
+	account := s.account(username)
+	if !checkPassword(account, password) {
+		return nil, "invalid username or password"
+	}
+
+	if roleLevel(account.Role) == 0 {
+		return nil, fmt.Sprintf("unknown role %s", account.Role)
+	}
+
+	if account.TOTPSecret != "" {
+		counter, ok := verifyTOTP(account.TOTPSecret, otp, time.Now())
+		if !ok {
+			return nil, "invalid one-time password"
+		}
+
+		if !s.sessions.UseTOTP(account.Username, counter) {
+			return nil, "one-time password is used already"
+		}
+	}
+
+	return account, ""
+}
+
+// setupAuthRouter to handle the login, the logout and the session of the dashboard users
+func (s *Server) setupAuthRouter(engine *gin.Engine) {
+	engine.POST("/login", func(c *gin.Context) {
+		var credentials struct {
+			Username string `json:"username" binding:"required"`
+			Password string `json:"password" binding:"required"`
+			OTP      string `json:"otp"`
+		}
+
+		if err := c.ShouldBindJSON(&credentials); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		entry := AuditEntry{User: credentials.Username, Action: "login", Remote: c.ClientIP()}
+		account, reason := s.login(credentials.Username, credentials.Password, credentials.OTP)
+		if account == nil {
+			entry.Detail = reason
+			s.audit.Record(entry)
+			c.String(http.StatusUnauthorized, "invalid username, password or one-time password")
+			return
+		}
+
+		session, err := s.sessions.Create(account)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		entry.Role, entry.Success = account.Role, true
+		s.audit.Record(entry)
+
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     sessionCookieName,
+			Value:    session.ID,
+			Path:     "/",
+			Expires:  session.Expires,
+			HttpOnly: true,
+			Secure:   c.Request.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+		})
+		c.JSON(http.StatusOK, session)
+	})
+
+	engine.GET("/session", s.authRequired, func(c *gin.Context) {
+		c.JSON(http.StatusOK, c.MustGet(sessionContextKey))
+	})
+
+	engine.POST("/logout", s.authRequired, func(c *gin.Context) {
+		session := c.MustGet(sessionContextKey).(*Session)
+		s.sessions.Delete(session.ID)
+
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     sessionCookieName,
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   c.Request.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+		})
+		c.String(http.StatusOK, "logged out")
+	})
+}
+
+// authRequired to check the session, the csrf token and the role of the request,
+// the changes by the users are recorded in the audit log
+func (s *Server) authRequired(c *gin.Context) {
+	id, err := c.Cookie(sessionCookieName)
+	session := s.sessions.Get(id)
+	if err != nil || session == nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "login is required"})
+		return
+	}
+
+	safe := isSafeMethod(c.Request.Method)
+	if !safe && subtle.ConstantTimeCompare([]byte(c.GetHeader(csrfHeaderName)), []byte(session.CSRFToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "invalid csrf token"})
+		return
+	}
+
+	if roleLevel(session.Role) < roleLevel(requiredRole(c)) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("permission denied for %s", session.Role)})
+		return
+	}
+
+	c.Set(sessionContextKey, session)
+	c.Next()
+
+	if !safe {
+		s.audit.Record(AuditEntry{
+			User:    session.User,
+			Role:    session.Role,
+			Action:  c.Request.Method + " " + c.Request.URL.RequestURI(),
+			Remote:  c.ClientIP(),
+			Success: c.Writer.Status() < http.StatusBadRequest,
+		})
+	}
+}
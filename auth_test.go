@@ -0,0 +1,181 @@
+package socks5lb
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// the test vectors of RFC 6238, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	assert.Equal(t, "287082", TOTPCode(secret, 59/totpPeriod))
+	assert.Equal(t, "081804", TOTPCode(secret, 1111111109/totpPeriod))
+
+	encoded := base32.StdEncoding.EncodeToString(secret)
+	now := time.Unix(1111111109, 0)
+	_, ok := verifyTOTP(encoded, "081804", now)
+	assert.True(t, ok)
+	_, ok = verifyTOTP(encoded, "081804", now.Add(5*time.Minute))
+	assert.False(t, ok)
+}
+
+// AuthEngineInstance returns a standalone engine with the accounts
+func AuthEngineInstance(t *testing.T, accounts ...Account) (*Server, *gin.Engine) {
+	config := ServerConfig{}
+	config.HTTP.Auth.Accounts = accounts
+	server, err := NewServer(&Pool{backends: make(map[string]*Backend)}, config)
+	assert.NoError(t, err)
+
+	engine := gin.New()
+	server.setupAuthRouter(engine)
+	apiGroup := engine.Group("/api")
+	apiGroup.Use(server.authRequired)
+	assert.NoError(t, server.setupAPIRouter(apiGroup))
+
+	return server, engine
+}
+
+func login(t *testing.T, engine *gin.Engine, username, password, otp string) (*http.Cookie, string, int) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/login", strings.NewReader(
+		fmt.Sprintf(`{"username": %q, "password": %q, "otp": %q}`, username, password, otp)))
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		return nil, "", w.Code
+	}
+
+	var session Session
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &session))
+	return w.Result().Cookies()[0], session.CSRFToken, w.Code
+}
+
+func TestServer_HTTPRedactSecrets(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	server, engine := AuthEngineInstance(t,
+		Account{Username: "viewer", PasswordHash: string(hash), Role: RoleViewer},
+		Account{Username: "admin", PasswordHash: string(hash), Role: RoleAdmin},
+	)
+
+	backend := NewBackend("10.60.0.1:1080", BackendCheckConfig{})
+	backend.UserName, backend.Password = "alice", "s3cret"
+	backend.Command.Env = []string{"SSH_TOKEN=t0ken"}
+	backend.HTTP.PasswordFile = "/run/secrets/proxy"
+	assert.NoError(t, server.Pool.Add(backend))
+
+	assert.NoError(t, server.Pool.Add(NewBackend("10.60.0.2:1080", BackendCheckConfig{InitialAlive: true})))
+	lease, err := server.Lease(nil, time.Minute)
+	assert.NoError(t, err)
+	defer server.ReleaseAll()
+
+	get := func(path string, cookie *http.Cookie) string {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		req.AddCookie(cookie)
+		engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	// the viewers see the names only
+	cookie, _, _ := login(t, engine, "viewer", "secret", "")
+	for _, path := range []string{"/api/all", "/api/backends"} {
+		body := get(path, cookie)
+		assert.NotContains(t, body, "s3cret")
+		assert.NotContains(t, body, "t0ken")
+		assert.NotContains(t, body, "/run/secrets")
+		assert.Contains(t, body, "SSH_TOKEN=")
+		assert.Contains(t, body, "alice")
+	}
+
+	// so are the leases, without the credentials of their ports
+	body := get("/api/leases", cookie)
+	assert.Contains(t, body, lease.ID)
+	assert.NotContains(t, body, lease.Password)
+
+	cookie, _, _ = login(t, engine, "admin", "secret", "")
+	for _, path := range []string{"/api/all", "/api/backends"} {
+		body := get(path, cookie)
+		assert.Contains(t, body, "s3cret")
+		assert.Contains(t, body, "SSH_TOKEN=t0ken")
+	}
+	assert.Contains(t, get("/api/leases", cookie), lease.Password)
+
+	// the backend itself is not changed
+	assert.Equal(t, "s3cret", backend.Password)
+}
+
+func TestServer_HTTPLogin(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	secret := []byte("12345678901234567890")
+	server, engine := AuthEngineInstance(t,
+		Account{Username: "viewer", PasswordHash: string(hash), Role: RoleViewer},
+		Account{Username: "admin", PasswordHash: string(hash), Role: RoleAdmin,
+			TOTPSecret: base32.StdEncoding.EncodeToString(secret)},
+	)
+
+	do := func(method, path string, cookie *http.Cookie, csrf string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		req.Header.Set(csrfHeaderName, csrf)
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// login is required
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/all", nil, ""))
+
+	_, _, code := login(t, engine, "viewer", "wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	_, _, code = login(t, engine, "nobody", "secret", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	cookie, csrf, code := login(t, engine, "viewer", "secret", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, cookie.HttpOnly)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/all", cookie, ""))
+	assert.Equal(t, http.StatusForbidden, do(http.MethodDelete, "/api/cache", cookie, csrf))
+
+	// the one-time password is required and can not be replayed
+	_, _, code = login(t, engine, "admin", "secret", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	otp := TOTPCode(secret, time.Now().Unix()/totpPeriod)
+	cookie, csrf, code = login(t, engine, "admin", "secret", otp)
+	assert.Equal(t, http.StatusOK, code)
+	_, _, code = login(t, engine, "admin", "secret", otp)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// the csrf token is required by the changes
+	assert.Equal(t, http.StatusForbidden, do(http.MethodDelete, "/api/delete?addr=127.0.0.1:1", cookie, ""))
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodDelete, "/api/delete?addr=127.0.0.1:1", cookie, csrf))
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/audit", cookie, ""))
+
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/logout", cookie, csrf))
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/all", cookie, ""))
+
+	var logins, failures int
+	for _, entry := range server.audit.Entries() {
+		if entry.Action == "login" {
+			logins++
+			if !entry.Success {
+				failures++
+			}
+		}
+	}
+	assert.Equal(t, 6, logins)
+	assert.Equal(t, 4, failures)
+}
@@ -11,12 +11,16 @@
 package socks5lb
 
 import (
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
+	"io"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/jcmturner/gokrb5/v8/client"
 	"github.com/txthinking/socks5"
 )
 
@@ -24,24 +28,168 @@ type BackendCheckConfig struct {
 	CheckURL     string `yaml:"check_url" json:"check_url"`
 	InitialAlive bool   `yaml:"initial_alive" json:"initial_alive"`
 	Timeout      uint   `yaml:"timeout" json:"timeout"`
+	// EgressIPURL responses the client ip in plain text, like https://api.ipify.org,
+	// to record the egress ip of the backend after the successful checks
+	EgressIPURL string `yaml:"egress_ip_url" json:"egress_ip_url"`
 }
 
+// CheckResult is the result of a single health check
+type CheckResult struct {
+	Time    time.Time     `json:"time"`
+	Alive   bool          `json:"alive"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+	// Layer is the first failed layer diagnosed, and State is the health state by it
+	Layer string `json:"layer,omitempty"`
+	State string `json:"state,omitempty"`
+}
+
+// maxCheckHistory is the number of the check results kept for each backend
+const maxCheckHistory = 20
+
+const (
+	ProtocolSocks5 = "socks5"
+	ProtocolQUIC   = "quic"
+	ProtocolDirect = "direct"
+	// ProtocolWireGuard connects the destinations through a userspace wireguard tunnel to the peer
+	ProtocolWireGuard = "wireguard"
+	// ProtocolHTTP connects the destinations by the CONNECT requests through the http proxy
+	ProtocolHTTP = "http"
+)
+
 type Backend struct {
-	Addr        string             `yaml:"addr" json:"addr" binding:"required"`
-	UserName    string             `yaml:"username" json:"username"`
-	Password    string             `yaml:"password" json:"password"`
-	CheckConfig BackendCheckConfig `yaml:"check_config" json:"check_config"`
+	Addr        string                 `yaml:"addr" json:"addr" binding:"required"`
+	Protocol    string                 `yaml:"protocol" json:"protocol"`
+	UserName    string                 `yaml:"username" json:"username"`
+	Password    string                 `yaml:"password" json:"password"`
+	CheckConfig BackendCheckConfig     `yaml:"check_config" json:"check_config"`
+	Labels      map[string]string      `yaml:"labels" json:"labels"`
+	Weight      uint                   `yaml:"weight" json:"weight"`
+	QUIC        BackendQUICConfig      `yaml:"quic" json:"quic"`
+	Egress      EgressConfig           `yaml:"egress" json:"egress"`
+	WireGuard   BackendWireGuardConfig `yaml:"wireguard" json:"wireguard"`
+	Command     BackendCommandConfig   `yaml:"command" json:"command"`
+	HTTP        BackendHTTPConfig      `yaml:"http" json:"http"`
 
-	alive bool
+	alive         bool
+	disabled      bool
+	draining      bool
+	leased        bool
+	currentWeight int
+	history       []CheckResult
+	quic          *quicClient
+	egress        *egressPool
+	wireguard     *wireguardTunnel
+	command       *commandProcess
+	kerberos      *client.Client
+	egressIP      string
+	latency       *latencySamples
+	diagnosis     *Diagnosis
 }
 
-// Alive returns backend status
+// Alive returns backend status, the backends with a helper process are down if it is not running
 func (b *Backend) Alive() bool {
-	return b.alive
+	return b.alive && b.commandRunning()
+}
+
+// Available returns true if the backend is alive and accepts new connections, the
+// leased backends only accept the connections of their leases
+func (b *Backend) Available() bool {
+	return b.Alive() && !b.disabled && !b.draining && !b.leased
+}
+
+// Leased returns true if the backend is reserved by a lease
+func (b *Backend) Leased() bool {
+	return b.leased
+}
+
+// Disabled returns true if the backend is disabled, it will not be checked and used
+func (b *Backend) Disabled() bool {
+	return b.disabled
+}
+
+// Draining returns true if the backend does not accept new connections
+func (b *Backend) Draining() bool {
+	return b.draining
+}
+
+// Enable to clear the disabled and draining flags of the backend
+func (b *Backend) Enable() {
+	b.disabled, b.draining = false, false
+}
+
+// Disable to stop checking and using the backend
+func (b *Backend) Disable() {
+	b.disabled = true
+}
+
+// Drain to stop sending new connections to the backend, the established ones are kept
+func (b *Backend) Drain() {
+	b.draining = true
+}
+
+// EffectiveWeight returns the weight for balancing, zero weight is treated as one
+func (b *Backend) EffectiveWeight() int {
+	if b.Weight == 0 {
+		return 1
+	}
+
+	return int(b.Weight)
+}
+
+// History returns the recent check results, the latest is the last one
+func (b *Backend) History() []CheckResult {
+	return append([]CheckResult(nil), b.history...)
+}
+
+// recordCheck to append the check result into the history
+func (b *Backend) recordCheck(start time.Time, err error) {
+	result := CheckResult{
+		Time:    start,
+		Alive:   b.alive,
+		Latency: time.Since(start),
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+		if b.diagnosis != nil {
+			result.Layer, result.State = b.diagnosis.Layer, b.diagnosis.State
+		}
+	}
+
+	b.history = append(b.history, result)
+	if len(b.history) > maxCheckHistory {
+		b.history = b.history[len(b.history)-maxCheckHistory:]
+	}
+}
+
+// failCheck to mark the backend down after the check is passed, the result of
+// the check is amended instead of recording another one
+func (b *Backend) failCheck(err error) {
+	b.alive = false
+	if n := len(b.history); n > 0 {
+		b.history[n-1].Alive, b.history[n-1].Error = false, err.Error()
+	}
 }
 
 // Check function to check the node healthy by given url
 func (b *Backend) Check() (err error) {
+	start := time.Now()
+	defer func() {
+		// find out which layer is broken for the failed check
+		b.diagnosis = nil
+		if err != nil && !b.alive {
+			b.diagnosis = b.diagnose()
+		}
+
+		b.recordCheck(start, err)
+	}()
+
+	if !b.commandRunning() {
+		b.alive = false
+		return errCommandNotRunning
+	}
+
 	if url := b.CheckConfig.CheckURL; url != "" {
 		var (
 			client *http.Client
@@ -53,11 +201,18 @@ func (b *Backend) Check() (err error) {
 		}
 
 		resp, err = client.Head(url)
-		if err != nil || (resp != nil && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusMovedPermanently && resp.StatusCode != http.StatusFound) {
+		if err == nil && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusMovedPermanently && resp.StatusCode != http.StatusFound {
+			err = fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+
+		if err != nil {
 			log.Error(err)
 			b.alive = false
+			b.recordFailure(time.Since(start), err)
 		} else {
 			b.alive = true
+			b.recordLatency(time.Since(start))
+			b.recordEgressIP(client)
 		}
 
 		return
@@ -67,14 +222,44 @@ func (b *Backend) Check() (err error) {
 	return
 }
 
+// recordEgressIP to learn the egress ip of the backend by the egress_ip_url, if any
+func (b *Backend) recordEgressIP(client *http.Client) {
+	url := b.CheckConfig.EgressIPURL
+	if url == "" {
+		return
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		log.Debugf("lookup the egress ip of backend %s failed, %v", b.Addr, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64))
+	if ip := net.ParseIP(strings.TrimSpace(string(data))); resp.StatusCode == http.StatusOK && ip != nil {
+		if b.egressIP != ip.String() {
+			RecordEvent("backend.egress", "the egress ip of backend %s is %s", b.Addr, ip)
+		}
+		b.egressIP = ip.String()
+	} else {
+		log.Debugf("unexpected egress ip response of backend %s, status code %d", b.Addr, resp.StatusCode)
+	}
+}
+
+// EgressIP returns the recorded egress ip of the backend, empty if unknown
+func (b *Backend) EgressIP() string {
+	return b.egressIP
+}
+
 // httpProxyClient to create http client with socks5 proxy
 func (b *Backend) httpProxyClient() (*http.Client, error) {
-	var timeout = b.CheckConfig.Timeout
+	var timeout = b.Timeout()
 
 	// setup a http client
 	httpTransport := &http.Transport{
 		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			return b.Socks5Conn("tcp", addr, int(timeout))
+			return b.Socks5Conn("tcp", addr, timeout)
 		},
 	}
 
@@ -89,8 +274,88 @@ func (b *Backend) socks5Client(timeout int) (*socks5.Client, error) {
 	return socks5.NewClient(string(b.Addr), b.UserName, b.Password, timeout, timeout)
 }
 
+// Dial to connect the socks5 service of the backend by its protocol
+func (b *Backend) Dial(timeout int) (net.Conn, error) {
+	switch b.Protocol {
+	case "", ProtocolSocks5:
+		return net.DialTimeout("tcp", b.Addr, time.Duration(timeout)*time.Second)
+	case ProtocolQUIC:
+		return b.dialQUIC(timeout)
+	}
+
+	return nil, fmt.Errorf("unsupported backend protocol %s", b.Protocol)
+}
+
+// Local returns true if socks5lb connects the destinations by itself for the
+// backend, there is no socks5 service of the backend to pass through
+func (b *Backend) Local() bool {
+	return b.Protocol == ProtocolDirect || b.Protocol == ProtocolWireGuard
+}
+
+// localOnlyOption returns the error of the option only allowed in the local configuration file, nil if none
+func (b *Backend) localOnlyOption() error {
+	if b.Command.configured() {
+		return errCommandNotLocal
+	}
+
+	if b.HTTP.PasswordFile != "" {
+		return errPasswordFileNotLocal
+	}
+
+	// the negotiate auth without the password logs in by the credential cache of this host
+	if b.HTTP.Keytab != "" || b.HTTP.CCache != "" || b.HTTP.Krb5Conf != "" ||
+		(b.HTTP.Auth == HTTPAuthNegotiate && b.Password == "") {
+		return errKerberosNotLocal
+	}
+
+	return nil
+}
+
+// Socks5 returns true if the backend exposes the socks5 service to pass through
+func (b *Backend) Socks5() bool {
+	return b.Protocol == "" || b.Protocol == ProtocolSocks5 || b.Protocol == ProtocolQUIC
+}
+
+// DialFor to connect the destination address through the backend for the user,
+// the direct backends connect it from their source address pool
+func (b *Backend) DialFor(user, network, addr string, timeout int) (net.Conn, error) {
+	if b.Protocol == ProtocolDirect {
+		return b.dialDirect(user, network, addr, timeout)
+	}
+
+	return b.Socks5Conn(network, addr, timeout)
+}
+
 // Socks5Conn to create a connection by specific params
 func (b *Backend) Socks5Conn(network, addr string, timeout int) (cc net.Conn, err error) {
+	switch b.Protocol {
+	case ProtocolDirect:
+		return b.dialDirect("", network, addr, timeout)
+	case ProtocolWireGuard:
+		return b.dialWireGuard(network, addr, timeout)
+	case ProtocolHTTP:
+		return b.dialHTTP(network, addr, timeout)
+	}
+
+	// the handshake deadline is cleared for the long-lived sessions, and the
+	// destination is resolved by the backend, not the local dns
+	if b.Protocol == ProtocolQUIC || network == "tcp" || network == "tcp4" || network == "tcp6" {
+		if cc, err = b.Dial(timeout); err != nil {
+			return
+		}
+
+		if timeout > 0 {
+			_ = cc.SetDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+		}
+
+		if err = socks5Handshake(cc, b.UserName, b.Password, addr); err != nil {
+			cc.Close()
+			return nil, err
+		}
+
+		return cc, cc.SetDeadline(time.Time{})
+	}
+
 	client, err := b.socks5Client(timeout)
 	if err != nil {
 		return
@@ -1,7 +1,13 @@
 package socks5lb
 
 import (
+	"bufio"
+	"io"
+	"net"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestBackend_Check(t *testing.T) {
@@ -15,3 +21,38 @@ func TestBackend_Check(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestBackend_Socks5ConnIdle(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	backend := NewBackend(NewTestSocks5Server(t), BackendCheckConfig{Timeout: 1})
+	conn, err := backend.Socks5Conn("tcp", echo.Addr().String(), backend.Timeout())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// the session outlives the handshake timeout
+	reader := bufio.NewReader(conn)
+	for i, line := range []string{"before\n", "after\n"} {
+		if i > 0 {
+			time.Sleep(1500 * time.Millisecond)
+		}
+
+		_, err = conn.Write([]byte(line))
+		assert.NoError(t, err)
+
+		received, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, line, received)
+	}
+}
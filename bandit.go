@@ -0,0 +1,222 @@
+/**
+ * File: bandit.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Saturday, October 17th 2026, 5:12:30 pm
+ * Last Modified: Saturday, October 17th 2026, 5:12:30 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"container/list"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/publicsuffix"
+)
+
+const (
+	BanditKeyDomain = "domain"
+	BanditKeyETLD1  = "etld1"
+
+	defaultBanditEpsilon         = 0.1
+	defaultBanditMaxDestinations = 1024
+	banditRewardAlpha            = 0.2
+)
+
+type BanditConfig struct {
+	Enable bool `yaml:"enable"`
+	// Key is how to group the destinations, "domain" or "etld1"
+	Key string `yaml:"key"`
+	// Epsilon is the probability to explore by the normal strategy
+	Epsilon         float64 `yaml:"epsilon"`
+	MaxDestinations int     `yaml:"max_destinations"`
+}
+
+// BanditArm is the learned performance of a backend for a destination
+type BanditArm struct {
+	Pulls     uint64        `json:"pulls"`
+	Successes uint64        `json:"successes"`
+	Latency   time.Duration `json:"latency"`
+	Reward    float64       `json:"reward"`
+}
+
+type banditDestination struct {
+	key  string
+	arms map[string]*BanditArm
+	elem *list.Element
+	seen time.Time
+}
+
+// Bandit learns the best backend for each destination by an epsilon-greedy policy
+type Bandit struct {
+	config BanditConfig
+
+	lock         sync.Mutex
+	destinations map[string]*banditDestination
+	lru          *list.List
+}
+
+// destinationKey returns the host of the address, or its eTLD+1 if required
+func destinationKey(addr string, etld1 bool) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if etld1 && net.ParseIP(host) == nil {
+		if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+			return domain
+		}
+	}
+
+	return host
+}
+
+// Key returns the destination key of the address, like the eTLD+1 of the host
+func (b *Bandit) Key(addr string) string {
+	return destinationKey(addr, b.config.Key == BanditKeyETLD1)
+}
+
+// Select returns the learned best backend for the destination, returns nil to
+// fall back to the normal strategy for the unseen destinations or exploration
+func (b *Bandit) Select(addr string, backends []*Backend) (best *Backend) {
+	key := b.Key(addr)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	dest := b.destinations[key]
+	if dest == nil || rand.Float64() < b.config.Epsilon {
+		return nil
+	}
+	b.lru.MoveToFront(dest.elem)
+
+	var bestReward float64
+	for _, backend := range backends {
+		if arm := dest.arms[backend.Addr]; arm != nil && (best == nil || arm.Reward > bestReward) {
+			best, bestReward = backend, arm.Reward
+		}
+	}
+
+	// the backends never succeeded for the destination are left to the normal strategy
+	if bestReward == 0 {
+		return nil
+	}
+
+	log.Tracef("[bandit] select %s for %s, reward %.3f", best.Addr, key, bestReward)
+	return
+}
+
+// Observe to learn from the result of a connection to the destination by the backend
+func (b *Bandit) Observe(addr string, backend *Backend, latency time.Duration, err error) {
+	key := b.Key(addr)
+
+	var reward float64
+	if err == nil {
+		reward = 1 / (1 + latency.Seconds())
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	dest := b.destinations[key]
+	if dest == nil {
+		dest = &banditDestination{key: key, arms: make(map[string]*BanditArm)}
+		dest.elem = b.lru.PushFront(dest)
+		b.destinations[key] = dest
+
+		// forget the least recently used destinations to bound the memory
+		for b.lru.Len() > b.config.MaxDestinations {
+			oldest := b.lru.Back().Value.(*banditDestination)
+			b.lru.Remove(oldest.elem)
+			delete(b.destinations, oldest.key)
+		}
+	} else {
+		b.lru.MoveToFront(dest.elem)
+	}
+	dest.seen = time.Now()
+
+	arm := dest.arms[backend.Addr]
+	if arm == nil {
+		arm = &BanditArm{Reward: reward, Latency: latency}
+		dest.arms[backend.Addr] = arm
+	} else {
+		arm.Reward += banditRewardAlpha * (reward - arm.Reward)
+	}
+
+	arm.Pulls++
+	if err == nil {
+		arm.Successes++
+		arm.Latency += time.Duration(banditRewardAlpha * float64(latency-arm.Latency))
+	}
+}
+
+// Expire to forget the destinations not seen since the time
+func (b *Bandit) Expire(before time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// the least recently used destinations are at the back
+	for b.lru.Len() > 0 {
+		oldest := b.lru.Back().Value.(*banditDestination)
+		if !oldest.seen.Before(before) {
+			break
+		}
+
+		b.lru.Remove(oldest.elem)
+		delete(b.destinations, oldest.key)
+	}
+}
+
+// Forget to remove the learned arms of the backend, like it is removed from the pool
+func (b *Bandit) Forget(addr string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, dest := range b.destinations {
+		delete(dest.arms, addr)
+	}
+}
+
+// Snapshot returns the learned arms of all destinations
+func (b *Bandit) Snapshot() map[string]map[string]BanditArm {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	snapshot := make(map[string]map[string]BanditArm, len(b.destinations))
+	for key, dest := range b.destinations {
+		arms := make(map[string]BanditArm, len(dest.arms))
+		for addr, arm := range dest.arms {
+			arms[addr] = *arm
+		}
+		snapshot[key] = arms
+	}
+
+	return snapshot
+}
+
+// NewBandit returns a new bandit by the configuration
+func NewBandit(config BanditConfig) *Bandit {
+	if config.Epsilon <= 0 || config.Epsilon > 1 {
+		config.Epsilon = defaultBanditEpsilon
+	}
+
+	if config.MaxDestinations <= 0 {
+		config.MaxDestinations = defaultBanditMaxDestinations
+	}
+
+	return &Bandit{
+		config:       config,
+		destinations: make(map[string]*banditDestination),
+		lru:          list.New(),
+	}
+}
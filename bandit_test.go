@@ -0,0 +1,51 @@
+package socks5lb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBandit_Key(t *testing.T) {
+	bandit := NewBandit(BanditConfig{Key: BanditKeyETLD1})
+	assert.Equal(t, "example.co.uk", bandit.Key("www.Example.co.uk:443"))
+	assert.Equal(t, "1.2.3.4", bandit.Key("1.2.3.4:80"))
+
+	bandit = NewBandit(BanditConfig{Key: BanditKeyDomain})
+	assert.Equal(t, "www.example.co.uk", bandit.Key("www.example.co.uk:443"))
+}
+
+func TestBandit_SelectAndObserve(t *testing.T) {
+	bandit := NewBandit(BanditConfig{Key: BanditKeyETLD1, Epsilon: 1e-9, MaxDestinations: 2})
+	fast := NewBackend("10.30.0.1:1086", BackendCheckConfig{InitialAlive: true})
+	slow := NewBackend("10.30.0.2:1086", BackendCheckConfig{InitialAlive: true})
+	broken := NewBackend("10.30.0.3:1086", BackendCheckConfig{InitialAlive: true})
+	backends := []*Backend{fast, slow, broken}
+
+	// unseen destinations fall back to the normal strategy
+	assert.Nil(t, bandit.Select("www.example.com:443", backends))
+
+	for i := 0; i < 5; i++ {
+		bandit.Observe("www.example.com:443", fast, 50*time.Millisecond, nil)
+		bandit.Observe("img.example.com:443", slow, 2*time.Second, nil)
+		bandit.Observe("api.example.com:443", broken, time.Millisecond, errors.New("refused"))
+	}
+	assert.Equal(t, fast, bandit.Select("cdn.example.com:443", backends))
+
+	// the learned backend is unavailable now
+	assert.Equal(t, slow, bandit.Select("cdn.example.com:443", []*Backend{slow, broken}))
+
+	// only the failures are learned, it falls back to the normal strategy
+	assert.Nil(t, bandit.Select("cdn.example.com:443", []*Backend{broken}))
+
+	// the least recently used destination is forgotten
+	bandit.Observe("example.org:80", fast, time.Millisecond, nil)
+	bandit.Observe("example.net:80", fast, time.Millisecond, nil)
+	assert.Len(t, bandit.Snapshot(), 2)
+	assert.Nil(t, bandit.Select("example.com:443", backends))
+
+	bandit.Forget(fast.Addr)
+	assert.Nil(t, bandit.Select("example.net:80", backends))
+}
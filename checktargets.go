@@ -0,0 +1,333 @@
+/**
+ * File: checktargets.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Sunday, October 18th 2026, 2:20:13 pm
+ * Last Modified: Sunday, October 18th 2026, 2:20:13 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultCheckTargets         = 5
+	defaultCheckTargetBudget    = 2
+	defaultCheckTargetThreshold = 2
+	defaultCheckTargetTimeout   = 5
+	checkTargetsMaxDestinations = 1024
+)
+
+type AdaptiveCheckConfig struct {
+	Enable bool `yaml:"enable"`
+	// Targets is the number of the most accessed destinations to check
+	Targets int `yaml:"targets"`
+	// Budget is the number of the targets checked for each backend in a round
+	Budget int `yaml:"budget"`
+	// Threshold is the number of the failed targets to mark the backend down,
+	// only if none of the targets is reachable through the backend
+	Threshold int `yaml:"threshold"`
+}
+
+// TargetResult is the last check result of a target through a backend
+type TargetResult struct {
+	Target   string        `json:"target"`
+	Time     time.Time     `json:"time"`
+	Alive    bool          `json:"alive"`
+	Latency  time.Duration `json:"latency"`
+	Failures uint          `json:"failures"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// CheckTarget is a destination learned from the traffic with its access count
+type CheckTarget struct {
+	Target string  `json:"target"`
+	Count  float64 `json:"count"`
+}
+
+// CheckTargets learns the most accessed destinations as the extra health check targets
+type CheckTargets struct {
+	config AdaptiveCheckConfig
+
+	lock    sync.Mutex
+	counts  map[string]float64
+	seen    map[string]time.Time
+	cursors map[string]int
+	results map[string]map[string]*TargetResult
+}
+
+// Record to count a session to the destination address
+func (t *CheckTargets) Record(addr string) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return
+	}
+	addr = strings.ToLower(addr)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if _, ok := t.counts[addr]; !ok && len(t.counts) >= checkTargetsMaxDestinations {
+		// forget the least accessed destination to bound the memory
+		var least string
+		for target, count := range t.counts {
+			if least == "" || count < t.counts[least] {
+				least = target
+			}
+		}
+		t.forgetLocked(least)
+	}
+
+	t.counts[addr]++
+	t.seen[addr] = time.Now()
+}
+
+// Expire to forget the destinations not accessed since the time, and their results
+func (t *CheckTargets) Expire(before time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for target, seen := range t.seen {
+		if seen.Before(before) {
+			t.forgetLocked(target)
+		}
+	}
+}
+
+// forgetLocked to remove the destination and its check results, the lock should be held
+func (t *CheckTargets) forgetLocked(target string) {
+	delete(t.counts, target)
+	delete(t.seen, target)
+	for _, results := range t.results {
+		delete(results, target)
+	}
+}
+
+// Decay to halve the access counts, so the recent sessions weigh more
+func (t *CheckTargets) Decay() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for target, count := range t.counts {
+		if count /= 2; count < 0.5 {
+			t.forgetLocked(target)
+		} else {
+			t.counts[target] = count
+		}
+	}
+}
+
+// topLocked returns the most accessed destinations
+func (t *CheckTargets) topLocked() (targets []CheckTarget) {
+	for target, count := range t.counts {
+		targets = append(targets, CheckTarget{Target: target, Count: count})
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].Count != targets[j].Count {
+			return targets[i].Count > targets[j].Count
+		}
+		return targets[i].Target < targets[j].Target
+	})
+
+	if len(targets) > t.config.Targets {
+		targets = targets[:t.config.Targets]
+	}
+
+	return
+}
+
+// Top returns the destinations to check, the most accessed first
+func (t *CheckTargets) Top() []CheckTarget {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.topLocked()
+}
+
+// Next returns the targets to check for the backend in this round, rotating
+// through the most accessed destinations within the budget
+func (t *CheckTargets) Next(backend string) (targets []string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	top := t.topLocked()
+	for i := 0; i < t.config.Budget && i < len(top); i++ {
+		targets = append(targets, top[(t.cursors[backend]+i)%len(top)].Target)
+	}
+
+	if len(top) > 0 {
+		t.cursors[backend] = (t.cursors[backend] + len(targets)) % len(top)
+	}
+
+	return
+}
+
+// Report to record the check result of the target through the backend
+func (t *CheckTargets) Report(backend, target string, latency time.Duration, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.results[backend] == nil {
+		t.results[backend] = make(map[string]*TargetResult)
+	}
+
+	result := t.results[backend][target]
+	if result == nil {
+		result = &TargetResult{Target: target}
+		t.results[backend][target] = result
+	}
+
+	result.Time, result.Alive, result.Latency, result.Error = time.Now(), err == nil, latency, ""
+	if err != nil {
+		result.Failures++
+		result.Error = err.Error()
+	} else {
+		result.Failures = 0
+	}
+}
+
+// Failing returns true if the last check of the destination through the backend is failed
+func (t *CheckTargets) Failing(backend, addr string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	result := t.results[backend][strings.ToLower(addr)]
+	return result != nil && !result.Alive
+}
+
+// Filter returns the backends which are not failing for the destination
+func (t *CheckTargets) Filter(addr string, backends []*Backend) (filtered []*Backend) {
+	for _, backend := range backends {
+		if !t.Failing(backend.Addr, addr) {
+			filtered = append(filtered, backend)
+		}
+	}
+
+	return
+}
+
+// Unreachable returns true if enough targets are failing and none of them is
+// reachable through the backend, a single blocked site never ejects it
+func (t *CheckTargets) Unreachable(backend string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var failed int
+	for _, result := range t.results[backend] {
+		if result.Alive {
+			return false
+		}
+		failed++
+	}
+
+	return failed >= t.config.Threshold
+}
+
+// Results returns the check results of the targets for each backend
+func (t *CheckTargets) Results() map[string][]TargetResult {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	results := make(map[string][]TargetResult, len(t.results))
+	for backend, targets := range t.results {
+		for _, result := range targets {
+			results[backend] = append(results[backend], *result)
+		}
+
+		sort.Slice(results[backend], func(i, j int) bool {
+			return results[backend][i].Target < results[backend][j].Target
+		})
+	}
+
+	return results
+}
+
+// Forget to remove the check results of the backend, like it is removed from the pool
+func (t *CheckTargets) Forget(backend string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.results, backend)
+	delete(t.cursors, backend)
+}
+
+// CheckTarget to check the destination is reachable through the backend,
+// also finish the tls handshake if it is a https destination
+func (b *Backend) CheckTarget(target string) (err error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return
+	}
+
+	timeout := b.Timeout()
+	if timeout <= 0 {
+		timeout = defaultCheckTargetTimeout
+	}
+
+	conn, err := b.Socks5Conn("tcp", target, timeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if port != "443" {
+		return
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+	if err = tls.Client(conn, &tls.Config{ServerName: host}).Handshake(); err != nil {
+		return fmt.Errorf("tls handshake with %s failed, %v", target, err)
+	}
+
+	return
+}
+
+// checkTargets to check the learned targets through the backend within the budget
+func (t *CheckTargets) checkTargets(backend *Backend) {
+	for _, target := range t.Next(backend.Addr) {
+		start := time.Now()
+		err := backend.CheckTarget(target)
+		t.Report(backend.Addr, target, time.Since(start), err)
+
+		if err != nil {
+			log.Debugf("check target %s through backend %s is failed, %v", target, backend.Addr, err)
+		} else {
+			log.Tracef("check target %s through backend %s is successful", target, backend.Addr)
+		}
+	}
+}
+
+// NewCheckTargets returns the adaptive check targets by the configuration
+func NewCheckTargets(config AdaptiveCheckConfig) *CheckTargets {
+	if config.Targets <= 0 {
+		config.Targets = defaultCheckTargets
+	}
+
+	if config.Budget <= 0 {
+		config.Budget = defaultCheckTargetBudget
+	}
+
+	if config.Threshold <= 0 {
+		config.Threshold = defaultCheckTargetThreshold
+	}
+
+	return &CheckTargets{
+		config:  config,
+		counts:  make(map[string]float64),
+		seen:    make(map[string]time.Time),
+		cursors: make(map[string]int),
+		results: make(map[string]map[string]*TargetResult),
+	}
+}
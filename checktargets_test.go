@@ -0,0 +1,99 @@
+package socks5lb
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckTargets_Next(t *testing.T) {
+	targets := NewCheckTargets(AdaptiveCheckConfig{Targets: 3, Budget: 2})
+
+	for i, addr := range []string{"a.com:443", "b.com:443", "c.com:443", "d.com:443"} {
+		for j := 0; j < 4-i; j++ {
+			targets.Record(addr)
+		}
+	}
+	targets.Record("not-an-address")
+
+	top := targets.Top()
+	assert.Len(t, top, 3)
+	assert.Equal(t, "a.com:443", top[0].Target)
+
+	// rotate through the top targets within the budget
+	assert.Equal(t, []string{"a.com:443", "b.com:443"}, targets.Next("backend"))
+	assert.Equal(t, []string{"c.com:443", "a.com:443"}, targets.Next("backend"))
+	assert.Equal(t, []string{"a.com:443", "b.com:443"}, targets.Next("other"))
+
+	targets.Report("backend", "a.com:443", time.Second, errors.New("reset"))
+	targets.Report("backend", "d.com:443", time.Second, errors.New("reset"))
+
+	// the rarely accessed destinations are forgotten, with their results
+	for i := 0; i < 3; i++ {
+		targets.Decay()
+	}
+	assert.Len(t, targets.Top(), 1)
+	if assert.Len(t, targets.Results()["backend"], 1) {
+		assert.Equal(t, "a.com:443", targets.Results()["backend"][0].Target)
+	}
+}
+
+func TestCheckTargets_Attribution(t *testing.T) {
+	targets := NewCheckTargets(AdaptiveCheckConfig{Threshold: 2})
+	healthy := NewBackend("10.50.0.1:1086", BackendCheckConfig{InitialAlive: true})
+	blocked := NewBackend("10.50.0.2:1086", BackendCheckConfig{InitialAlive: true})
+
+	// a single blocked site does not eject the backend
+	targets.Report(blocked.Addr, "a.com:443", time.Second, errors.New("reset"))
+	assert.False(t, targets.Unreachable(blocked.Addr))
+	assert.True(t, targets.Failing(blocked.Addr, "A.com:443"))
+	assert.Equal(t, []*Backend{healthy}, targets.Filter("a.com:443", []*Backend{healthy, blocked}))
+	assert.Len(t, targets.Filter("b.com:443", []*Backend{healthy, blocked}), 2)
+
+	targets.Report(blocked.Addr, "b.com:443", time.Second, errors.New("reset"))
+	assert.True(t, targets.Unreachable(blocked.Addr))
+
+	targets.Report(blocked.Addr, "b.com:443", time.Millisecond, nil)
+	assert.False(t, targets.Unreachable(blocked.Addr))
+	assert.Len(t, targets.Results()[blocked.Addr], 2)
+
+	targets.Forget(blocked.Addr)
+	assert.Empty(t, targets.Results())
+}
+
+func TestPool_CheckTargets(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	pool := &Pool{backends: make(map[string]*Backend)}
+	backend := NewBackend(NewTestSocks5Server(t), BackendCheckConfig{InitialAlive: true, Timeout: 3})
+	broken := NewBackend(FreeAddr(t), BackendCheckConfig{InitialAlive: true, Timeout: 3})
+	assert.NoError(t, pool.Add(backend))
+	assert.NoError(t, pool.Add(broken))
+
+	targets := NewCheckTargets(AdaptiveCheckConfig{Threshold: 1})
+	pool.SetCheckTargets(targets)
+
+	addr := strings.TrimPrefix(target.URL, "http://")
+	_, port, _ := net.SplitHostPort(addr)
+	pool.Observe(addr, backend, time.Millisecond, nil)
+	pool.Check()
+
+	assert.True(t, backend.Alive())
+	assert.False(t, broken.Alive())
+
+	// the ejection amends the result of the check round
+	if history := broken.History(); assert.Len(t, history, 1) {
+		assert.False(t, history[0].Alive)
+		assert.NotEmpty(t, history[0].Error)
+	}
+	assert.False(t, targets.Failing(backend.Addr, "127.0.0.1:"+port))
+	assert.True(t, targets.Failing(broken.Addr, "127.0.0.1:"+port))
+	assert.Equal(t, backend, pool.NextFor(addr))
+}
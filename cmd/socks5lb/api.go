@@ -0,0 +1,115 @@
+/**
+ * File: api.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Saturday, October 17th 2026, 6:20:41 am
+ * Last Modified: Saturday, October 17th 2026, 6:20:41 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"time"
+)
+
+// passwordEnv is the environment variable of the password to login the admin API
+const passwordEnv = "SOCKS5LB_PASSWORD"
+
+// errUnauthorized is the admin API of the running instance refusing the request,
+// the subcommands should not fall back to the local checks silently
+var errUnauthorized = errors.New("the admin API refused the request")
+
+// apiCredentials are the account to login the admin API if the auth is enabled
+type apiCredentials struct {
+	username string
+	otp      string
+}
+
+// bind to add the login flags to the subcommand, the password is read from the environment
+func (a *apiCredentials) bind(flags *flag.FlagSet) {
+	flags.StringVar(&a.username, "u", "", "username to login the admin API, the password is read from $"+passwordEnv)
+	flags.StringVar(&a.otp, "otp", "", "one-time password to login the admin API")
+}
+
+// apiClient requests the admin API of the running instance
+type apiClient struct {
+	base   string
+	client *http.Client
+}
+
+// newAPIClient returns the client of the admin API on the address, and logins if the username is given
+func newAPIClient(addr string, credentials apiCredentials, timeout time.Duration) (api *apiClient, err error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return
+	}
+
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return
+	}
+
+	api = &apiClient{
+		base:   "http://" + net.JoinHostPort(host, port),
+		client: &http.Client{Timeout: timeout, Jar: jar},
+	}
+
+	if credentials.username == "" {
+		return
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"username": credentials.username,
+		"password": os.Getenv(passwordEnv),
+		"otp":      credentials.otp,
+	})
+	if err != nil {
+		return
+	}
+
+	resp, err := api.client.Post(api.base+"/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w, login as %s failed with status code %d", errUnauthorized, credentials.username, resp.StatusCode)
+	}
+
+	return
+}
+
+// get to request the path, the caller should close the body of the response
+func (a *apiClient) get(path string) (*http.Response, error) {
+	resp, err := a.client.Get(a.base + path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%w with status code %d, login by -u with the password in $%s", errUnauthorized, resp.StatusCode, passwordEnv)
+	}
+
+	_ = resp.Body.Close()
+	return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+}
@@ -0,0 +1,103 @@
+/**
+ * File: diag.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Saturday, October 17th 2026, 3:18:27 pm
+ * Last Modified: Saturday, October 17th 2026, 3:18:27 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mingcheng/socks5lb"
+	log "github.com/sirupsen/logrus"
+)
+
+// fetchDiagnostics to download the diagnostic bundle from the running instance
+func fetchDiagnostics(addr string, credentials apiCredentials, w io.Writer) (err error) {
+	api, err := newAPIClient(addr, credentials, 30*time.Second)
+	if err != nil {
+		return
+	}
+
+	resp, err := api.get("/api/diagnostics")
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return
+}
+
+// runDiag to write the diagnostic bundle, from the running instance if possible
+func runDiag(args []string) (err error) {
+	var (
+		path        string
+		output      string
+		credentials apiCredentials
+	)
+
+	flags := flag.NewFlagSet("diag", flag.ExitOnError)
+	flags.StringVar(&path, "c", cfgPath, "configure file cfgPath")
+	flags.StringVar(&output, "o", fmt.Sprintf("%s-diag-%s.tar.gz", socks5lb.AppName, time.Now().Format("20060102150405")), "output file")
+	credentials.bind(flags)
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	config, err := NewConfig(path)
+	if err != nil {
+		return
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	if addr := config.ServerConfig.HTTP.Addr; addr != "" {
+		if err = fetchDiagnostics(addr, credentials, file); err == nil {
+			fmt.Printf("the diagnostic bundle is saved to %s\n", output)
+			return
+		}
+
+		// the instance is running, but the bundle is not allowed to download
+		if errors.Is(err, errUnauthorized) {
+			_ = file.Close()
+			_ = os.Remove(output)
+			return
+		}
+
+		log.Warnf("fetch the diagnostic bundle from %s failed, %v, fallback to the local one", addr, err)
+		if err = file.Truncate(0); err != nil {
+			return
+		}
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			return
+		}
+	}
+
+	// the instance is not running, only the configuration and the runtime information are available
+	server, err := socks5lb.NewServer(newPool(config), config.ServerConfig)
+	if err != nil {
+		return
+	}
+
+	if err = server.WriteDiagnostics(file); err != nil {
+		return
+	}
+
+	fmt.Printf("the local diagnostic bundle is saved to %s\n", output)
+	return
+}
@@ -17,7 +17,6 @@ import (
 	"github.com/judwhite/go-svc"
 	"github.com/mingcheng/socks5lb"
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v3"
 
 	"os"
 )
@@ -50,15 +49,28 @@ func NewConfig(path string) (config *socks5lb.Configure, err error) {
 		return
 	}
 
-	if err = yaml.Unmarshal(data, &config); err != nil {
-		return
-	}
+	return socks5lb.ParseConfigure(data)
+}
 
-	return
+// commands are the subcommands, like `socks5lb diag -c config.yml`
+var commands = map[string]func(args []string) error{
+	"diag":          runDiag,
+	"sandbox-check": runSandboxCheck,
+	"selftest":      runSelfTest,
 }
 
 func main() {
 	log.Infof("%s v%s(%s), build on %s", socks5lb.AppName, socks5lb.Version, socks5lb.BuildCommit, socks5lb.BuildDate)
+
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	flag.Parse()
 
 	// read the config if err != nil
@@ -23,19 +23,27 @@ type program struct {
 	Server *socks5lb.Server
 }
 
-// Init to initial the program
-func (p *program) Init(svc.Environment) (err error) {
-
+// newPool returns the backend pool by the configuration
+func newPool(config *socks5lb.Configure) *socks5lb.Pool {
 	log.Tracef("new initial backend pools")
 	pool := socks5lb.NewPool()
 
-	for _, v := range p.Config.Backends {
+	for _, v := range config.Backends {
 		log.Tracef("add backend %s", v.Addr)
 		backend := socks5lb.NewBackend(v.Addr, v.CheckConfig)
+		backend.UserName, backend.Password = v.UserName, v.Password
+		backend.Labels, backend.Weight = v.Labels, v.Weight
+		backend.Protocol, backend.QUIC, backend.Egress = v.Protocol, v.QUIC, v.Egress
+		backend.WireGuard, backend.Command, backend.HTTP = v.WireGuard, v.Command, v.HTTP
 		_ = pool.Add(backend)
 	}
 
-	p.Server, err = socks5lb.NewServer(pool, p.Config.ServerConfig)
+	return pool
+}
+
+// Init to initial the program
+func (p *program) Init(svc.Environment) (err error) {
+	p.Server, err = socks5lb.NewServer(newPool(p.Config), p.Config.ServerConfig)
 
 	return
 }
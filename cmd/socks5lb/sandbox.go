@@ -0,0 +1,102 @@
+/**
+ * File: sandbox.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Sunday, October 18th 2026, 5:02:16 pm
+ * Last Modified: Sunday, October 18th 2026, 5:02:16 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/mingcheng/socks5lb"
+	log "github.com/sirupsen/logrus"
+)
+
+// fetchSandbox to get the sandbox state of the running instance
+func fetchSandbox(addr string, credentials apiCredentials) (status socks5lb.SandboxStatus, err error) {
+	api, err := newAPIClient(addr, credentials, 10*time.Second)
+	if err != nil {
+		return
+	}
+
+	resp, err := api.get("/api/sandbox")
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	return
+}
+
+// printSandbox to print the sandbox state in a human readable way
+func printSandbox(name string, status socks5lb.SandboxStatus) {
+	switch {
+	case status.Active:
+		fmt.Printf("%s: sandbox is active, %d syscalls are allowed, action %s\n", name, status.Syscalls, status.Action)
+	case status.Error != "":
+		fmt.Printf("%s: sandbox is not active, %s\n", name, status.Error)
+	case !status.Enabled:
+		fmt.Printf("%s: sandbox is not enabled\n", name)
+	default:
+		fmt.Printf("%s: sandbox is not active\n", name)
+	}
+}
+
+// runSandboxCheck to report whether the sandbox of the running instance is active,
+// or whether the sandbox can be installed on this host if it is not running
+func runSandboxCheck(args []string) (err error) {
+	var (
+		path        string
+		credentials apiCredentials
+	)
+
+	flags := flag.NewFlagSet("sandbox-check", flag.ExitOnError)
+	flags.StringVar(&path, "c", cfgPath, "configure file cfgPath")
+	credentials.bind(flags)
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	config, err := NewConfig(path)
+	if err != nil {
+		return
+	}
+
+	if addr := config.ServerConfig.HTTP.Addr; addr != "" {
+		status, err := fetchSandbox(addr, credentials)
+		if err == nil {
+			printSandbox("running instance", status)
+			if !status.Active {
+				return errors.New("the sandbox of the running instance is not active")
+			}
+			return nil
+		}
+
+		// checking this host instead tells nothing about the running instance
+		if errors.Is(err, errUnauthorized) {
+			return err
+		}
+
+		log.Warnf("fetch the sandbox state from %s failed, %v, check this host instead", addr, err)
+	}
+
+	// install the sandbox into this process to check it is supported by the kernel
+	_ = socks5lb.InstallSandbox(&config.ServerConfig)
+	status := socks5lb.Sandbox()
+	printSandbox("this host", status)
+	if !status.Active {
+		return errors.New("the sandbox is not supported on this host")
+	}
+
+	return
+}
@@ -0,0 +1,92 @@
+/**
+ * File: selftest.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Tuesday, October 20th 2026, 4:05:51 pm
+ * Last Modified: Tuesday, October 20th 2026, 4:05:51 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mingcheng/socks5lb"
+)
+
+// printSelfTest to print the self-test report in a human readable way, returns the number of the failures
+func printSelfTest(results []socks5lb.SelfTestResult) (failed int) {
+	for _, result := range results {
+		switch {
+		case result.Skipped:
+			fmt.Printf("[SKIP] %s: %s\n", result.Name, result.Detail)
+		case result.Passed:
+			fmt.Printf("[PASS] %s\n", result.Name)
+		default:
+			failed++
+			fmt.Printf("[FAIL] %s: %s\n", result.Name, result.Detail)
+			if result.Fix != "" {
+				fmt.Printf("       fix: %s\n", result.Fix)
+			}
+		}
+	}
+
+	fmt.Printf("\n%d checks, %d failed\n", len(results), failed)
+	return
+}
+
+// runSelfTest to check the prerequisites of the configuration on this host
+func runSelfTest(args []string) (err error) {
+	var (
+		path    string
+		jsonOut bool
+	)
+
+	flags := flag.NewFlagSet("selftest", flag.ExitOnError)
+	flags.StringVar(&path, "c", cfgPath, "configure file cfgPath")
+	flags.BoolVar(&jsonOut, "json", false, "print the report in json")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	// the configuration is validated by the self-test, report the errors instead of exiting
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	config, err := socks5lb.ParseConfigure(data)
+	if err != nil {
+		fmt.Printf("[FAIL] configuration: %v\n       fix: fix the configuration file %s\n", err, path)
+		return fmt.Errorf("self-test failed")
+	}
+
+	results := socks5lb.SelfTest(config)
+	failed := 0
+	if jsonOut {
+		for _, result := range results {
+			if !result.Passed {
+				failed++
+			}
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(results); err != nil {
+			return
+		}
+	} else {
+		failed = printSelfTest(results)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("self-test failed, %d checks are failed", failed)
+	}
+
+	return
+}
@@ -0,0 +1,246 @@
+/**
+ * File: command.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Wednesday, October 21st 2026, 2:31:56 pm
+ * Last Modified: Wednesday, October 21st 2026, 2:31:56 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultCommandMaxBackoff = 60
+	// commandStableDuration resets the backoff if the process runs longer than it
+	commandStableDuration = 30 * time.Second
+	// commandStopTimeout is how long to wait for the process to exit before killing it
+	commandStopTimeout = 5 * time.Second
+	// maxCommandLogs is the number of the output lines kept for each process
+	maxCommandLogs = 200
+)
+
+// errCommandNotRunning is the check error of the backends whose helper process is not running
+var errCommandNotRunning = errors.New("the helper process is not running")
+
+// errCommandNotLocal is the helper process of a backend from the API or the remote
+// configuration, which could run any program on this host
+var errCommandNotLocal = errors.New("the helper process is only allowed in the local configuration file")
+
+// configured returns true if any field of the helper process is set
+func (c BackendCommandConfig) configured() bool {
+	return len(c.Args) > 0 || len(c.Env) > 0 || c.Dir != ""
+}
+
+type BackendCommandConfig struct {
+	// Args are the helper process and its arguments, which exposes the socks5 service
+	// on the backend address, like ["ssh", "-N", "-D", "127.0.0.1:1081", "user@host"]
+	Args []string `yaml:"args" json:"args"`
+	Env  []string `yaml:"env" json:"env"`
+	Dir  string   `yaml:"dir" json:"dir"`
+	// MaxBackoff is the max delay to restart the exited process in seconds, 60 by default
+	MaxBackoff uint `yaml:"max_backoff" json:"max_backoff"`
+}
+
+// CommandStatus is the state of the helper process of the backend
+type CommandStatus struct {
+	Addr      string    `json:"addr"`
+	Running   bool      `json:"running"`
+	PID       int       `json:"pid,omitempty"`
+	Restarts  uint      `json:"restarts"`
+	StartedAt time.Time `json:"started_at,omitempty"`
+	ExitedAt  time.Time `json:"exited_at,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Logs      []string  `json:"logs"`
+}
+
+// commandProcess supervises the helper process of a backend
+type commandProcess struct {
+	lock   sync.Mutex
+	status CommandStatus
+	cancel context.CancelFunc
+	done   chan struct{}
+	buf    []byte
+}
+
+var commandsLock sync.Mutex
+
+// Write to capture the output of the process line by line
+func (p *commandProcess) Write(data []byte) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.buf = append(p.buf, data...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := string(bytes.TrimRight(p.buf[:i], "\r"))
+		p.buf = p.buf[i+1:]
+
+		log.Debugf("[command] %s: %s", p.status.Addr, line)
+		p.status.Logs = append(p.status.Logs, line)
+		if len(p.status.Logs) > maxCommandLogs {
+			p.status.Logs = p.status.Logs[len(p.status.Logs)-maxCommandLogs:]
+		}
+	}
+
+	return len(data), nil
+}
+
+// run to start the process and wait for its exit
+func (p *commandProcess) run(ctx context.Context, config BackendCommandConfig) (err error) {
+	cmd := exec.CommandContext(ctx, config.Args[0], config.Args[1:]...)
+	cmd.Env = append(os.Environ(), config.Env...)
+	cmd.Dir = config.Dir
+	cmd.Stdout, cmd.Stderr = p, p
+
+	// ask the process to exit gracefully, then kill it after the timeout
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = commandStopTimeout
+
+	if err = cmd.Start(); err == nil {
+		p.lock.Lock()
+		p.status.Running, p.status.PID, p.status.StartedAt = true, cmd.Process.Pid, time.Now()
+		p.lock.Unlock()
+
+		log.Infof("the helper process of backend %s is started, pid %d", p.status.Addr, cmd.Process.Pid)
+		err = cmd.Wait()
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.status.Running, p.status.PID, p.status.ExitedAt = false, 0, time.Now()
+	if err == nil {
+		err = errors.New("exited")
+	}
+	p.status.Error = err.Error()
+
+	return
+}
+
+// supervise to keep the process running, restart it with the backoff until stopped
+func (p *commandProcess) supervise(ctx context.Context, config BackendCommandConfig) {
+	defer close(p.done)
+
+	restart := backoff{
+		min:    time.Second,
+		max:    time.Duration(config.MaxBackoff) * time.Second,
+		stable: commandStableDuration,
+	}
+	if restart.max <= 0 {
+		restart.max = defaultCommandMaxBackoff * time.Second
+	}
+
+	for {
+		start := time.Now()
+		err := p.run(ctx, config)
+		if ctx.Err() != nil {
+			log.Infof("the helper process of backend %s is stopped", p.status.Addr)
+			return
+		}
+
+		delay, _ := restart.next(time.Since(start))
+		RecordEvent("command.exited", "the helper process of backend %s exited, %v, restart in %v", p.status.Addr, err, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		p.lock.Lock()
+		p.status.Restarts++
+		p.lock.Unlock()
+	}
+}
+
+// startCommand to start supervising the helper process of the backend, if any
+func (b *Backend) startCommand() {
+	commandsLock.Lock()
+	defer commandsLock.Unlock()
+
+	if len(b.Command.Args) == 0 || b.command != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.command = &commandProcess{
+		status: CommandStatus{Addr: b.Addr},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go b.command.supervise(ctx, b.Command)
+}
+
+// stopCommand to stop the helper process of the backend, returns the channel
+// closed after the process exited, nil if there is no process
+func (b *Backend) stopCommand() <-chan struct{} {
+	commandsLock.Lock()
+	defer commandsLock.Unlock()
+
+	if b.command == nil {
+		return nil
+	}
+
+	process := b.command
+	b.command = nil
+	process.cancel()
+
+	return process.done
+}
+
+// commandRunning returns false if the helper process of the backend is not running
+func (b *Backend) commandRunning() bool {
+	if len(b.Command.Args) == 0 {
+		return true
+	}
+
+	commandsLock.Lock()
+	process := b.command
+	commandsLock.Unlock()
+
+	if process == nil {
+		return false
+	}
+
+	process.lock.Lock()
+	defer process.lock.Unlock()
+
+	return process.status.Running
+}
+
+// CommandStatus returns the state of the helper process, nil if it is not started
+func (b *Backend) CommandStatus() *CommandStatus {
+	commandsLock.Lock()
+	process := b.command
+	commandsLock.Unlock()
+
+	if process == nil {
+		return nil
+	}
+
+	process.lock.Lock()
+	defer process.lock.Unlock()
+
+	status := process.status
+	status.Logs = append([]string{}, status.Logs...)
+	return &status
+}
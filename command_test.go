@@ -0,0 +1,78 @@
+package socks5lb
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/txthinking/socks5"
+)
+
+// TestCommandHelper is the helper process of TestBackend_Command, a socks5 server
+func TestCommandHelper(t *testing.T) {
+	addr := os.Getenv("SOCKS5LB_COMMAND_HELPER")
+	if addr == "" {
+		t.Skip("only for the helper process of the command backend")
+	}
+
+	server, err := socks5.NewClassicServer(addr, "127.0.0.1", "", "", 5, 5)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	fmt.Printf("the helper is listening on %s\n", addr)
+	_ = server.ListenAndServe(nil)
+}
+
+func TestBackend_Command(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+
+	addr := FreeAddr(t)
+	backend := NewBackend(addr, BackendCheckConfig{CheckURL: target.URL, InitialAlive: true, Timeout: 5})
+	backend.Command = BackendCommandConfig{
+		Args: []string{os.Args[0], "-test.run=^TestCommandHelper$"},
+		Env:  []string{"SOCKS5LB_COMMAND_HELPER=" + addr},
+	}
+
+	pool := &Pool{backends: make(map[string]*Backend)}
+	assert.NoError(t, pool.Add(backend))
+	assert.True(t, pool.HasCommands())
+
+	// the backend is down until the helper is started
+	assert.False(t, backend.Alive())
+	assert.ErrorIs(t, backend.Check(), errCommandNotRunning)
+
+	pool.StartCommands()
+	assert.Eventually(t, func() bool {
+		return backend.Check() == nil && backend.Alive()
+	}, 5*time.Second, 50*time.Millisecond)
+
+	status := backend.CommandStatus()
+	assert.True(t, status.Running)
+	assert.True(t, strings.Contains(strings.Join(status.Logs, "\n"), "the helper is listening"))
+
+	// restart the exited helper
+	process, err := os.FindProcess(status.PID)
+	assert.NoError(t, err)
+	assert.NoError(t, process.Kill())
+	assert.Eventually(t, func() bool {
+		return !backend.Alive()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		status := backend.CommandStatus()
+		return status.Restarts == 1 && status.Running && backend.Check() == nil
+	}, 10*time.Second, 50*time.Millisecond)
+
+	pool.StopCommands()
+	assert.Nil(t, backend.CommandStatus())
+	assert.False(t, backend.Alive())
+}
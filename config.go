@@ -10,9 +10,17 @@
 
 package socks5lb
 
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
 type ServerConfig struct {
 	HTTP struct {
-		Addr string `yaml:"addr"`
+		Addr string     `yaml:"addr"`
+		Auth AuthConfig `yaml:"auth"`
 	} `yaml:"http"`
 
 	TProxy struct {
@@ -21,10 +29,126 @@ type ServerConfig struct {
 
 	Sock5 struct {
 		Addr string `yaml:"addr"`
+		// Mode is "passthrough" by default, or "relay" to handle the socks5 requests by itself
+		Mode string `yaml:"mode"`
 	} `yaml:"socks5"`
+
+	HTTPProxy struct {
+		Addr  string          `yaml:"addr"`
+		Cache HTTPCacheConfig `yaml:"cache"`
+	} `yaml:"http_proxy"`
+
+	QUIC struct {
+		Addr     string `yaml:"addr"`
+		CertFile string `yaml:"cert_file"`
+		KeyFile  string `yaml:"key_file"`
+		// ClientCAFile verifies the client certificates of the other instances, required unless listening on the loopback
+		ClientCAFile string `yaml:"client_ca_file"`
+	} `yaml:"quic"`
+
+	Bandit BanditConfig `yaml:"bandit"`
+
+	AdaptiveCheck AdaptiveCheckConfig `yaml:"adaptive_check"`
+
+	Diversity DiversityConfig `yaml:"diversity"`
+
+	AdaptiveTimeout AdaptiveTimeoutConfig `yaml:"adaptive_timeout"`
+
+	Supervisor SupervisorConfig `yaml:"supervisor"`
+
+	Pick PickConfig `yaml:"pick"`
+
+	Lease LeaseConfig `yaml:"lease"`
+
+	Sandbox SandboxConfig `yaml:"sandbox"`
+
+	RemoteConfig RemoteConfig `yaml:"remote_config"`
+
+	Signature SignatureConfig `yaml:"signature"`
+
+	Privacy PrivacyConfig `yaml:"privacy"`
+
+	MDNS MDNSConfig `yaml:"mdns"`
+
+	// Rules route the destinations to the backends, the first matched rule wins
+	Rules []RoutingRule `yaml:"rules"`
+
+	// Profiles are the named routing options, one is active at a time
+	Profiles []Profile `yaml:"profiles"`
+	// Profile is the active profile at start, the first one by default
+	Profile string `yaml:"profile"`
+}
+
+type HTTPCacheConfig struct {
+	Enable bool `yaml:"enable"`
+	// Dir is the directory to store the cached objects, keep in memory if empty
+	Dir           string   `yaml:"dir"`
+	MaxSize       int64    `yaml:"max_size"`
+	MaxObjectSize int64    `yaml:"max_object_size"`
+	Hosts         []string `yaml:"hosts"`
 }
 
 type Configure struct {
 	ServerConfig ServerConfig `yaml:"server"`
 	Backends     []Backend    `yaml:"backends"`
 }
+
+// Validate to check the configuration before using it
+func (c *Configure) Validate() error {
+	switch c.ServerConfig.Sock5.Mode {
+	case "", Socks5ModePassthrough, Socks5ModeRelay:
+	default:
+		return fmt.Errorf("unknown socks5 mode %s", c.ServerConfig.Sock5.Mode)
+	}
+
+	if quic := c.ServerConfig.QUIC; quic.Addr != "" && quic.ClientCAFile == "" && !loopbackAddr(quic.Addr) {
+		return errQUICClientAuth
+	}
+
+	addrs := make(map[string]bool)
+	for _, backend := range c.Backends {
+		if backend.Addr == "" {
+			return errors.New("the address of the backend is empty")
+		}
+
+		if addrs[backend.Addr] {
+			return fmt.Errorf("backend %s is duplicated", backend.Addr)
+		}
+		addrs[backend.Addr] = true
+
+		switch backend.Protocol {
+		case "", ProtocolSocks5, ProtocolQUIC, ProtocolDirect, ProtocolWireGuard, ProtocolHTTP:
+		default:
+			return fmt.Errorf("unknown protocol %s of backend %s", backend.Protocol, backend.Addr)
+		}
+
+		switch backend.HTTP.Auth {
+		case "", HTTPAuthBasic, HTTPAuthNTLM, HTTPAuthNegotiate, HTTPAuthNegotiateNTLM:
+		default:
+			return fmt.Errorf("unknown http auth %s of backend %s", backend.HTTP.Auth, backend.Addr)
+		}
+
+		if len(backend.Command.Args) > 0 && backend.Local() {
+			return fmt.Errorf("backend %s connects the destinations by itself, the helper process is not supported", backend.Addr)
+		}
+	}
+
+	return nil
+}
+
+// ParseConfigure returns the validated configuration from the yaml data
+func ParseConfigure(data []byte) (config *Configure, err error) {
+	if err = yaml.Unmarshal(data, &config); err != nil {
+		return
+	}
+
+	if config == nil {
+		return nil, errors.New("the configuration is empty")
+	}
+
+	if err = config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return
+}
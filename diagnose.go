@@ -0,0 +1,384 @@
+/**
+ * File: diagnose.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Saturday, October 31st 2026, 11:26:13 am
+ * Last Modified: Saturday, October 31st 2026, 11:26:13 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/txthinking/socks5"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	LayerPing     = "ping"
+	LayerTCP      = "tcp"
+	LayerGreeting = "greeting"
+	LayerAuth     = "auth"
+	LayerConnect  = "connect"
+)
+
+const (
+	BackendStateUp = "up"
+	// BackendStateDown is the failed backend without the diagnosis
+	BackendStateDown = "down"
+	// BackendStateHostDown is the host not answering the ping nor the tcp connection
+	BackendStateHostDown = "host_down"
+	// BackendStateProxyDown is the host answering the ping, but the proxy port is closed
+	BackendStateProxyDown = "proxy_down"
+	// BackendStateUnreachable is the proxy port is closed, and the host can not be pinged
+	BackendStateUnreachable = "unreachable"
+	// BackendStateProxyError is the proxy port accepting the connections without a proper greeting
+	BackendStateProxyError = "proxy_error"
+	BackendStateAuthFailed = "auth_failed"
+	// BackendStateTargetBlocked is the proxy up, but it fails to connect the check target
+	BackendStateTargetBlocked = "target_blocked"
+	// BackendStateTargetError is the check target connected, but the check request failed
+	BackendStateTargetError = "target_error"
+)
+
+var (
+	// errProxyAuth is the credentials rejected by the proxy of the backend
+	errProxyAuth = errors.New("proxy authentication failed")
+	// errPingUnavailable is the unprivileged icmp socket not permitted by the system
+	errPingUnavailable = errors.New("ping is not permitted")
+)
+
+// LayerResult is the result of a layer of the diagnosis
+type LayerResult struct {
+	Layer   string        `json:"layer"`
+	OK      bool          `json:"ok"`
+	Skipped bool          `json:"skipped,omitempty"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// Diagnosis is the layered diagnosis of a failed check, from the host to the check target
+type Diagnosis struct {
+	Time time.Time `json:"time"`
+	// Layer is the first failed layer, empty if all the layers are passed
+	Layer  string        `json:"layer,omitempty"`
+	State  string        `json:"state"`
+	Layers []LayerResult `json:"layers"`
+}
+
+// run to append the result of the layer, returns true if it is passed
+func (d *Diagnosis) run(layer string, probe func() error) bool {
+	start := time.Now()
+	err := probe()
+
+	result := LayerResult{Layer: layer, OK: err == nil, Latency: time.Since(start)}
+	if err != nil {
+		result.Error = err.Error()
+		if d.Layer == "" {
+			d.Layer = layer
+		}
+	}
+
+	d.Layers = append(d.Layers, result)
+	return err == nil
+}
+
+// result returns the result of the layer, nil if it is not run
+func (d *Diagnosis) result(layer string) *LayerResult {
+	for i := range d.Layers {
+		if d.Layers[i].Layer == layer {
+			return &d.Layers[i]
+		}
+	}
+
+	return nil
+}
+
+// ping to send an icmp echo request to the ip by the unprivileged datagram socket,
+// errPingUnavailable is returned if the socket is not permitted
+func ping(ip net.IP, timeout time.Duration) (err error) {
+	network, protocol := "udp4", 1
+	var echo icmp.Type = ipv4.ICMPTypeEcho
+	if ip.To4() == nil {
+		network, protocol, echo = "udp6", 58, ipv6.ICMPTypeEchoRequest
+	}
+
+	conn, err := icmp.ListenPacket(network, "")
+	if err != nil {
+		return fmt.Errorf("%w, %v", errPingUnavailable, err)
+	}
+	defer conn.Close()
+
+	msg := icmp.Message{Type: echo, Body: &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: 1, Data: []byte("socks5lb")}}
+	data, err := msg.Marshal(nil)
+	if err != nil {
+		return
+	}
+
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return
+	}
+
+	if _, err = conn.WriteTo(data, &net.UDPAddr{IP: ip}); err != nil {
+		return
+	}
+
+	// the id is replaced by the kernel for the unprivileged sockets, wait for any echo reply from the ip
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		reply, err := icmp.ParseMessage(protocol, buf[:n])
+		if err != nil {
+			continue
+		}
+
+		if addr, ok := from.(*net.UDPAddr); ok && addr.IP.Equal(ip) &&
+			(reply.Type == ipv4.ICMPTypeEchoReply || reply.Type == ipv6.ICMPTypeEchoReply) {
+			return nil
+		}
+	}
+}
+
+// checkTarget returns the host and port of the check url
+func checkTarget(checkURL string) (string, error) {
+	u, err := url.Parse(checkURL)
+	if err != nil {
+		return "", err
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// diagnose the backend layer by layer after the check is failed, nil for the
+// backends without a proxy to diagnose
+func (b *Backend) diagnose() *Diagnosis {
+	if b.Local() || b.CheckConfig.CheckURL == "" {
+		return nil
+	}
+
+	target, err := checkTarget(b.CheckConfig.CheckURL)
+	if err != nil {
+		return nil
+	}
+
+	seconds := b.Timeout()
+	if seconds <= 0 {
+		seconds = 5
+	}
+	timeout := time.Duration(seconds) * time.Second
+
+	// the diagnosis runs in the check round, so all the layers share the timeout of a check
+	diagnosis := &Diagnosis{Time: time.Now()}
+	deadline := diagnosis.Time.Add(timeout)
+	remaining := func() int {
+		return max(1, int(math.Ceil(time.Until(deadline).Seconds())))
+	}
+
+	// the ping is only a hint, the layers above it are diagnosed meanwhile
+	pinged := make(chan LayerResult, 1)
+	go func() {
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+
+		host, _, _ := net.SplitHostPort(b.Addr)
+		start := time.Now()
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err == nil {
+			err = ping(ips[0].IP, time.Until(deadline))
+		}
+
+		result := LayerResult{Layer: LayerPing, OK: err == nil, Latency: time.Since(start)}
+		if err != nil {
+			result.Error, result.Skipped = err.Error(), errors.Is(err, errPingUnavailable)
+		}
+		pinged <- result
+	}()
+	defer func() {
+		diagnosis.Layers = append([]LayerResult{<-pinged}, diagnosis.Layers...)
+		diagnosis.State = diagnosis.state()
+	}()
+
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}()
+
+	// the quic backends are connected by the quic handshake
+	passed := diagnosis.run(LayerTCP, func() (err error) {
+		if b.Protocol == ProtocolHTTP {
+			conn, err = net.DialTimeout("tcp", b.Addr, time.Until(deadline))
+		} else {
+			conn, err = b.Dial(seconds)
+		}
+
+		if err == nil {
+			err = conn.SetDeadline(deadline)
+		}
+		return
+	})
+
+	if passed && b.Protocol == ProtocolHTTP {
+		// the credentials of the http proxy are sent with the CONNECT requests
+		proxyConn, err := b.dialHTTP("tcp", target, remaining())
+		if err == nil {
+			_ = proxyConn.Close()
+		}
+
+		passed = diagnosis.run(LayerAuth, func() error {
+			if errors.Is(err, errProxyAuth) {
+				return err
+			}
+			return nil
+		})
+
+		if passed {
+			diagnosis.run(LayerConnect, func() error {
+				return err
+			})
+		}
+	} else if passed {
+		method := byte(socks5.MethodNone)
+		if b.UserName != "" && b.Password != "" {
+			method = socks5.MethodUsernamePassword
+		}
+
+		passed = diagnosis.run(LayerGreeting, func() error {
+			if _, err := socks5.NewNegotiationRequest([]byte{method}).WriteTo(conn); err != nil {
+				return err
+			}
+
+			reply, err := socks5.NewNegotiationReplyFrom(conn)
+			if err != nil {
+				return err
+			}
+
+			// the other method is required by the proxy if the offered one is rejected
+			method = reply.Method
+			return nil
+		})
+
+		passed = passed && diagnosis.run(LayerAuth, func() error {
+			switch method {
+			case socks5.MethodNone:
+				return nil
+			case socks5.MethodUsernamePassword:
+			default:
+				return fmt.Errorf("%w, no acceptable authentication method", errProxyAuth)
+			}
+
+			if _, err := socks5.NewUserPassNegotiationRequest([]byte(b.UserName), []byte(b.Password)).WriteTo(conn); err != nil {
+				return err
+			}
+
+			reply, err := socks5.NewUserPassNegotiationReplyFrom(conn)
+			if err != nil {
+				return err
+			}
+
+			if reply.Status != socks5.UserPassStatusSuccess {
+				return fmt.Errorf("%w, %v", errProxyAuth, socks5.ErrUserPassAuth)
+			}
+
+			return nil
+		})
+
+		if passed {
+			diagnosis.run(LayerConnect, func() error {
+				atyp, addr, port, err := socks5.ParseAddress(target)
+				if err != nil {
+					return err
+				}
+
+				// the length of the domain is prefixed by the request again
+				if atyp == socks5.ATYPDomain {
+					addr = addr[1:]
+				}
+
+				if _, err = socks5.NewRequest(socks5.CmdConnect, atyp, addr, port).WriteTo(conn); err != nil {
+					return err
+				}
+
+				reply, err := socks5.NewReplyFrom(conn)
+				if err != nil {
+					return err
+				}
+
+				if reply.Rep != socks5.RepSuccess {
+					return fmt.Errorf("socks5 server replied %d when connecting %s", reply.Rep, target)
+				}
+
+				return nil
+			})
+		}
+	}
+
+	return diagnosis
+}
+
+// state returns the health state by the first failed layer
+func (d *Diagnosis) state() string {
+	switch d.Layer {
+	case "":
+		// all the layers are passed, the check request itself is failed
+		return BackendStateTargetError
+	case LayerTCP:
+		if ping := d.result(LayerPing); ping.OK {
+			return BackendStateProxyDown
+		} else if ping.Skipped {
+			return BackendStateUnreachable
+		}
+		return BackendStateHostDown
+	case LayerGreeting:
+		return BackendStateProxyError
+	case LayerAuth:
+		return BackendStateAuthFailed
+	case LayerConnect:
+		return BackendStateTargetBlocked
+	}
+
+	return BackendStateDown
+}
+
+// State returns the health state of the backend, diagnosed by the last failed check
+func (b *Backend) State() string {
+	if b.Alive() {
+		return BackendStateUp
+	}
+
+	if b.diagnosis != nil {
+		return b.diagnosis.State
+	}
+
+	return BackendStateDown
+}
+
+// Diagnosis returns the diagnosis of the last failed check, nil if it is passed or not diagnosed
+func (b *Backend) Diagnosis() *Diagnosis {
+	return b.diagnosis
+}
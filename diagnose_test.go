@@ -0,0 +1,142 @@
+package socks5lb
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/txthinking/socks5"
+)
+
+func TestBackend_Diagnose(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer target.Close()
+
+	// a socks5 server requires the credentials
+	sl, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer sl.Close()
+
+	secured := sl.Addr().String()
+	server, err := socks5.NewClassicServer(secured, "127.0.0.1", "alice", "s3cret", 5, 5)
+	assert.NoError(t, err)
+	go serveClassicSocks5(server, sl)
+
+	// a tcp service which does not speak socks5
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+			_ = conn.Close()
+		}
+	}()
+
+	for name, tc := range map[string]struct {
+		addr, user, password, checkURL string
+		layer                          string
+		states                         []string
+	}{
+		"closed port": {
+			addr: FreeAddr(t), checkURL: target.URL, layer: LayerTCP,
+			// the state depends on whether the unprivileged ping is permitted
+			states: []string{BackendStateProxyDown, BackendStateUnreachable},
+		},
+		"not socks5": {
+			addr: l.Addr().String(), checkURL: target.URL, layer: LayerGreeting,
+			states: []string{BackendStateProxyError},
+		},
+		"no credentials": {
+			addr: secured, checkURL: target.URL, layer: LayerAuth,
+			states: []string{BackendStateAuthFailed},
+		},
+		"wrong password": {
+			addr: secured, user: "alice", password: "wrong", checkURL: target.URL, layer: LayerAuth,
+			states: []string{BackendStateAuthFailed},
+		},
+		"target blocked": {
+			addr: secured, user: "alice", password: "s3cret", checkURL: "http://" + FreeAddr(t), layer: LayerConnect,
+			states: []string{BackendStateTargetBlocked},
+		},
+		"target error": {
+			addr: secured, user: "alice", password: "s3cret", checkURL: target.URL, layer: "",
+			states: []string{BackendStateTargetError},
+		},
+		"target error by name": {
+			addr: secured, user: "alice", password: "s3cret", checkURL: strings.Replace(target.URL, "127.0.0.1", "localhost", 1), layer: "",
+			states: []string{BackendStateTargetError},
+		},
+	} {
+		backend := NewBackend(tc.addr, BackendCheckConfig{CheckURL: tc.checkURL, Timeout: 2})
+		backend.UserName, backend.Password = tc.user, tc.password
+
+		assert.Error(t, backend.Check(), name)
+		assert.False(t, backend.Alive(), name)
+
+		diagnosis := backend.Diagnosis()
+		if !assert.NotNil(t, diagnosis, name) {
+			continue
+		}
+
+		assert.Equal(t, tc.layer, diagnosis.Layer, name)
+		assert.Contains(t, tc.states, diagnosis.State, name)
+		assert.Equal(t, LayerPing, diagnosis.Layers[0].Layer, name)
+		assert.Equal(t, diagnosis.State, NewBackendView(backend).State, name)
+
+		history := backend.History()
+		assert.Equal(t, tc.layer, history[len(history)-1].Layer, name)
+		assert.Equal(t, diagnosis.State, history[len(history)-1].State, name)
+	}
+
+	// the passed check clears the diagnosis
+	backend := NewBackend(secured, BackendCheckConfig{CheckURL: target.URL + "/ok", Timeout: 2})
+	backend.UserName, backend.Password = "alice", "wrong"
+	assert.Error(t, backend.Check())
+	assert.Equal(t, BackendStateAuthFailed, backend.State())
+
+	backend.CheckConfig.CheckURL = "http://" + FreeAddr(t)
+	backend.Password = "s3cret"
+	assert.Error(t, backend.Check())
+	assert.Equal(t, BackendStateTargetBlocked, backend.State())
+
+	backend.Protocol = ProtocolDirect
+	assert.Error(t, backend.Check())
+	assert.Nil(t, backend.Diagnosis())
+	assert.Equal(t, BackendStateDown, backend.State())
+}
+
+func TestBackend_DiagnoseHTTP(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+
+	backend := NewBackend(NewTestNTLMProxy(t, "NTLM", "CORP", "alice", "s3cret"), BackendCheckConfig{CheckURL: target.URL, Timeout: 2})
+	backend.Protocol, backend.UserName, backend.Password = ProtocolHTTP, "alice", "wrong"
+	backend.HTTP = BackendHTTPConfig{Auth: HTTPAuthNTLM, Domain: "CORP"}
+
+	assert.Error(t, backend.Check())
+	assert.Equal(t, LayerAuth, backend.Diagnosis().Layer)
+	assert.Equal(t, BackendStateAuthFailed, backend.State())
+
+	backend.Password = "s3cret"
+	backend.CheckConfig.CheckURL = "http://" + FreeAddr(t)
+	assert.Error(t, backend.Check())
+	assert.Equal(t, LayerConnect, backend.Diagnosis().Layer)
+	assert.Equal(t, BackendStateTargetBlocked, backend.State())
+
+	backend.CheckConfig.CheckURL = target.URL
+	assert.NoError(t, backend.Check())
+	assert.Nil(t, backend.Diagnosis())
+	assert.Equal(t, BackendStateUp, backend.State())
+}
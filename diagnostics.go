@@ -0,0 +1,327 @@
+/**
+ * File: diagnostics.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Saturday, October 17th 2026, 2:36:09 pm
+ * Last Modified: Saturday, October 17th 2026, 2:36:09 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// redacted is the placeholder of the secrets in the diagnostic bundle
+const redacted = "******"
+
+// maxRecentLogs is the number of the log lines kept in memory
+const maxRecentLogs = 500
+
+// recentLogHook keeps the recent log lines for the diagnostic bundle
+type recentLogHook struct {
+	lock  sync.Mutex
+	lines []string
+	times []time.Time
+}
+
+func (h *recentLogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *recentLogHook) Fire(entry *log.Entry) error {
+	line, err := entry.String()
+	if err != nil {
+		return err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.lines = append(h.lines, strings.TrimRight(line, "\n"))
+	h.times = append(h.times, entry.Time)
+	if len(h.lines) > maxRecentLogs {
+		h.lines = h.lines[len(h.lines)-maxRecentLogs:]
+		h.times = h.times[len(h.times)-maxRecentLogs:]
+	}
+
+	return nil
+}
+
+// Expire to drop the log lines older than the time
+func (h *recentLogHook) Expire(before time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	i := 0
+	for i < len(h.times) && h.times[i].Before(before) {
+		i++
+	}
+	h.lines, h.times = h.lines[i:], h.times[i:]
+}
+
+// Lines returns the recent log lines
+func (h *recentLogHook) Lines() []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return append([]string(nil), h.lines...)
+}
+
+var recentLogs = &recentLogHook{}
+
+func init() {
+	log.AddHook(recentLogs)
+}
+
+// ListenerInfo is the listening address of the server
+type ListenerInfo struct {
+	Name string `json:"name"`
+	Addr string `json:"addr"`
+}
+
+// Listeners returns the active listeners of the server
+func (s *Server) Listeners() (listeners []ListenerInfo) {
+	for name, l := range map[string]net.Listener{
+		"socks5":     s.socks5Listener,
+		"tproxy":     s.tproxyListener,
+		"http_proxy": s.httpProxyListener,
+	} {
+		if l != nil {
+			listeners = append(listeners, ListenerInfo{Name: name, Addr: l.Addr().String()})
+		}
+	}
+
+	if s.quicListener != nil {
+		listeners = append(listeners, ListenerInfo{Name: "quic", Addr: s.quicListener.Addr().String()})
+	}
+
+	if s.Config.HTTP.Addr != "" {
+		listeners = append(listeners, ListenerInfo{Name: "http_admin", Addr: s.Config.HTTP.Addr})
+	}
+
+	return
+}
+
+// diagnosticBackend is the redacted backend with the check history
+type diagnosticBackend struct {
+	BackendView
+	History []CheckResult `json:"history"`
+}
+
+// redactBackend returns a copy of the backend without the secrets
+func redactBackend(b *Backend) *Backend {
+	backend := *b
+	if backend.Password != "" {
+		backend.Password = redacted
+	}
+	if backend.WireGuard.PrivateKey != "" {
+		backend.WireGuard.PrivateKey = redacted
+	}
+	if backend.WireGuard.PresharedKey != "" {
+		backend.WireGuard.PresharedKey = redacted
+	}
+	if backend.HTTP.PasswordFile != "" {
+		backend.HTTP.PasswordFile = redacted
+	}
+	if backend.HTTP.Keytab != "" {
+		backend.HTTP.Keytab = redacted
+	}
+	if backend.HTTP.CCache != "" {
+		backend.HTTP.CCache = redacted
+	}
+
+	// keep the names of the environment variables of the helper process only
+	if len(backend.Command.Env) > 0 {
+		backend.Command.Env = make([]string, 0, len(b.Command.Env))
+		for _, env := range b.Command.Env {
+			name, _, _ := strings.Cut(env, "=")
+			backend.Command.Env = append(backend.Command.Env, name+"="+redacted)
+		}
+	}
+
+	return &backend
+}
+
+// redactedBackends returns the backends without the secrets
+func (s *Server) redactedBackends() (backends []Backend) {
+	for _, b := range s.Pool.Select(nil) {
+		backends = append(backends, *redactBackend(b))
+	}
+
+	return
+}
+
+// redactedConfig returns the server configuration without the secrets
+func (s *Server) redactedConfig() ServerConfig {
+	config := *s.Config
+	if config.RemoteConfig.AuthHeader != "" {
+		config.RemoteConfig.AuthHeader = redacted
+	}
+
+	config.HTTP.Auth.Accounts = nil
+	for _, account := range s.Config.HTTP.Auth.Accounts {
+		account.PasswordHash = redacted
+		if account.TOTPSecret != "" {
+			account.TOTPSecret = redacted
+		}
+		config.HTTP.Auth.Accounts = append(config.HTTP.Auth.Accounts, account)
+	}
+
+	return config
+}
+
+// runtimeStats returns the runtime metrics of the process
+func runtimeStats() map[string]interface{} {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := map[string]interface{}{
+		"go_version":  runtime.Version(),
+		"os":          runtime.GOOS,
+		"arch":        runtime.GOARCH,
+		"num_cpu":     runtime.NumCPU(),
+		"gomaxprocs":  runtime.GOMAXPROCS(0),
+		"goroutines":  runtime.NumGoroutine(),
+		"heap_alloc":  mem.HeapAlloc,
+		"heap_sys":    mem.HeapSys,
+		"sys":         mem.Sys,
+		"num_gc":      mem.NumGC,
+		"pause_total": time.Duration(mem.PauseTotalNs).String(),
+	}
+
+	if fds, err := os.ReadDir("/proc/self/fd"); err == nil {
+		stats["open_fds"] = len(fds)
+	}
+
+	return stats
+}
+
+// versionInfo returns the version and build metadata
+func versionInfo() map[string]interface{} {
+	info := map[string]interface{}{
+		"name":         AppName,
+		"version":      Version,
+		"build_commit": BuildCommit,
+		"build_date":   BuildDate,
+		"debug_mode":   DebugMode,
+		"privacy":      Privacy(),
+		"start_time":   StartTime,
+		"uptime":       time.Since(StartTime).String(),
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		info["go_module"] = build.Main.Path
+		settings := make(map[string]string)
+		for _, setting := range build.Settings {
+			settings[setting.Key] = setting.Value
+		}
+		info["build_settings"] = settings
+	}
+
+	return info
+}
+
+// WriteDiagnostics to write the tar.gz diagnostic bundle into the writer
+func (s *Server) WriteDiagnostics(w io.Writer) (err error) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+
+	addFile := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0o644,
+			Size:    int64(len(data)),
+			ModTime: now,
+		}); err != nil {
+			return err
+		}
+
+		_, err := tw.Write(data)
+		return err
+	}
+
+	addJSON := func(name string, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		return addFile(name, data)
+	}
+
+	backends := s.redactedBackends()
+	config, err := yaml.Marshal(struct {
+		ServerConfig ServerConfig `yaml:"server"`
+		Backends     []Backend    `yaml:"backends"`
+	}{s.redactedConfig(), backends})
+	if err != nil {
+		return
+	}
+
+	pool := make([]diagnosticBackend, 0, len(backends))
+	for i := range backends {
+		pool = append(pool, diagnosticBackend{
+			BackendView: NewBackendView(&backends[i]),
+			History:     backends[i].History(),
+		})
+	}
+
+	var goroutines bytes.Buffer
+	if err = pprof.Lookup("goroutine").WriteTo(&goroutines, 2); err != nil {
+		return
+	}
+
+	files := []struct {
+		name string
+		fn   func() error
+	}{
+		{"version.json", func() error { return addJSON("version.json", versionInfo()) }},
+		{"config.yml", func() error { return addFile("config.yml", config) }},
+		{"pool.json", func() error { return addJSON("pool.json", pool) }},
+		{"events.json", func() error { return addJSON("events.json", RecentEvents()) }},
+		{"logs.txt", func() error { return addFile("logs.txt", []byte(strings.Join(recentLogs.Lines(), "\n"))) }},
+		{"goroutines.txt", func() error { return addFile("goroutines.txt", goroutines.Bytes()) }},
+		{"runtime.json", func() error { return addJSON("runtime.json", runtimeStats()) }},
+		{"listeners.json", func() error { return addJSON("listeners.json", s.Listeners()) }},
+	}
+
+	for _, f := range files {
+		if err = f.fn(); err != nil {
+			log.Errorf("write %s into the diagnostic bundle failed, %v", f.name, err)
+			return
+		}
+	}
+
+	// socket tables are only available on Linux
+	for _, name := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		if data, err := os.ReadFile("/proc/self/net/" + name); err == nil {
+			if err = addFile("sockets/"+name+".txt", data); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return
+	}
+
+	return gz.Close()
+}
@@ -0,0 +1,51 @@
+package socks5lb
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_HTTPDiagnostics(t *testing.T) {
+	engine := EngineInstance(t)
+
+	backend := NewBackend("10.20.0.1:1086", BackendCheckConfig{InitialAlive: true})
+	backend.Password = "secret"
+	assert.NoError(t, NewPool().Add(backend))
+	defer NewPool().Remove(backend.Addr)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/diagnostics", nil)
+	engine.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	gz, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+
+		data, _ := io.ReadAll(tr)
+		files[header.Name] = string(data)
+	}
+
+	for _, name := range []string{"version.json", "config.yml", "pool.json", "events.json", "logs.txt", "goroutines.txt", "runtime.json", "listeners.json"} {
+		assert.Contains(t, files, name)
+	}
+
+	assert.Contains(t, files["pool.json"], "10.20.0.1:1086")
+	assert.Contains(t, files["events.json"], "backend.added")
+	assert.NotContains(t, files["pool.json"], "secret")
+	assert.NotContains(t, files["config.yml"], "secret")
+}
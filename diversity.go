@@ -0,0 +1,219 @@
+/**
+ * File: diversity.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Sunday, October 25th 2026, 2:21:08 pm
+ * Last Modified: Sunday, October 25th 2026, 2:21:08 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// DiversityKeySubnet is the /24 or /48 network of the recorded egress ip of the backend
+	DiversityKeySubnet = "subnet"
+
+	defaultDiversityWindow   = 600
+	defaultDiversityMaxUsers = 4096
+)
+
+type DiversityConfig struct {
+	Enable bool `yaml:"enable"`
+	// Keys are what make the networks different, "subnet" or the label names like
+	// "provider", the backends sharing any of them are on the same network
+	Keys []string `yaml:"keys"`
+	// Window is how long to avoid the networks used by the user in seconds, 600 by default
+	Window   uint `yaml:"window"`
+	MaxUsers int  `yaml:"max_users"`
+}
+
+// Diversity spreads the consecutive sessions of each user across the distinct networks
+type Diversity struct {
+	config DiversityConfig
+	window time.Duration
+
+	lock sync.Mutex
+	// users are the last used time of the networks by each user
+	users map[string]map[string]time.Time
+}
+
+// NewDiversity returns the egress diversity strategy
+func NewDiversity(config DiversityConfig) *Diversity {
+	if len(config.Keys) == 0 {
+		config.Keys = []string{DiversityKeySubnet}
+	}
+
+	if config.Window == 0 {
+		config.Window = defaultDiversityWindow
+	}
+
+	if config.MaxUsers <= 0 {
+		config.MaxUsers = defaultDiversityMaxUsers
+	}
+
+	return &Diversity{
+		config: config,
+		window: time.Duration(config.Window) * time.Second,
+		users:  make(map[string]map[string]time.Time),
+	}
+}
+
+// networks returns the networks of the backend by the keys, the backend itself
+// is the network if the key is unknown, like the egress ip is not recorded yet
+func (d *Diversity) networks(backend *Backend) (networks []string) {
+	for _, key := range d.config.Keys {
+		value := ""
+		if key == DiversityKeySubnet {
+			if addr, err := netip.ParseAddr(backend.EgressIP()); err == nil {
+				bits := 48
+				if addr = addr.Unmap(); addr.Is4() {
+					bits = 24
+				}
+				prefix, _ := addr.Prefix(bits)
+				value = prefix.String()
+			}
+		} else {
+			value = backend.Labels[key]
+		}
+
+		if value == "" {
+			value = "backend:" + backend.Addr
+		}
+		networks = append(networks, key+"="+value)
+	}
+
+	return
+}
+
+// Filter returns the backends whose networks are the least recently used by the user,
+// the ones not used in the window at all if any
+func (d *Diversity) Filter(user string, backends []*Backend) []*Backend {
+	if user == "" || len(backends) <= 1 {
+		return backends
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	used := d.users[user]
+	if len(used) == 0 {
+		return backends
+	}
+
+	var (
+		since    = time.Now().Add(-d.window)
+		oldest   time.Time
+		selected []*Backend
+	)
+
+	for _, backend := range backends {
+		// the latest use of any network of the backend
+		var last time.Time
+		for _, network := range d.networks(backend) {
+			if t := used[network]; t.After(since) && t.After(last) {
+				last = t
+			}
+		}
+
+		switch {
+		case selected == nil || last.Before(oldest):
+			oldest, selected = last, []*Backend{backend}
+		case last.Equal(oldest):
+			selected = append(selected, backend)
+		}
+	}
+
+	return selected
+}
+
+// Record to remember the networks of the backend used by the user
+func (d *Diversity) Record(user string, backend *Backend) {
+	if user == "" || backend == nil {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := time.Now()
+	used := d.users[user]
+	if used == nil {
+		if len(d.users) >= d.config.MaxUsers {
+			d.expire(now.Add(-d.window))
+		}
+
+		// forget a random user if all of them are active
+		if len(d.users) >= d.config.MaxUsers {
+			for user := range d.users {
+				delete(d.users, user)
+				break
+			}
+		}
+
+		used = make(map[string]time.Time)
+		d.users[user] = used
+	}
+
+	for network, t := range used {
+		if t.Before(now.Add(-d.window)) {
+			delete(used, network)
+		}
+	}
+
+	for _, network := range d.networks(backend) {
+		used[network] = now
+	}
+}
+
+// expire to forget the uses before the time, the lock is held by the caller
+func (d *Diversity) expire(before time.Time) {
+	for user, used := range d.users {
+		for network, t := range used {
+			if t.Before(before) {
+				delete(used, network)
+			}
+		}
+
+		if len(used) == 0 {
+			delete(d.users, user)
+		}
+	}
+}
+
+// Expire to forget the uses before the time
+func (d *Diversity) Expire(before time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.expire(before)
+}
+
+// Snapshot returns the networks used by each user in the window
+func (d *Diversity) Snapshot() map[string]map[string]time.Time {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	since := time.Now().Add(-d.window)
+	snapshot := make(map[string]map[string]time.Time, len(d.users))
+	for user, used := range d.users {
+		networks := make(map[string]time.Time, len(used))
+		for network, t := range used {
+			if t.After(since) {
+				networks[network] = t
+			}
+		}
+
+		if len(networks) > 0 {
+			snapshot[user] = networks
+		}
+	}
+
+	return snapshot
+}
@@ -0,0 +1,91 @@
+package socks5lb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiversity_Filter(t *testing.T) {
+	a1 := NewBackend("10.90.0.1:1086", BackendCheckConfig{InitialAlive: true})
+	a1.egressIP, a1.Labels = "203.0.113.10", map[string]string{"provider": "a"}
+	a2 := NewBackend("10.90.0.2:1086", BackendCheckConfig{InitialAlive: true})
+	a2.egressIP, a2.Labels = "203.0.113.20", map[string]string{"provider": "b"}
+	b1 := NewBackend("10.90.0.3:1086", BackendCheckConfig{InitialAlive: true})
+	b1.egressIP, b1.Labels = "198.51.100.10", map[string]string{"provider": "a"}
+	unknown := NewBackend("10.90.0.4:1086", BackendCheckConfig{InitialAlive: true})
+	backends := []*Backend{a1, a2, b1, unknown}
+
+	diversity := NewDiversity(DiversityConfig{Enable: true})
+	assert.Equal(t, []string{"subnet=203.0.113.0/24"}, diversity.networks(a1))
+	assert.Equal(t, []string{"subnet=backend:10.90.0.4:1086"}, diversity.networks(unknown))
+
+	diversity.Record("alice", a1)
+	assert.Equal(t, []*Backend{b1, unknown}, diversity.Filter("alice", backends))
+	assert.Equal(t, backends, diversity.Filter("bob", backends))
+	assert.Equal(t, backends, diversity.Filter("", backends))
+
+	// the least recently used networks are chosen if all of them are used
+	diversity.Record("alice", b1)
+	diversity.Record("alice", unknown)
+	assert.Equal(t, []*Backend{a1, a2}, diversity.Filter("alice", backends))
+
+	// the labels make the networks different too
+	diversity = NewDiversity(DiversityConfig{Enable: true, Keys: []string{"subnet", "provider"}})
+	diversity.Record("alice", a2)
+	assert.Equal(t, []*Backend{b1, unknown}, diversity.Filter("alice", backends))
+	assert.Contains(t, diversity.Snapshot()["alice"], "provider=b")
+}
+
+func TestPool_RouteDiversity(t *testing.T) {
+	pool := &Pool{backends: make(map[string]*Backend)}
+	for i, ip := range []string{"203.0.113.10", "203.0.113.20", "198.51.100.10", "192.0.2.10"} {
+		backend := NewBackend(FreeAddr(t), BackendCheckConfig{InitialAlive: true})
+		backend.egressIP = ip
+		backend.Labels = map[string]string{"index": string(rune('0' + i))}
+		assert.NoError(t, pool.Add(backend))
+	}
+	pool.SetDiversity(NewDiversity(DiversityConfig{Enable: true}))
+
+	// three networks, consecutive sessions never share one of them
+	var last string
+	for i := 0; i < 12; i++ {
+		backend, _ := pool.route("alice", "example.com:443")
+		assert.NotNil(t, backend)
+
+		network := pool.Diversity().networks(backend)[0]
+		assert.NotEqual(t, last, network)
+		last = network
+	}
+
+	// the same for the passthrough connections, without the destinations
+	last = ""
+	for i := 0; i < 12; i++ {
+		backend := pool.NextForUser("bob")
+		assert.NotNil(t, backend)
+
+		network := pool.Diversity().networks(backend)[0]
+		assert.NotEqual(t, last, network)
+		last = network
+	}
+}
+
+func TestBackend_EgressIP(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ip" {
+			_, _ = w.Write([]byte("203.0.113.7\n"))
+		}
+	}))
+	defer target.Close()
+
+	backend := NewBackend(NewTestSocks5Server(t), BackendCheckConfig{
+		CheckURL:    target.URL,
+		EgressIPURL: target.URL + "/ip",
+		Timeout:     5,
+	})
+	assert.NoError(t, backend.Check())
+	assert.Equal(t, "203.0.113.7", backend.EgressIP())
+	assert.Equal(t, "203.0.113.7", NewBackendView(backend).EgressIP)
+}
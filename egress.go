@@ -0,0 +1,317 @@
+/**
+ * File: egress.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Sunday, October 18th 2026, 10:08:51 am
+ * Last Modified: Sunday, October 18th 2026, 10:08:51 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxEgressUsage is the number of the source addresses tracked for a prefix
+const maxEgressUsage = 4096
+
+// maxEgressAttempts is how many times to pick a source address not excluded
+const maxEgressAttempts = 16
+
+type EgressConfig struct {
+	// Addresses are the local addresses to use as the source addresses
+	Addresses []string `yaml:"addresses" json:"addresses"`
+	// Prefix is the routed IPv6 prefix, like 2001:db8:1::/64, to draw the source addresses from
+	Prefix string `yaml:"prefix" json:"prefix"`
+	// Sticky to use the same source address for the same user
+	Sticky bool `yaml:"sticky" json:"sticky"`
+	// Freebind to bind the addresses which are not assigned to the interfaces, Linux only
+	Freebind bool `yaml:"freebind" json:"freebind"`
+}
+
+// EgressUsage is the usage of a source address
+type EgressUsage struct {
+	Sessions uint64    `json:"sessions"`
+	Failures uint64    `json:"failures"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// EgressExclusion is a source address excluded for a destination, all destinations if empty
+type EgressExclusion struct {
+	Addr        string    `json:"addr"`
+	Destination string    `json:"destination,omitempty"`
+	Until       time.Time `json:"until"`
+}
+
+// EgressStatus is the source address usage and the exclusions of a direct backend
+type EgressStatus struct {
+	Usage      map[string]EgressUsage `json:"usage"`
+	Exclusions []EgressExclusion      `json:"exclusions"`
+}
+
+// egressPool draws the source addresses for the direct connections
+type egressPool struct {
+	lock       sync.Mutex
+	addresses  []net.IP
+	prefix     *net.IPNet
+	usage      map[string]*EgressUsage
+	exclusions map[string]map[string]time.Time
+}
+
+var egressPoolsLock sync.Mutex
+
+// newEgressPool parses the source addresses by the configuration
+func newEgressPool(config EgressConfig) (pool *egressPool, err error) {
+	pool = &egressPool{
+		usage:      make(map[string]*EgressUsage),
+		exclusions: make(map[string]map[string]time.Time),
+	}
+
+	for _, addr := range config.Addresses {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid egress address %s", addr)
+		}
+		pool.addresses = append(pool.addresses, ip)
+	}
+
+	if config.Prefix != "" {
+		if _, pool.prefix, err = net.ParseCIDR(config.Prefix); err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
+
+// excluded returns true if the address is excluded for the destination
+func (p *egressPool) excluded(ip net.IP, dst string, now time.Time) bool {
+	for _, key := range []string{"", dst} {
+		if until, ok := p.exclusions[ip.String()][key]; ok && now.Before(until) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// candidate returns the n-th candidate address, seeded by the user if sticky
+func (p *egressPool) candidate(seed uint64) net.IP {
+	if p.prefix == nil {
+		return p.addresses[seed%uint64(len(p.addresses))]
+	}
+
+	// fill the host bits of the prefix
+	ip := make(net.IP, len(p.prefix.IP))
+	copy(ip, p.prefix.IP)
+
+	random := make([]byte, net.IPv6len)
+	binary.BigEndian.PutUint64(random[:8], seed*0x9e3779b97f4a7c15)
+	binary.BigEndian.PutUint64(random[8:], seed)
+	random = random[len(random)-len(ip):]
+
+	for i := range ip {
+		ip[i] |= random[i] &^ p.prefix.Mask[i]
+	}
+
+	return ip
+}
+
+// randomSeed returns a random seed for the non-sticky sessions
+func randomSeed() uint64 {
+	n, err := rand.Int(rand.Reader, new(big.Int).SetUint64(^uint64(0)))
+	if err != nil {
+		return uint64(time.Now().UnixNano())
+	}
+
+	return n.Uint64()
+}
+
+// Pick returns a source address for the user and the destination
+func (p *egressPool) Pick(user, dst string, sticky bool) (net.IP, error) {
+	if len(p.addresses) == 0 && p.prefix == nil {
+		return nil, nil
+	}
+
+	seed := randomSeed()
+	if sticky && user != "" {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(user))
+		seed = h.Sum64()
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := time.Now()
+	for i := uint64(0); i < maxEgressAttempts; i++ {
+		if ip := p.candidate(seed + i); !p.excluded(ip, dst, now) {
+			return ip, nil
+		}
+	}
+
+	// try all the addresses in turn, the prefix is too large to do so
+	if p.prefix == nil {
+		for _, ip := range p.addresses {
+			if !p.excluded(ip, dst, now) {
+				return ip, nil
+			}
+		}
+	}
+
+	return nil, errors.New("all egress addresses are excluded for " + dst)
+}
+
+// Record to track the usage of the source address
+func (p *egressPool) Record(ip net.IP, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	usage := p.usage[ip.String()]
+	if usage == nil {
+		// forget the least recently used address when there are too many
+		if len(p.usage) >= maxEgressUsage {
+			var oldest string
+			for addr, u := range p.usage {
+				if oldest == "" || u.LastUsed.Before(p.usage[oldest].LastUsed) {
+					oldest = addr
+				}
+			}
+			delete(p.usage, oldest)
+		}
+
+		usage = &EgressUsage{}
+		p.usage[ip.String()] = usage
+	}
+
+	usage.Sessions++
+	usage.LastUsed = time.Now()
+	if err != nil {
+		usage.Failures++
+	}
+}
+
+// Exclude the source address for the destination, or all destinations if empty
+func (p *egressPool) Exclude(addr, dst string, duration time.Duration) error {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return fmt.Errorf("invalid egress address %s", addr)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.exclusions[ip.String()] == nil {
+		p.exclusions[ip.String()] = make(map[string]time.Time)
+	}
+	p.exclusions[ip.String()][dst] = time.Now().Add(duration)
+	return nil
+}
+
+// Include to remove the exclusion of the source address
+func (p *egressPool) Include(addr, dst string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if ip := net.ParseIP(addr); ip != nil {
+		delete(p.exclusions[ip.String()], dst)
+	}
+}
+
+// Usage returns the usage of the source addresses
+func (p *egressPool) Usage() map[string]EgressUsage {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	usage := make(map[string]EgressUsage, len(p.usage))
+	for addr, u := range p.usage {
+		usage[addr] = *u
+	}
+
+	return usage
+}
+
+// Exclusions returns the active exclusions
+func (p *egressPool) Exclusions() (exclusions []EgressExclusion) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := time.Now()
+	for addr, destinations := range p.exclusions {
+		for dst, until := range destinations {
+			if now.Before(until) {
+				exclusions = append(exclusions, EgressExclusion{Addr: addr, Destination: dst, Until: until})
+			}
+		}
+	}
+
+	return
+}
+
+// sourcePool returns the source address pool of the direct backend
+func (b *Backend) sourcePool() (*egressPool, error) {
+	egressPoolsLock.Lock()
+	defer egressPoolsLock.Unlock()
+
+	if b.egress == nil {
+		pool, err := newEgressPool(b.Egress)
+		if err != nil {
+			return nil, err
+		}
+		b.egress = pool
+	}
+
+	return b.egress, nil
+}
+
+// dialDirect to connect the destination directly from a source address of the pool
+func (b *Backend) dialDirect(user, network, addr string, timeout int) (conn net.Conn, err error) {
+	egress, err := b.sourcePool()
+	if err != nil {
+		return
+	}
+
+	dst := destinationKey(addr, true)
+	ip, err := egress.Pick(user, dst, b.Egress.Sticky)
+	if err != nil {
+		return
+	}
+
+	dialer := &net.Dialer{Timeout: time.Duration(timeout) * time.Second, Resolver: Resolver()}
+	if ip != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		if b.Egress.Freebind {
+			dialer.Control = freebindControl
+		}
+	}
+
+	conn, err = dialer.Dial(network, addr)
+	if ip != nil {
+		log.Tracef("[direct] %s -> %s from %s, %v", user, addr, ip, err)
+		egress.Record(ip, err)
+	}
+
+	return
+}
+
+// egressSourcePool returns the source address pool of the direct backend by the address
+func (s *Server) egressSourcePool(addr string) (*egressPool, error) {
+	backend := s.Pool.Get(addr)
+	if backend == nil || backend.Protocol != ProtocolDirect {
+		return nil, fmt.Errorf("direct backend %s is not found", addr)
+	}
+
+	return backend.sourcePool()
+}
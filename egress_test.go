@@ -0,0 +1,108 @@
+package socks5lb
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEgressPool_Pick(t *testing.T) {
+	pool, err := newEgressPool(EgressConfig{Prefix: "2001:db8:1::/64"})
+	assert.NoError(t, err)
+
+	_, prefix, _ := net.ParseCIDR("2001:db8:1::/64")
+	for i := 0; i < 10; i++ {
+		ip, err := pool.Pick("", "example.com", false)
+		assert.NoError(t, err)
+		assert.True(t, prefix.Contains(ip))
+	}
+
+	// the sticky user always gets the same address
+	first, _ := pool.Pick("192.168.1.10", "example.com", true)
+	second, _ := pool.Pick("192.168.1.10", "example.org", true)
+	assert.Equal(t, first, second)
+
+	pool, err = newEgressPool(EgressConfig{Prefix: "10.40.0.0/24"})
+	assert.NoError(t, err)
+	ip, _ := pool.Pick("", "example.com", false)
+	assert.Equal(t, net.IP{10, 40, 0}, ip.To4()[:3])
+
+	_, err = newEgressPool(EgressConfig{Addresses: []string{"not-an-ip"}})
+	assert.Error(t, err)
+}
+
+func TestEgressPool_Exclude(t *testing.T) {
+	pool, err := newEgressPool(EgressConfig{Addresses: []string{"10.40.0.1", "10.40.0.2"}})
+	assert.NoError(t, err)
+
+	// excluded for the destination only
+	assert.NoError(t, pool.Exclude("10.40.0.1", "example.com", time.Minute))
+	for i := 0; i < 10; i++ {
+		ip, err := pool.Pick("", "example.com", false)
+		assert.NoError(t, err)
+		assert.Equal(t, "10.40.0.2", ip.String())
+	}
+
+	// excluded for all destinations
+	assert.NoError(t, pool.Exclude("10.40.0.2", "", time.Minute))
+	_, err = pool.Pick("", "example.com", false)
+	assert.Error(t, err)
+
+	ip, err := pool.Pick("", "example.org", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.40.0.1", ip.String())
+	assert.Len(t, pool.Exclusions(), 2)
+
+	pool.Include("10.40.0.1", "example.com")
+	ip, err = pool.Pick("", "example.com", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.40.0.1", ip.String())
+
+	assert.Error(t, pool.Exclude("not-an-ip", "", time.Minute))
+}
+
+func TestEgressPool_Record(t *testing.T) {
+	pool, _ := newEgressPool(EgressConfig{Addresses: []string{"10.40.0.1"}})
+
+	ip := net.ParseIP("10.40.0.1")
+	pool.Record(ip, nil)
+	pool.Record(ip, errors.New("refused"))
+
+	usage := pool.Usage()["10.40.0.1"]
+	assert.Equal(t, uint64(2), usage.Sessions)
+	assert.Equal(t, uint64(1), usage.Failures)
+}
+
+func TestBackend_DialDirect(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.RemoteAddr)
+	}))
+	defer ts.Close()
+
+	backend := NewBackend("direct", BackendCheckConfig{InitialAlive: true})
+	backend.Protocol = ProtocolDirect
+	backend.Egress = EgressConfig{Addresses: []string{"127.0.0.1"}, Sticky: true}
+
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return backend.DialFor("192.168.1.10", network, addr, 3)
+		},
+	}}
+
+	resp, err := client.Get(ts.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	host, _, _ := net.SplitHostPort(string(body))
+	assert.Equal(t, "127.0.0.1", host)
+
+	pool, _ := backend.sourcePool()
+	assert.Equal(t, uint64(1), pool.Usage()["127.0.0.1"].Sessions)
+}
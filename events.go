@@ -0,0 +1,60 @@
+/**
+ * File: events.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Saturday, October 17th 2026, 2:10:44 pm
+ * Last Modified: Saturday, October 17th 2026, 2:10:44 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Event is something notable happened in the server, like a backend is down
+type Event struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Message string    `json:"message"`
+}
+
+// maxRecentEvents is the number of the events kept in memory
+const maxRecentEvents = 200
+
+var (
+	recentEvents []Event
+	eventsLock   sync.Mutex
+)
+
+// RecordEvent to record an event with the kind and the formatted message
+func RecordEvent(kind, format string, args ...interface{}) {
+	event := Event{
+		Time:    time.Now(),
+		Kind:    kind,
+		Message: fmt.Sprintf(format, args...),
+	}
+	log.Infof("[event] %s: %s", event.Kind, event.Message)
+
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+
+	recentEvents = append(recentEvents, event)
+	if len(recentEvents) > maxRecentEvents {
+		recentEvents = recentEvents[len(recentEvents)-maxRecentEvents:]
+	}
+}
+
+// RecentEvents returns the recent events, the latest is the last one
+func RecentEvents() []Event {
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+
+	return append([]Event(nil), recentEvents...)
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+/**
+ * File: freebind.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Sunday, October 18th 2026, 10:47:20 am
+ * Last Modified: Sunday, October 18th 2026, 10:47:20 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// freebindControl is not implemented by default
+func freebindControl(_, _ string, _ syscall.RawConn) error {
+	return fmt.Errorf("sorry, freebind is not implemented on this platform")
+}
@@ -0,0 +1,38 @@
+//go:build linux
+
+/**
+ * File: freebind_linux.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Sunday, October 18th 2026, 10:47:20 am
+ * Last Modified: Sunday, October 18th 2026, 10:47:20 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// freebindControl to allow binding the addresses which are not assigned to the interfaces,
+// the routed prefix should be a local route, like `ip -6 route add local 2001:db8:1::/64 dev lo`
+func freebindControl(network, _ string, c syscall.RawConn) (err error) {
+	ctrlErr := c.Control(func(fd uintptr) {
+		if strings.HasSuffix(network, "6") {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_FREEBIND, 1)
+		} else {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_FREEBIND, 1)
+		}
+	})
+
+	if ctrlErr != nil {
+		return ctrlErr
+	}
+
+	return
+}
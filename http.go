@@ -14,12 +14,11 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
+	"net"
 	"net/http"
-	"os"
 	"strconv"
 	"time"
 )
-import "github.com/rocksolidlabs/gin-logrus"
 
 var engine *gin.Engine
 
@@ -46,6 +45,12 @@ func (s *Server) setupAPIRouter(apiGroup *gin.RouterGroup) (err error) {
 			backends = s.Pool.AllHealthy()
 		}
 
+		if !canViewSecrets(c) {
+			for i, backend := range backends {
+				backends[i] = redactBackend(backend)
+			}
+		}
+
 		c.JSON(http.StatusOK, backends)
 	})
 
@@ -76,8 +81,18 @@ func (s *Server) setupAPIRouter(apiGroup *gin.RouterGroup) (err error) {
 			return
 		}
 
-		for _, backend := range backends {
-			err = s.Pool.Add(&backend)
+		for i := range backends {
+			if err := backends[i].localOnlyOption(); err != nil {
+				c.String(http.StatusBadRequest, "backend %s is refused, %v", backends[i].Addr, err)
+				return
+			}
+		}
+
+		for i := range backends {
+			backend := &backends[i]
+			backend.alive = backend.CheckConfig.InitialAlive
+
+			err = s.Pool.Add(backend)
 			if err != nil {
 				c.String(http.StatusServiceUnavailable, err.Error())
 				return
@@ -87,6 +102,261 @@ func (s *Server) setupAPIRouter(apiGroup *gin.RouterGroup) (err error) {
 		c.String(http.StatusOK, fmt.Sprintf("%d", len(backends)))
 	})
 
+	// label selector based listing and bulk actions
+	s.setupBackendsRouter(apiGroup)
+	s.setupPickRouter(apiGroup)
+	s.setupLeaseRouter(apiGroup)
+
+	// show the hit and miss metrics of the http proxy cache
+	apiGroup.GET("cache", func(c *gin.Context) {
+		if s.httpCache == nil {
+			c.String(http.StatusNotFound, "http proxy cache is not enabled")
+			return
+		}
+
+		c.JSON(http.StatusOK, s.httpCache.Stats())
+	})
+
+	// show the learned backends for each destination
+	apiGroup.GET("bandit", func(c *gin.Context) {
+		bandit := s.Pool.Bandit()
+		if bandit == nil {
+			c.String(http.StatusNotFound, "destination learning is not enabled")
+			return
+		}
+
+		c.JSON(http.StatusOK, bandit.Snapshot())
+	})
+
+	// show the learned check targets and their results through each backend
+	apiGroup.GET("targets", func(c *gin.Context) {
+		targets := s.Pool.CheckTargets()
+		if targets == nil {
+			c.String(http.StatusNotFound, "adaptive health check is not enabled")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"targets": targets.Top(),
+			"results": targets.Results(),
+		})
+	})
+
+	// show the networks recently used by each user
+	apiGroup.GET("diversity", func(c *gin.Context) {
+		diversity := s.Pool.Diversity()
+		if diversity == nil {
+			c.String(http.StatusNotFound, "egress diversity is not enabled")
+			return
+		}
+
+		c.JSON(http.StatusOK, diversity.Snapshot())
+	})
+
+	// show the hit statistics of the routing rules
+	apiGroup.GET("rules/stats", func(c *gin.Context) {
+		rules := s.Pool.Rules()
+		if rules == nil {
+			c.String(http.StatusNotFound, "routing rules are not configured")
+			return
+		}
+
+		c.JSON(http.StatusOK, rules.Stats())
+	})
+
+	// export the hit statistics of the routing rules in the prometheus text format
+	apiGroup.GET("metrics", func(c *gin.Context) {
+		c.Header("Content-Type", metricsContentType)
+		c.Status(http.StatusOK)
+
+		if rules := s.Pool.Rules(); rules != nil {
+			if err := rules.WriteMetrics(c.Writer); err != nil {
+				log.Errorf("write the metrics failed, %v", err)
+			}
+		}
+	})
+
+	// show the rules not matched in the window, like ?window=24h, and the shadowed rules
+	apiGroup.GET("rules/report", func(c *gin.Context) {
+		rules := s.Pool.Rules()
+		if rules == nil {
+			c.String(http.StatusNotFound, "routing rules are not configured")
+			return
+		}
+
+		var (
+			window time.Duration
+			err    error
+		)
+		if str := c.Query("window"); str != "" {
+			if window, err = time.ParseDuration(str); err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, rules.Report(window))
+	})
+
+	// show the helper processes of the backends with their recent output
+	apiGroup.GET("commands", func(c *gin.Context) {
+		commands := []*CommandStatus{}
+		for _, backend := range s.Pool.All() {
+			if status := backend.CommandStatus(); status != nil {
+				commands = append(commands, status)
+			}
+		}
+
+		c.JSON(http.StatusOK, commands)
+	})
+
+	// show the active profile and the available ones
+	apiGroup.GET("profile", func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.ProfileStatus())
+	})
+
+	// switch to the profile, the established sessions are kept
+	apiGroup.POST("profile/:name", func(c *gin.Context) {
+		if err := s.SwitchProfile(c.Param("name")); err != nil {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, s.ProfileStatus())
+	})
+
+	// show the states of the subsystems
+	apiGroup.GET("subsystems", func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.supervisor.Status())
+	})
+
+	// show the advertised and the discovered services on the lan
+	apiGroup.GET("mdns", func(c *gin.Context) {
+		mdns := s.MDNS()
+		if mdns == nil {
+			c.String(http.StatusNotFound, "mdns is not enabled")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"advertised": mdns.Advertised(),
+			"discovered": mdns.Discovered(),
+		})
+	})
+
+	// show the logins and the changes by the dashboard users
+	apiGroup.GET("audit", func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.audit.Entries())
+	})
+
+	// show the applied version and the errors of the remote configuration
+	apiGroup.GET("remote_config", func(c *gin.Context) {
+		if s.Config.RemoteConfig.URL == "" {
+			c.String(http.StatusNotFound, "remote configuration is not enabled")
+			return
+		}
+
+		c.JSON(http.StatusOK, s.RemoteConfigStatus())
+	})
+
+	// pull the remote configuration now
+	apiGroup.POST("remote_config/pull", func(c *gin.Context) {
+		if s.Config.RemoteConfig.URL == "" {
+			c.String(http.StatusNotFound, "remote configuration is not enabled")
+			return
+		}
+
+		if err := s.PullRemoteConfig(); err != nil {
+			c.String(http.StatusBadGateway, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, s.RemoteConfigStatus())
+	})
+
+	// show the state of the seccomp sandbox
+	apiGroup.GET("sandbox", func(c *gin.Context) {
+		c.JSON(http.StatusOK, Sandbox())
+	})
+
+	// download the diagnostic bundle for the bug reports
+	apiGroup.GET("diagnostics", func(c *gin.Context) {
+		filename := fmt.Sprintf("%s-diag-%s.tar.gz", AppName, time.Now().Format("20060102150405"))
+		c.Header("Content-Type", "application/gzip")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+		if err := s.WriteDiagnostics(c.Writer); err != nil {
+			log.Error(err)
+			_ = c.Error(err)
+		}
+	})
+
+	// show the source address usage and the exclusions of the direct backends
+	apiGroup.GET("egress", func(c *gin.Context) {
+		egress := make(map[string]EgressStatus)
+		for _, backend := range s.Pool.All() {
+			if backend.Protocol != ProtocolDirect {
+				continue
+			}
+
+			pool, err := backend.sourcePool()
+			if err != nil {
+				c.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+			egress[backend.Addr] = EgressStatus{Usage: pool.Usage(), Exclusions: pool.Exclusions()}
+		}
+
+		c.JSON(http.StatusOK, egress)
+	})
+
+	// exclude a source address for a destination, or all destinations
+	apiGroup.POST("egress/exclude", func(c *gin.Context) {
+		pool, err := s.egressSourcePool(c.Query("backend"))
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		duration := time.Hour
+		if c.Query("duration") != "" {
+			if duration, err = time.ParseDuration(c.Query("duration")); err != nil || duration <= 0 {
+				c.String(http.StatusBadRequest, "invalid duration %s", c.Query("duration"))
+				return
+			}
+		}
+
+		if err = pool.Exclude(c.Query("addr"), c.Query("destination"), duration); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		c.String(http.StatusOK, fmt.Sprintf("egress address %s is excluded", c.Query("addr")))
+	})
+
+	// remove the exclusion of a source address
+	apiGroup.DELETE("egress/exclude", func(c *gin.Context) {
+		pool, err := s.egressSourcePool(c.Query("backend"))
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		pool.Include(c.Query("addr"), c.Query("destination"))
+		c.String(http.StatusOK, fmt.Sprintf("egress address %s is included", c.Query("addr")))
+	})
+
+	// purge the http proxy cache
+	apiGroup.DELETE("cache", func(c *gin.Context) {
+		if s.httpCache == nil {
+			c.String(http.StatusNotFound, "http proxy cache is not enabled")
+			return
+		}
+
+		s.httpCache.Purge()
+		c.String(http.StatusOK, "http proxy cache is purged")
+	})
+
 	return
 }
 
@@ -96,21 +366,76 @@ func (s *Server) setupRouter() (err error) {
 		return fmt.Errorf("the Gin engine is alreay instanced, maybe is running")
 	}
 
+	engine, err = s.newRouter()
+	return
+}
+
+// accessLogger to log the requests by the standard logger, so the client ips are
+// scrubbed by the privacy mode like the other logs
+func accessLogger(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	status := c.Writer.Status()
+	entry := log.WithFields(log.Fields{
+		"client":  c.ClientIP(),
+		"method":  c.Request.Method,
+		"path":    c.Request.URL.Path,
+		"status":  status,
+		"latency": time.Since(start).String(),
+	})
+
+	if len(c.Errors) > 0 {
+		entry = entry.WithField("error", c.Errors.String())
+	}
+
+	switch {
+	case status >= http.StatusInternalServerError:
+		entry.Error("[http] request failed")
+	case status >= http.StatusBadRequest:
+		entry.Warn("[http] request refused")
+	default:
+		entry.Debug("[http] request served")
+	}
+}
+
+// newRouter returns a new http engine with all the routers
+func (s *Server) newRouter() (router *gin.Engine, err error) {
 	// gin default config
-	engine = gin.New()
-	engine.Use(ginlogrus.Logger(log.New(), "http", false, true, os.Stdout, log.TraceLevel))
-	engine.Use(gin.Recovery())
+	router = gin.New()
+	router.Use(accessLogger)
+	router.Use(gin.Recovery())
+
+	apiGroup := router.Group("/api")
+	if s.Config.HTTP.Auth.Enabled() {
+		s.setupAuthRouter(router)
+		apiGroup.Use(s.authRequired)
+	}
 
-	err = s.setupAPIRouter(engine.Group("/api"))
+	err = s.setupAPIRouter(apiGroup)
+
+	// the health of the subsystems for the probes, 503 if any of them is not running
+	router.GET("/healthz", func(c *gin.Context) {
+		code := http.StatusOK
+		if !s.supervisor.Healthy() {
+			code = http.StatusServiceUnavailable
+		}
+
+		c.JSON(code, gin.H{
+			"healthy":    code == http.StatusOK,
+			"subsystems": s.supervisor.Status(),
+		})
+	})
 
 	// show basic information
-	engine.GET("/version", func(c *gin.Context) {
+	router.GET("/version", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"name":         AppName,
 			"version":      Version,
 			"build_commit": BuildCommit,
 			"build_date":   BuildDate,
 			"uptime":       time.Now().Sub(StartTime),
+			"privacy":      Privacy(),
 		})
 	})
 	return
@@ -118,11 +443,21 @@ func (s *Server) setupRouter() (err error) {
 
 // ListenHTTPAdmin is not implemented by default
 func (s *Server) ListenHTTPAdmin(addr string) (err error) {
-	if err = s.setupRouter(); err != nil {
+	// the engine is kept by the restarts
+	if engine == nil {
+		if err = s.setupRouter(); err != nil {
+			s.listening()
+			return
+		}
+	}
+
+	s.httpAdminListener, err = net.Listen("tcp", addr)
+	s.listening()
+	if err != nil {
 		return
 	}
 
-	return engine.Run(addr)
+	return engine.RunListener(s.httpAdminListener)
 }
 
 // Engine returns the main http engine for testing purposes
@@ -0,0 +1,221 @@
+/**
+ * File: http_backends.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Saturday, October 17th 2026, 10:42:37 am
+ * Last Modified: Saturday, October 17th 2026, 10:42:37 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// BackendView is the backend with its runtime status for the admin API
+type BackendView struct {
+	*Backend
+	Alive    bool   `json:"alive"`
+	Disabled bool   `json:"disabled"`
+	Draining bool   `json:"draining"`
+	Leased   bool   `json:"leased"`
+	EgressIP string `json:"egress_ip,omitempty"`
+	// EffectiveTimeout is the timeout used by the checks and the dials
+	EffectiveTimeout TimeoutStatus `json:"effective_timeout"`
+	// State is the health state like "target_blocked", by the diagnosis of the last failed check
+	State     string     `json:"state"`
+	Diagnosis *Diagnosis `json:"diagnosis,omitempty"`
+}
+
+// BulkResult is the result of a bulk action on a single backend
+type BulkResult struct {
+	Addr  string `json:"addr"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// NewBackendView returns the view of the given backend
+func NewBackendView(backend *Backend) BackendView {
+	return BackendView{
+		Backend:  backend,
+		Alive:    backend.Alive(),
+		Disabled: backend.Disabled(),
+		Draining: backend.Draining(),
+		Leased:   backend.Leased(),
+		EgressIP: backend.EgressIP(),
+
+		EffectiveTimeout: backend.TimeoutStatus(),
+		State:            backend.State(),
+		Diagnosis:        backend.Diagnosis(),
+	}
+}
+
+// sortBackendViews sorts the views by the field name, prefix with "-" for descending
+func sortBackendViews(views []BackendView, by string) error {
+	desc := strings.HasPrefix(by, "-")
+	by = strings.TrimPrefix(by, "-")
+
+	var less func(i, j int) bool
+	switch by {
+	case "", "addr":
+		less = func(i, j int) bool { return views[i].Addr < views[j].Addr }
+	case "weight":
+		less = func(i, j int) bool { return views[i].EffectiveWeight() < views[j].EffectiveWeight() }
+	case "alive":
+		less = func(i, j int) bool { return !views[i].Alive && views[j].Alive }
+	default:
+		return fmt.Errorf("unsupported sort field %q", by)
+	}
+
+	sort.SliceStable(views, func(i, j int) bool {
+		if desc {
+			return less(j, i)
+		}
+		return less(i, j)
+	})
+
+	return nil
+}
+
+// setupBackendsRouter to handle the label selector based backend APIs
+func (s *Server) setupBackendsRouter(apiGroup *gin.RouterGroup) {
+
+	// list the backends with filtering, sorting and pagination
+	apiGroup.GET("backends", func(c *gin.Context) {
+		selector, err := ParseSelector(c.Query("selector"))
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		onlyHealthy, _ := strconv.ParseBool(c.Query("healthy"))
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "0"))
+		if offset < 0 || limit < 0 {
+			c.String(http.StatusBadRequest, "offset and limit should not be negative")
+			return
+		}
+
+		secrets := canViewSecrets(c)
+		views := make([]BackendView, 0)
+		for _, backend := range s.Pool.Select(selector) {
+			if onlyHealthy && !backend.Alive() {
+				continue
+			}
+
+			view := NewBackendView(backend)
+			if !secrets {
+				view.Backend = redactBackend(backend)
+			}
+			views = append(views, view)
+		}
+
+		if err = sortBackendViews(views, c.Query("sort")); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		total := len(views)
+		if offset > total {
+			offset = total
+		}
+		views = views[offset:]
+		if limit > 0 && limit < len(views) {
+			views = views[:limit]
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"total":    total,
+			"offset":   offset,
+			"limit":    limit,
+			"backends": views,
+		})
+	})
+
+	// bulk actions on the backends matched by the selector
+	apiGroup.POST("backends/:action", func(c *gin.Context) {
+		selector, err := ParseSelector(c.Query("selector"))
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// do not touch all backends by an empty selector accidentally
+		if selector.Empty() {
+			c.String(http.StatusBadRequest, "selector is empty")
+			return
+		}
+
+		var action func(backend *Backend) error
+		switch c.Param("action") {
+		case "drain":
+			action = func(backend *Backend) error {
+				backend.Drain()
+				return nil
+			}
+		case "enable":
+			action = func(backend *Backend) error {
+				backend.Enable()
+				return nil
+			}
+		case "disable":
+			action = func(backend *Backend) error {
+				backend.Disable()
+				return nil
+			}
+		case "check":
+			action = func(backend *Backend) error {
+				if err := backend.Check(); err != nil {
+					return err
+				}
+				if !backend.Alive() {
+					return fmt.Errorf("backend %s is not alive", backend.Addr)
+				}
+				return nil
+			}
+		case "weight":
+			weight, err := strconv.ParseUint(c.Query("weight"), 10, 32)
+			if err != nil {
+				c.String(http.StatusBadRequest, "invalid weight")
+				return
+			}
+			action = func(backend *Backend) error {
+				s.Pool.SetWeight(backend, uint(weight))
+				return nil
+			}
+		case "remove":
+			action = func(backend *Backend) error {
+				return s.Pool.Remove(backend.Addr)
+			}
+		default:
+			c.String(http.StatusNotFound, fmt.Sprintf("unsupported action %s", c.Param("action")))
+			return
+		}
+
+		results := make([]BulkResult, 0)
+		for _, backend := range s.Pool.Select(selector) {
+			result := BulkResult{Addr: backend.Addr, OK: true}
+			if err := action(backend); err != nil {
+				result.OK, result.Error = false, err.Error()
+			}
+
+			log.Tracef("%s backend %s, result %v", c.Param("action"), backend.Addr, result.OK)
+			results = append(results, result)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"action":   c.Param("action"),
+			"selector": selector.String(),
+			"results":  results,
+		})
+	})
+}
@@ -69,6 +69,27 @@ func TestServer_HTTPPutAndDelete(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Equal(t, w.Body.String(), "2")
 
+	// the helper processes are only from the local configuration file
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPut, "/api/add", strings.NewReader(`[
+  {"addr": "192.168.112.254:1086"},
+  {"addr": "127.0.0.1:1081", "command": {"args": ["sh", "-c", "id"]}}
+	]`))
+	engine.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Nil(t, NewPool().Get("192.168.112.254:1086"))
+	assert.Nil(t, NewPool().Get("127.0.0.1:1081"))
+
+	// so are the password files, which could be any file on this host
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPut, "/api/add", strings.NewReader(`[
+  {"addr": "192.168.113.254:3128", "protocol": "http", "http": {"auth": "basic", "password_file": "/etc/shadow"}}
+	]`))
+	engine.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), errPasswordFileNotLocal.Error())
+	assert.Nil(t, NewPool().Get("192.168.113.254:3128"))
+
 	w = httptest.NewRecorder()
 	req, _ = http.NewRequest(http.MethodDelete, "/api/delete?addr=192.168.100.254:1086", nil)
 	engine.ServeHTTP(w, req)
@@ -79,3 +100,48 @@ func TestServer_HTTPPutAndDelete(t *testing.T) {
 	engine.ServeHTTP(w, req)
 	assert.NotEqual(t, http.StatusOK, w.Code)
 }
+
+func TestServer_HTTPBackendsBulk(t *testing.T) {
+	engine := EngineInstance(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPut, "/api/add", strings.NewReader(`[
+  {
+    "addr": "10.10.0.1:1086",
+    "labels": {"provider": "bulk", "country": "us"},
+    "check_config": {"initial_alive": true}
+  },
+  {
+    "addr": "10.10.0.2:1086",
+    "labels": {"provider": "bulk", "country": "jp"},
+    "check_config": {"initial_alive": true}
+  }
+	]`))
+	engine.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "/api/backends/drain?selector=provider=bulk,country!=us", nil)
+	engine.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"addr":"10.10.0.2:1086","ok":true`)
+	assert.NotContains(t, w.Body.String(), "10.10.0.1:1086")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/api/backends?selector=provider=bulk&sort=-addr&limit=1", nil)
+	engine.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"total":2`)
+	assert.Contains(t, w.Body.String(), `"draining":true`)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "/api/backends/remove", nil)
+	engine.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "/api/backends/remove?selector=provider=bulk", nil)
+	engine.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, NewPool().Get("10.10.0.1:1086"))
+}
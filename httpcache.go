@@ -0,0 +1,647 @@
+/**
+ * File: httpcache.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Saturday, October 17th 2026, 12:05:16 pm
+ * Last Modified: Saturday, October 17th 2026, 12:05:16 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultCacheMaxSize       = 256 << 20
+	defaultCacheMaxObjectSize = 32 << 20
+)
+
+// cacheableStatus are the status codes which can be cached
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+type cacheEntry struct {
+	Key        string      `json:"key"`
+	URL        string      `json:"url"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Vary       []string    `json:"vary"`
+	StoredAt   time.Time   `json:"stored_at"`
+	Expires    time.Time   `json:"expires"`
+	Size       int64       `json:"size"`
+
+	body []byte
+	elem *list.Element
+}
+
+// HTTPCacheStats is the hit and miss metrics of the http cache
+type HTTPCacheStats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Revalidated uint64 `json:"revalidated"`
+	Bypassed    uint64 `json:"bypassed"`
+	Stored      uint64 `json:"stored"`
+	Evicted     uint64 `json:"evicted"`
+	HitBytes    uint64 `json:"hit_bytes"`
+	Objects     int    `json:"objects"`
+	Size        int64  `json:"size"`
+	MaxSize     int64  `json:"max_size"`
+}
+
+// HTTPCache is a memory or disk cache for the plain http responses
+type HTTPCache struct {
+	dir           string
+	hosts         []string
+	maxSize       int64
+	maxObjectSize int64
+
+	lock    sync.Mutex
+	entries map[string]*cacheEntry
+	vary    map[string][]string
+	lru     *list.List
+	size    int64
+
+	hits, misses, revalidated, bypassed, stored, evicted, hitBytes uint64
+}
+
+// cacheControl parses the Cache-Control header into directives
+func cacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, line := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+
+			kv := strings.SplitN(part, "=", 2)
+			key := strings.ToLower(strings.TrimSpace(kv[0]))
+			if len(kv) > 1 {
+				directives[key] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+			} else {
+				directives[key] = ""
+			}
+		}
+	}
+
+	return directives
+}
+
+// varyHeaders returns the header names in the Vary header
+func varyHeaders(header http.Header) (names []string) {
+	for _, line := range header.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	return
+}
+
+// cacheKey returns the key by the url and the request header values of the vary names
+func cacheKey(req *http.Request, vary []string) string {
+	key := req.URL.String()
+	for _, name := range vary {
+		key += "\n" + name + ":" + strings.Join(req.Header.Values(name), ",")
+	}
+
+	return key
+}
+
+// freshnessLifetime returns how long the response can be used without revalidation
+func freshnessLifetime(resp *http.Response, now time.Time) time.Duration {
+	cc := cacheControl(resp.Header)
+	if _, ok := cc["no-cache"]; ok {
+		return 0
+	}
+
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[name]; ok {
+			if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return time.Duration(sec) * time.Second
+			}
+			return 0
+		}
+	}
+
+	if expires := resp.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+
+		date := now
+		if d, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+			date = d
+		}
+		return t.Sub(date)
+	}
+
+	// heuristic freshness, 10% of the time since the last modification
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		lifetime := now.Sub(lastModified) / 10
+		if lifetime > 24*time.Hour {
+			lifetime = 24 * time.Hour
+		}
+		return lifetime
+	}
+
+	return 0
+}
+
+// storable returns true if the response is allowed to be stored by a shared cache
+func storable(req *http.Request, resp *http.Response) bool {
+	if !cacheableStatus[resp.StatusCode] {
+		return false
+	}
+
+	reqCC, respCC := cacheControl(req.Header), cacheControl(resp.Header)
+	if _, ok := reqCC["no-store"]; ok {
+		return false
+	}
+
+	for _, name := range []string{"no-store", "private"} {
+		if _, ok := respCC[name]; ok {
+			return false
+		}
+	}
+
+	if _, public := respCC["public"]; req.Header.Get("Authorization") != "" && !public {
+		return false
+	}
+
+	for _, name := range varyHeaders(resp.Header) {
+		if name == "*" {
+			return false
+		}
+	}
+
+	// it is useless to store the response which can neither be fresh nor be revalidated
+	return freshnessLifetime(resp, time.Now()) > 0 ||
+		resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+}
+
+// Enabled returns true if the request should be handled by the cache
+func (c *HTTPCache) Enabled(req *http.Request) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+
+	if len(c.hosts) == 0 {
+		return true
+	}
+
+	host := strings.ToLower(req.URL.Hostname())
+	for _, h := range c.hosts {
+		h = strings.ToLower(h)
+		if host == h || (strings.HasPrefix(h, ".") && strings.HasSuffix(host, h)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Stats returns the current metrics of the cache
+func (c *HTTPCache) Stats() HTTPCacheStats {
+	c.lock.Lock()
+	objects, size := len(c.entries), c.size
+	c.lock.Unlock()
+
+	return HTTPCacheStats{
+		Hits:        atomic.LoadUint64(&c.hits),
+		Misses:      atomic.LoadUint64(&c.misses),
+		Revalidated: atomic.LoadUint64(&c.revalidated),
+		Bypassed:    atomic.LoadUint64(&c.bypassed),
+		Stored:      atomic.LoadUint64(&c.stored),
+		Evicted:     atomic.LoadUint64(&c.evicted),
+		HitBytes:    atomic.LoadUint64(&c.hitBytes),
+		Objects:     objects,
+		Size:        size,
+		MaxSize:     c.maxSize,
+	}
+}
+
+// Purge to remove all the cached objects
+func (c *HTTPCache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, entry := range c.entries {
+		c.removeLocked(entry, true)
+	}
+	c.vary = make(map[string][]string)
+}
+
+// path returns the file path of the cached object without extension
+func (c *HTTPCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// lookup returns the cached entry for the request
+func (c *HTTPCache) lookup(req *http.Request) *cacheEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry := c.entries[cacheKey(req, c.vary[req.URL.String()])]
+	if entry != nil {
+		c.lru.MoveToFront(entry.elem)
+	}
+
+	return entry
+}
+
+// removeLocked to remove the entry, the lock should be held
+func (c *HTTPCache) removeLocked(entry *cacheEntry, files bool) {
+	if c.entries[entry.Key] != entry {
+		return
+	}
+
+	delete(c.entries, entry.Key)
+	c.lru.Remove(entry.elem)
+	c.size -= entry.Size
+
+	if files && c.dir != "" {
+		path := c.path(entry.Key)
+		_ = os.Remove(path + ".json")
+		_ = os.Remove(path + ".body")
+	}
+}
+
+// insert to add the entry into the cache and evict the old ones if exceeded
+func (c *HTTPCache) insert(entry *cacheEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// the files of the same key are already overwritten by the new entry
+	if old := c.entries[entry.Key]; old != nil {
+		c.removeLocked(old, false)
+	}
+
+	c.entries[entry.Key] = entry
+	c.vary[entry.URL] = entry.Vary
+	entry.elem = c.lru.PushFront(entry)
+	c.size += entry.Size
+
+	for c.size > c.maxSize && c.lru.Len() > 1 {
+		oldest := c.lru.Back().Value.(*cacheEntry)
+		log.Tracef("[http-cache] evict %s", oldest.URL)
+		c.removeLocked(oldest, true)
+		atomic.AddUint64(&c.evicted, 1)
+	}
+}
+
+// writeFile to replace the file by a renamed temporary file, the readers
+// of the old file are not affected
+func writeFile(path string, data []byte) (err error) {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(file.Name())
+		}
+	}()
+
+	if _, err = file.Write(data); err != nil {
+		_ = file.Close()
+		return
+	}
+
+	if err = file.Close(); err != nil {
+		return
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// save to write the entry to the disk
+func (c *HTTPCache) save(entry *cacheEntry, body []byte) (err error) {
+	path := c.path(entry.Key)
+	if err = writeFile(path+".body", body); err != nil {
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	return writeFile(path+".json", data)
+}
+
+// load to restore the cached objects from the disk
+func (c *HTTPCache) load() (err error) {
+	matches, err := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	if err != nil {
+		return
+	}
+
+	for _, match := range matches {
+		var entry cacheEntry
+
+		data, err := os.ReadFile(match)
+		if err == nil {
+			err = json.Unmarshal(data, &entry)
+		}
+		if err == nil {
+			_, err = os.Stat(strings.TrimSuffix(match, ".json") + ".body")
+		}
+
+		if err != nil {
+			log.Warnf("[http-cache] drop the broken object %s, %v", match, err)
+			_ = os.Remove(match)
+			continue
+		}
+
+		c.insert(&entry)
+	}
+
+	log.Debugf("[http-cache] loaded %d objects from %s", len(c.entries), c.dir)
+	return
+}
+
+// response builds the response by the cached entry
+func (c *HTTPCache) response(req *http.Request, entry *cacheEntry, status string) (resp *http.Response, err error) {
+	var body io.ReadCloser
+	if c.dir != "" {
+		if body, err = os.Open(c.path(entry.Key) + ".body"); err != nil {
+			return
+		}
+	} else {
+		body = io.NopCloser(bytes.NewReader(entry.body))
+	}
+
+	c.lock.Lock()
+	header, size, storedAt := entry.Header.Clone(), entry.Size, entry.StoredAt
+	c.lock.Unlock()
+
+	header.Set("Age", strconv.Itoa(int(time.Since(storedAt).Seconds())))
+	header.Set("X-Cache", status)
+
+	atomic.AddUint64(&c.hitBytes, uint64(size))
+	return &http.Response{
+		Status:        strconv.Itoa(entry.StatusCode) + " " + http.StatusText(entry.StatusCode),
+		StatusCode:    entry.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          body,
+		ContentLength: size,
+		Request:       req,
+	}, nil
+}
+
+// fresh returns true if the entry satisfies the request without revalidation
+func (c *HTTPCache) fresh(req *http.Request, entry *cacheEntry) bool {
+	now := time.Now()
+	reqCC := cacheControl(req.Header)
+
+	c.lock.Lock()
+	storedAt, expires := entry.StoredAt, entry.Expires
+	c.lock.Unlock()
+
+	if _, ok := reqCC["no-cache"]; ok {
+		return false
+	}
+
+	if v, ok := reqCC["max-age"]; ok {
+		if sec, err := strconv.ParseInt(v, 10, 64); err == nil && now.Sub(storedAt) > time.Duration(sec)*time.Second {
+			return false
+		}
+	}
+
+	return now.Before(expires)
+}
+
+// validators returns the ETag and Last-Modified of the entry for revalidation
+func (c *HTTPCache) validators(entry *cacheEntry) (etag, lastModified string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return entry.Header.Get("ETag"), entry.Header.Get("Last-Modified")
+}
+
+// revalidate to send the conditional request for the stale entry
+func (c *HTTPCache) revalidate(transport http.RoundTripper, req *http.Request, entry *cacheEntry) (*http.Response, error) {
+	condReq := req.Clone(req.Context())
+	etag, lastModified := c.validators(entry)
+	if etag != "" {
+		condReq.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		condReq.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	resp, err := transport.RoundTrip(condReq)
+	if err != nil || resp.StatusCode != http.StatusNotModified {
+		return resp, err
+	}
+	_ = resp.Body.Close()
+
+	// refresh the stored headers and the freshness by the 304 response
+	c.lock.Lock()
+	header := entry.Header.Clone()
+	for k, v := range resp.Header {
+		header[k] = v
+	}
+
+	now := time.Now()
+	entry.Header, entry.StoredAt = header, now
+	entry.Expires = now.Add(freshnessLifetime(&http.Response{Header: header}, now))
+
+	data, err := json.Marshal(entry)
+	c.lock.Unlock()
+
+	if c.dir != "" && err == nil {
+		_ = writeFile(c.path(entry.Key)+".json", data)
+	}
+
+	atomic.AddUint64(&c.revalidated, 1)
+	return c.response(req, entry, "REVALIDATED")
+}
+
+// RoundTrip to serve the request from the cache or fetch it by the transport
+func (c *HTTPCache) RoundTrip(transport http.RoundTripper, req *http.Request) (resp *http.Response, err error) {
+	if req.Header.Get("Range") != "" || req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		atomic.AddUint64(&c.bypassed, 1)
+		return transport.RoundTrip(req)
+	}
+
+	if _, ok := cacheControl(req.Header)["no-store"]; ok {
+		atomic.AddUint64(&c.bypassed, 1)
+		return transport.RoundTrip(req)
+	}
+
+	if entry := c.lookup(req); entry != nil {
+		if c.fresh(req, entry) {
+			log.Tracef("[http-cache] hit %s", entry.URL)
+			atomic.AddUint64(&c.hits, 1)
+			return c.response(req, entry, "HIT")
+		}
+
+		if etag, lastModified := c.validators(entry); etag != "" || lastModified != "" {
+			if resp, err = c.revalidate(transport, req, entry); err != nil {
+				return
+			}
+			if resp.Header.Get("X-Cache") == "REVALIDATED" {
+				return
+			}
+
+			atomic.AddUint64(&c.misses, 1)
+			return c.store(req, resp), nil
+		}
+	}
+
+	log.Tracef("[http-cache] miss %s", req.URL)
+	atomic.AddUint64(&c.misses, 1)
+	if resp, err = transport.RoundTrip(req); err != nil {
+		return
+	}
+
+	return c.store(req, resp), nil
+}
+
+// store wraps the response body to store the response when it is fully read
+func (c *HTTPCache) store(req *http.Request, resp *http.Response) *http.Response {
+	if !storable(req, resp) || resp.ContentLength > c.maxObjectSize {
+		return resp
+	}
+
+	now := time.Now()
+	vary := varyHeaders(resp.Header)
+	header := resp.Header.Clone()
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+	header.Del("X-Cache")
+
+	entry := &cacheEntry{
+		Key:        cacheKey(req, vary),
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Header:     header,
+		Vary:       vary,
+		StoredAt:   now,
+		Expires:    now.Add(freshnessLifetime(resp, now)),
+	}
+
+	resp.Header.Set("X-Cache", "MISS")
+	resp.Body = &cacheBodyReader{
+		ReadCloser: resp.Body,
+		cache:      c,
+		entry:      entry,
+	}
+
+	return resp
+}
+
+// cacheBodyReader buffers the body while reading, and stores it at the end
+type cacheBodyReader struct {
+	io.ReadCloser
+	cache    *HTTPCache
+	entry    *cacheEntry
+	buf      bytes.Buffer
+	overflow bool
+}
+
+func (r *cacheBodyReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	if n > 0 && !r.overflow {
+		if int64(r.buf.Len()+n) > r.cache.maxObjectSize {
+			r.overflow = true
+			r.buf.Reset()
+		} else {
+			r.buf.Write(p[:n])
+		}
+	}
+
+	if err == io.EOF && !r.overflow {
+		r.finish()
+	}
+
+	return
+}
+
+// finish to store the entry when the body is read completely
+func (r *cacheBodyReader) finish() {
+	r.overflow = true
+
+	body := r.buf.Bytes()
+	r.entry.Size = int64(len(body))
+
+	if r.cache.dir != "" {
+		if err := r.cache.save(r.entry, body); err != nil {
+			log.Errorf("[http-cache] store %s failed, %v", r.entry.URL, err)
+			return
+		}
+	} else {
+		r.entry.body = body
+	}
+
+	log.Tracef("[http-cache] stored %s, %d bytes", r.entry.URL, r.entry.Size)
+	r.cache.insert(r.entry)
+	atomic.AddUint64(&r.cache.stored, 1)
+}
+
+// NewHTTPCache returns a new http cache by the configuration
+func NewHTTPCache(config HTTPCacheConfig) (cache *HTTPCache, err error) {
+	cache = &HTTPCache{
+		dir:           config.Dir,
+		hosts:         config.Hosts,
+		maxSize:       config.MaxSize,
+		maxObjectSize: config.MaxObjectSize,
+		entries:       make(map[string]*cacheEntry),
+		vary:          make(map[string][]string),
+		lru:           list.New(),
+	}
+
+	if cache.maxSize <= 0 {
+		cache.maxSize = defaultCacheMaxSize
+	}
+
+	if cache.maxObjectSize <= 0 {
+		cache.maxObjectSize = defaultCacheMaxObjectSize
+	}
+
+	if cache.dir != "" {
+		if err = os.MkdirAll(cache.dir, 0o700); err != nil {
+			return nil, err
+		}
+
+		if err = cache.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
@@ -0,0 +1,144 @@
+package socks5lb
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func NewCacheOrigin(t *testing.T) (*httptest.Server, *uint64) {
+	var requests uint64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint64(&requests, 1)
+
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		}
+
+		_, _ = fmt.Fprintf(w, "%s %s", r.URL.Path, r.Header.Get("Accept-Language"))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+func FetchByCache(t *testing.T, cache *HTTPCache, url string, header http.Header) (string, string) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := cache.RoundTrip(http.DefaultTransport, req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp.Header.Get("X-Cache"), string(body)
+}
+
+func TestHTTPCache_Freshness(t *testing.T) {
+	origin, requests := NewCacheOrigin(t)
+	cache, err := NewHTTPCache(HTTPCacheConfig{Enable: true})
+	assert.NoError(t, err)
+
+	status, body := FetchByCache(t, cache, origin.URL+"/fresh", nil)
+	assert.Equal(t, "MISS", status)
+	assert.Equal(t, "/fresh ", body)
+
+	status, body = FetchByCache(t, cache, origin.URL+"/fresh", nil)
+	assert.Equal(t, "HIT", status)
+	assert.Equal(t, "/fresh ", body)
+	assert.Equal(t, uint64(1), atomic.LoadUint64(requests))
+
+	FetchByCache(t, cache, origin.URL+"/private", nil)
+	status, _ = FetchByCache(t, cache, origin.URL+"/private", nil)
+	assert.Equal(t, "", status)
+
+	stats := cache.Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, 1, stats.Objects)
+}
+
+func TestHTTPCache_RevalidateAndVary(t *testing.T) {
+	origin, requests := NewCacheOrigin(t)
+	cache, err := NewHTTPCache(HTTPCacheConfig{Enable: true, Dir: t.TempDir()})
+	assert.NoError(t, err)
+
+	FetchByCache(t, cache, origin.URL+"/etag", nil)
+	status, body := FetchByCache(t, cache, origin.URL+"/etag", nil)
+	assert.Equal(t, "REVALIDATED", status)
+	assert.Equal(t, "/etag ", body)
+	assert.Equal(t, uint64(2), atomic.LoadUint64(requests))
+
+	en, zh := http.Header{"Accept-Language": {"en"}}, http.Header{"Accept-Language": {"zh"}}
+	FetchByCache(t, cache, origin.URL+"/vary", en)
+	status, body = FetchByCache(t, cache, origin.URL+"/vary", zh)
+	assert.Equal(t, "MISS", status)
+	assert.Equal(t, "/vary zh", body)
+	status, body = FetchByCache(t, cache, origin.URL+"/vary", en)
+	assert.Equal(t, "HIT", status)
+	assert.Equal(t, "/vary en", body)
+
+	// the objects on the disk should be loaded again
+	reloaded, err := NewHTTPCache(HTTPCacheConfig{Enable: true, Dir: cache.dir})
+	assert.NoError(t, err)
+	assert.Equal(t, cache.Stats().Objects, reloaded.Stats().Objects)
+
+	// nothing is left after purged, neither the vary names nor the temporary files
+	cache.Purge()
+	assert.Empty(t, cache.vary)
+	files, err := os.ReadDir(cache.dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestHTTPCache_Limits(t *testing.T) {
+	cache, err := NewHTTPCache(HTTPCacheConfig{
+		Enable:        true,
+		MaxSize:       10,
+		MaxObjectSize: 8,
+		Hosts:         []string{".debian.org"},
+	})
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://deb.debian.org/debian/", nil)
+	assert.True(t, cache.Enabled(req))
+	req, _ = http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	assert.False(t, cache.Enabled(req))
+
+	origin, _ := NewCacheOrigin(t)
+	for _, path := range []string{"/fresh?a", "/fresh?b"} {
+		FetchByCache(t, cache, origin.URL+path, nil)
+	}
+
+	stats := cache.Stats()
+	assert.Equal(t, 1, stats.Objects)
+	assert.Equal(t, uint64(1), stats.Evicted)
+	assert.LessOrEqual(t, stats.Size, stats.MaxSize)
+
+	// the object larger than the limit should not be stored
+	cache, _ = NewHTTPCache(HTTPCacheConfig{Enable: true, MaxObjectSize: 4})
+	FetchByCache(t, cache, origin.URL+"/fresh", nil)
+	assert.Equal(t, 0, cache.Stats().Objects)
+}
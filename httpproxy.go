@@ -0,0 +1,178 @@
+/**
+ * File: httpproxy.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Saturday, October 17th 2026, 11:30:52 am
+ * Last Modified: Saturday, October 17th 2026, 11:30:52 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// hopHeaders are removed when forwarding the request to the backend
+// @see https://www.rfc-editor.org/rfc/rfc7230#section-6.1
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders to remove the hop-by-hop headers
+func removeHopHeaders(header http.Header) {
+	for _, f := range header["Connection"] {
+		for _, h := range strings.Split(f, ",") {
+			header.Del(strings.TrimSpace(h))
+		}
+	}
+
+	for _, h := range hopHeaders {
+		header.Del(h)
+	}
+}
+
+// userContextKey is the context key of the client user
+type userContextKey struct{}
+
+// dialBackend to connect the destination address through a healthy backend
+func (s *Server) dialBackend(ctx context.Context, network, addr string) (conn net.Conn, err error) {
+	user, _ := ctx.Value(userContextKey{}).(string)
+
+	backend, matched := s.Pool.route(user, addr)
+	if backend == nil {
+		return nil, errors.New("sorry, we don't have healthy backend")
+	}
+
+	log.Tracef("[http-proxy] dial %s via %s", addr, backend.Addr)
+	start := time.Now()
+	conn, err = backend.DialFor(user, network, addr, backend.Timeout())
+	s.Pool.Observe(addr, backend, time.Since(start), err)
+	if err != nil {
+		return
+	}
+
+	return matched.track(conn), nil
+}
+
+// newHTTPProxyTransport returns the transport for the plain http requests
+func (s *Server) newHTTPProxyTransport() *http.Transport {
+	return &http.Transport{
+		Proxy:              nil,
+		DialContext:        s.dialBackend,
+		DisableCompression: true,
+	}
+}
+
+// handleConnect to tunnel the CONNECT request
+func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	backendConn, err := s.dialBackend(r.Context(), "tcp", r.Host)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer backendConn.Close()
+
+	clientConn, _, err := hijacker.Hijack()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	defer clientConn.Close()
+
+	if _, err = clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		log.Error(err)
+		return
+	}
+
+	s.Transport(clientConn, backendConn)
+}
+
+// ServeHTTP implements the http forward proxy
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Tracef("[http-proxy] %s %s %s", r.RemoteAddr, r.Method, r.URL)
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		r = r.WithContext(context.WithValue(r.Context(), userContextKey{}, anonymizeClient(host)))
+	}
+
+	if r.Method == http.MethodConnect {
+		s.handleConnect(w, r)
+		return
+	}
+
+	if !r.URL.IsAbs() {
+		http.Error(w, "this is a proxy server, absolute URL is required", http.StatusBadRequest)
+		return
+	}
+
+	outReq := r.Clone(r.Context())
+	outReq.RequestURI = ""
+	removeHopHeaders(outReq.Header)
+
+	var (
+		resp *http.Response
+		err  error
+	)
+
+	if s.httpCache != nil && s.httpCache.Enabled(outReq) {
+		resp, err = s.httpCache.RoundTrip(s.httpTransport, outReq)
+	} else {
+		resp, err = s.httpTransport.RoundTrip(outReq)
+	}
+
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	removeHopHeaders(resp.Header)
+	for k, values := range resp.Header {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	if _, err = io.Copy(w, resp.Body); err != nil {
+		log.Error(err)
+	}
+}
+
+// ListenHTTPProxy to listen the http forward proxy on a specific address
+func (s *Server) ListenHTTPProxy(addr string) (err error) {
+	s.httpProxyListener, err = net.Listen("tcp", addr)
+	s.listening()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	defer s.httpProxyListener.Close()
+
+	return http.Serve(s.httpProxyListener, s)
+}
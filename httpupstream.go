@@ -0,0 +1,214 @@
+/**
+ * File: httpupstream.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Monday, October 26th 2026, 2:48:10 pm
+ * Last Modified: Monday, October 26th 2026, 2:48:10 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	HTTPAuthBasic = "basic"
+	HTTPAuthNTLM  = "ntlm"
+	// HTTPAuthNegotiate sends the spnego token of the kerberos ticket of the proxy in the Negotiate scheme
+	HTTPAuthNegotiate = "negotiate"
+	// HTTPAuthNegotiateNTLM sends the raw ntlm messages in the Negotiate scheme, without spnego nor kerberos
+	HTTPAuthNegotiateNTLM = "negotiate-ntlm"
+)
+
+// errPasswordFileNotLocal is the password file of a backend from the API or the remote configuration,
+// which could send any file readable on this host to the proxy as the password
+var errPasswordFileNotLocal = errors.New("the password file is only allowed in the local configuration file")
+
+type BackendHTTPConfig struct {
+	// Auth is the authentication scheme of the http proxy, "basic", "ntlm", "negotiate" or "negotiate-ntlm", none if empty
+	Auth string `yaml:"auth" json:"auth"`
+	// Domain is the windows domain of the credentials, or the username is like DOMAIN\user,
+	// it is the realm of the kerberos, or the username is like user@REALM
+	Domain      string `yaml:"domain" json:"domain"`
+	Workstation string `yaml:"workstation" json:"workstation"`
+	// PasswordFile is the file of the password, like the mounted secret, instead of the password
+	PasswordFile string `yaml:"password_file" json:"password_file"`
+	// Krb5Conf is the kerberos configuration of the negotiate auth, /etc/krb5.conf by default
+	Krb5Conf string `yaml:"krb5_conf" json:"krb5_conf"`
+	// Keytab of the username to login, or the password, or the credential cache is used
+	Keytab string `yaml:"keytab" json:"keytab"`
+	// CCache is the credential cache of the kinit, $KRB5CCNAME or /tmp/krb5cc_<uid> by default
+	CCache string `yaml:"ccache" json:"ccache"`
+	// SPN is the service principal of the proxy, HTTP/<host of the proxy> by default
+	SPN string `yaml:"spn" json:"spn"`
+}
+
+// bufferedConn is the connection with the data read ahead by the reader
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// httpCredentials returns the username, the domain and the password of the http proxy
+func (b *Backend) httpCredentials() (user, domain, password string, err error) {
+	user, domain, password = b.UserName, b.HTTP.Domain, b.Password
+	if i := strings.IndexByte(user, '\\'); i >= 0 {
+		domain, user = user[:i], user[i+1:]
+	}
+
+	if file := b.HTTP.PasswordFile; file != "" {
+		var data []byte
+		if data, err = os.ReadFile(file); err != nil {
+			return
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+	}
+
+	return
+}
+
+// httpChallenge returns the token of the scheme from the Proxy-Authenticate headers
+func httpChallenge(resp *http.Response, scheme string) (token []byte, err error) {
+	for _, value := range resp.Header.Values("Proxy-Authenticate") {
+		fields := strings.Fields(value)
+		if len(fields) == 2 && strings.EqualFold(fields[0], scheme) {
+			return base64.StdEncoding.DecodeString(fields[1])
+		}
+	}
+
+	return nil, fmt.Errorf("the proxy did not send the %s challenge", scheme)
+}
+
+// dialHTTP to connect the address by the CONNECT request through the http proxy,
+// the ntlm and negotiate handshakes are bound to the connection
+func (b *Backend) dialHTTP(network, addr string, timeout int) (conn net.Conn, err error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("the http proxy backend %s does not support %s", b.Addr, network)
+	}
+
+	user, domain, password, err := b.httpCredentials()
+	if err != nil {
+		return
+	}
+
+	proxy, err := net.DialTimeout("tcp", b.Addr, time.Duration(timeout)*time.Second)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = proxy.Close()
+		}
+	}()
+
+	if timeout > 0 {
+		_ = proxy.SetDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+	}
+
+	// the ntlm messages are sent by the negotiate scheme too, the kerberos token is sent in a single round
+	var scheme, authorization string
+	switch b.HTTP.Auth {
+	case "":
+	case HTTPAuthBasic:
+		scheme = "Basic"
+		authorization = base64.StdEncoding.EncodeToString([]byte(b.UserName + ":" + password))
+	case HTTPAuthNTLM, HTTPAuthNegotiateNTLM:
+		scheme = "NTLM"
+		if b.HTTP.Auth == HTTPAuthNegotiateNTLM {
+			scheme = "Negotiate"
+		}
+		authorization = base64.StdEncoding.EncodeToString(ntlmNegotiateMessage())
+	case HTTPAuthNegotiate:
+		var token []byte
+		if token, err = kerberosToken(b, b.kerberosSPN()); err != nil {
+			return
+		}
+		scheme, authorization = "Negotiate", base64.StdEncoding.EncodeToString(token)
+	default:
+		return nil, fmt.Errorf("unknown http auth %s of backend %s", b.HTTP.Auth, b.Addr)
+	}
+
+	reader := bufio.NewReader(proxy)
+	for round := 0; ; round++ {
+		req := &http.Request{
+			Method: http.MethodConnect,
+			URL:    &url.URL{Opaque: addr},
+			Host:   addr,
+			Header: make(http.Header),
+		}
+		req.Header.Set("Proxy-Connection", "Keep-Alive")
+		if authorization != "" {
+			req.Header.Set("Proxy-Authorization", scheme+" "+authorization)
+		}
+
+		if err = req.Write(proxy); err != nil {
+			return
+		}
+
+		var resp *http.Response
+		if resp, err = http.ReadResponse(reader, req); err != nil {
+			return
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
+
+		// drain the body to reuse the connection for the next round of the handshake
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		if resp.StatusCode == http.StatusProxyAuthRequired && (round > 0 || (b.HTTP.Auth != HTTPAuthNTLM && b.HTTP.Auth != HTTPAuthNegotiateNTLM)) {
+			return nil, fmt.Errorf("%w, http proxy %s replied %s when connecting %s", errProxyAuth, b.Addr, resp.Status, addr)
+		}
+
+		if resp.StatusCode != http.StatusProxyAuthRequired {
+			return nil, fmt.Errorf("http proxy %s replied %s when connecting %s", b.Addr, resp.Status, addr)
+		}
+
+		var (
+			token     []byte
+			challenge *ntlmChallenge
+		)
+		if token, err = httpChallenge(resp, scheme); err != nil {
+			return
+		}
+
+		if challenge, err = parseNTLMChallenge(token); err != nil {
+			return
+		}
+
+		if resp.Close {
+			return nil, fmt.Errorf("http proxy %s closed the connection during the %s handshake", b.Addr, scheme)
+		}
+
+		authorization = base64.StdEncoding.EncodeToString(ntlmAuthenticateMessage(challenge, user, domain, password, b.HTTP.Workstation))
+	}
+
+	if err = proxy.SetDeadline(time.Time{}); err != nil {
+		return
+	}
+
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: proxy, reader: reader}, nil
+	}
+
+	return proxy, nil
+}
@@ -0,0 +1,273 @@
+package socks5lb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ntlmString decodes the UTF-16LE field
+func ntlmString(data []byte) string {
+	codes := make([]uint16, len(data)/2)
+	for i := range codes {
+		codes[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+
+	return string(utf16.Decode(codes))
+}
+
+// NewTestNTLMProxy returns the address of a stand-in http proxy, which requires the
+// NTLMv2 credentials by the scheme on the same connection, then tunnels the CONNECT requests
+func NewTestNTLMProxy(t *testing.T, scheme, domain, user, password string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	challenge := []byte("\x01\x23\x45\x67\x89\xab\xcd\xef")
+	targetInfo := []byte{2, 0, 8, 0, 'C', 0, 'O', 0, 'R', 0, 'P', 0, 0, 0, 0, 0}
+
+	// the challenge message with the target info
+	msg := make([]byte, 48)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 2)
+	binary.LittleEndian.PutUint32(msg[20:], ntlmNegotiateFlags)
+	copy(msg[24:], challenge)
+	binary.LittleEndian.PutUint16(msg[40:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint16(msg[42:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint32(msg[44:], 48)
+	msg = append(msg, targetInfo...)
+
+	reply := func(conn net.Conn, status int, header string) {
+		_, _ = fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n%sContent-Length: 4\r\n\r\ndeny", status, http.StatusText(status), header)
+	}
+
+	handle := func(conn net.Conn) {
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		authenticated := false
+
+		for {
+			req, err := http.ReadRequest(reader)
+			if err != nil {
+				return
+			}
+
+			token, _ := strings.CutPrefix(req.Header.Get("Proxy-Authorization"), scheme+" ")
+			data, _ := base64.StdEncoding.DecodeString(token)
+
+			switch {
+			case len(data) >= 12 && bytes.Equal(data[:8], ntlmSignature) && binary.LittleEndian.Uint32(data[8:]) == 1:
+				reply(conn, http.StatusProxyAuthRequired, fmt.Sprintf("Proxy-Authenticate: %s %s\r\n", scheme, base64.StdEncoding.EncodeToString(msg)))
+				continue
+
+			case len(data) >= 64 && bytes.Equal(data[:8], ntlmSignature) && binary.LittleEndian.Uint32(data[8:]) == 3:
+				nt, _ := ntlmField(data, 20)
+				d, _ := ntlmField(data, 28)
+				u, _ := ntlmField(data, 36)
+				if len(nt) > 16 && ntlmString(d) == domain && ntlmString(u) == user {
+					key := ntlmV2Hash(user, domain, password)
+					authenticated = hmac.Equal(nt[:16], ntlmHMAC(key, challenge, nt[16:]))
+				}
+			}
+
+			if !authenticated || req.Method != http.MethodConnect {
+				reply(conn, http.StatusProxyAuthRequired, fmt.Sprintf("Proxy-Authenticate: %s\r\nConnection: close\r\n", scheme))
+				return
+			}
+
+			target, err := net.Dial("tcp", req.Host)
+			if err != nil {
+				reply(conn, http.StatusBadGateway, "")
+				return
+			}
+			defer target.Close()
+
+			_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+			go io.Copy(target, reader)
+			_, _ = io.Copy(conn, target)
+			return
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestBackend_HTTPNTLM(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer target.Close()
+
+	addr := NewTestNTLMProxy(t, "NTLM", "CORP", "alice", "s3cret")
+	backend := NewBackend(addr, BackendCheckConfig{CheckURL: target.URL, Timeout: 5})
+	backend.Protocol, backend.UserName, backend.Password = ProtocolHTTP, "alice", "s3cret"
+	backend.HTTP = BackendHTTPConfig{Auth: HTTPAuthNTLM, Domain: "CORP"}
+
+	// the health check uses the same authentication
+	assert.NoError(t, backend.Check())
+	assert.True(t, backend.Alive())
+
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return backend.DialFor("", network, addr, 5)
+		},
+	}}
+	resp, err := client.Get(target.URL)
+	if assert.NoError(t, err) {
+		data, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(t, "hello", string(data))
+	}
+
+	backend.Password = "wrong"
+	assert.Error(t, backend.Check())
+	assert.False(t, backend.Alive())
+}
+
+func TestBackend_HTTPNegotiate(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+
+	file := filepath.Join(t.TempDir(), "password")
+	assert.NoError(t, os.WriteFile(file, []byte("s3cret\n"), 0600))
+
+	addr := NewTestNTLMProxy(t, "Negotiate", "CORP", "bob", "s3cret")
+	backend := NewBackend(addr, BackendCheckConfig{CheckURL: target.URL, Timeout: 5})
+	backend.Protocol, backend.UserName = ProtocolHTTP, `CORP\bob`
+	backend.HTTP = BackendHTTPConfig{Auth: HTTPAuthNegotiateNTLM, PasswordFile: file}
+	assert.NoError(t, backend.Check())
+
+	// the ntlm handshake is not accepted by the other scheme
+	backend.HTTP.Auth = HTTPAuthNTLM
+	assert.Error(t, backend.Check())
+
+	backend.HTTP = BackendHTTPConfig{Auth: HTTPAuthNegotiateNTLM, PasswordFile: filepath.Join(t.TempDir(), "missing")}
+	assert.Error(t, backend.Check())
+}
+
+func TestBackend_HTTPKerberos(t *testing.T) {
+	// the kdc is not available in the tests, so the token is issued for the service principal only
+	token := kerberosToken
+	defer func() { kerberosToken = token }()
+	kerberosToken = func(b *Backend, spn string) ([]byte, error) {
+		return []byte("ticket of " + spn), nil
+	}
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+
+	want := "Negotiate " + base64.StdEncoding.EncodeToString([]byte("ticket of HTTP/proxy.corp.example.com"))
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				req, err := http.ReadRequest(reader)
+				if err != nil {
+					return
+				}
+
+				// the kerberos token is accepted in a single round
+				if req.Header.Get("Proxy-Authorization") != want {
+					_, _ = conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Negotiate\r\nContent-Length: 0\r\n\r\n"))
+					return
+				}
+
+				upstream, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+
+				_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				go io.Copy(upstream, reader)
+				_, _ = io.Copy(conn, upstream)
+			}()
+		}
+	}()
+
+	backend := NewBackend(l.Addr().String(), BackendCheckConfig{CheckURL: target.URL, Timeout: 5})
+	backend.Protocol = ProtocolHTTP
+	backend.HTTP = BackendHTTPConfig{Auth: HTTPAuthNegotiate, SPN: "HTTP/proxy.corp.example.com"}
+	assert.NoError(t, backend.Check())
+
+	// the service principal is the host of the proxy by default
+	assert.Equal(t, "HTTP/127.0.0.1", NewBackend("127.0.0.1:3128", BackendCheckConfig{}).kerberosSPN())
+	backend.HTTP.SPN = ""
+	_, err = backend.dialHTTP("tcp", target.Listener.Addr().String(), 5)
+	assert.ErrorIs(t, err, errProxyAuth)
+
+	_, err = ParseConfigure([]byte("backends:\n  - addr: 127.0.0.1:3128\n    protocol: http\n    http:\n      auth: negotiate\n"))
+	assert.NoError(t, err)
+}
+
+func TestBackend_KerberosClient(t *testing.T) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "krb5.conf")
+	assert.NoError(t, os.WriteFile(conf, []byte("[libdefaults]\n  default_realm = CORP.EXAMPLE.COM\n"), 0600))
+
+	backend := NewBackend("127.0.0.1:3128", BackendCheckConfig{})
+	backend.Protocol, backend.UserName = ProtocolHTTP, "bob"
+	backend.HTTP = BackendHTTPConfig{Auth: HTTPAuthNegotiate, Krb5Conf: filepath.Join(dir, "missing")}
+	_, err := backend.kerberosClient()
+	assert.ErrorContains(t, err, "kerberos configuration")
+
+	backend.HTTP = BackendHTTPConfig{Auth: HTTPAuthNegotiate, Krb5Conf: conf, Keytab: filepath.Join(dir, "missing")}
+	_, err = backend.kerberosClient()
+	assert.ErrorContains(t, err, "keytab")
+
+	backend.HTTP = BackendHTTPConfig{Auth: HTTPAuthNegotiate, Krb5Conf: conf, CCache: filepath.Join(dir, "missing")}
+	_, err = backend.kerberosClient()
+	assert.ErrorContains(t, err, "credential cache")
+
+	// the realm is parsed from the username, and the client is kept until the backend is removed
+	backend.UserName, backend.Password = "bob@corp.example.com", "s3cret"
+	cl, err := backend.kerberosClient()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "bob", cl.Credentials.UserName())
+		assert.Equal(t, "CORP.EXAMPLE.COM", cl.Credentials.Domain())
+
+		again, _ := backend.kerberosClient()
+		assert.Same(t, cl, again)
+	}
+
+	backend.closeKerberos()
+	assert.Nil(t, backend.kerberos)
+}
@@ -0,0 +1,147 @@
+/**
+ * File: kerberos.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Saturday, October 31st 2026, 10:12:36 am
+ * Last Modified: Saturday, October 31st 2026, 10:12:36 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+)
+
+const defaultKrb5Conf = "/etc/krb5.conf"
+
+// errKerberosNotLocal is the keytab or the credential cache of a backend from the API or the remote configuration,
+// which could authenticate to any proxy as the user of this host
+var errKerberosNotLocal = errors.New("the kerberos keytab and credential cache are only allowed in the local configuration file")
+
+var kerberosClientsLock sync.Mutex
+
+// kerberosToken returns the spnego token of the service principal, replaced in the tests without a kdc
+var kerberosToken = func(b *Backend, spn string) ([]byte, error) {
+	cl, err := b.kerberosClient()
+	if err != nil {
+		return nil, err
+	}
+
+	s := spnego.SPNEGOClient(cl, spn)
+	if err = s.AcquireCred(); err != nil {
+		return nil, fmt.Errorf("kerberos login of backend %s failed, %w", b.Addr, err)
+	}
+
+	token, err := s.InitSecContext()
+	if err != nil {
+		return nil, fmt.Errorf("kerberos ticket of %s failed, %w", spn, err)
+	}
+
+	return token.Marshal()
+}
+
+// kerberosSPN returns the service principal of the http proxy, like HTTP/proxy.example.com
+func (b *Backend) kerberosSPN() string {
+	if b.HTTP.SPN != "" {
+		return b.HTTP.SPN
+	}
+
+	host, _, err := net.SplitHostPort(b.Addr)
+	if err != nil {
+		host = b.Addr
+	}
+
+	return "HTTP/" + host
+}
+
+// kerberosClient returns the kerberos client of the backend, it is logged in by the keytab,
+// the password or the credential cache, and the tickets are kept until the backend is removed
+func (b *Backend) kerberosClient() (*client.Client, error) {
+	kerberosClientsLock.Lock()
+	defer kerberosClientsLock.Unlock()
+
+	if b.kerberos != nil {
+		return b.kerberos, nil
+	}
+
+	file := b.HTTP.Krb5Conf
+	if file == "" {
+		file = defaultKrb5Conf
+	}
+
+	conf, err := config.Load(file)
+	if err != nil {
+		return nil, fmt.Errorf("load the kerberos configuration %s failed, %w", file, err)
+	}
+
+	user, realm, password, err := b.httpCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	if i := strings.LastIndexByte(user, '@'); i >= 0 {
+		user, realm = user[:i], user[i+1:]
+	}
+	realm = strings.ToUpper(realm)
+
+	var cl *client.Client
+	switch {
+	case b.HTTP.Keytab != "":
+		var kt *keytab.Keytab
+		if kt, err = keytab.Load(b.HTTP.Keytab); err != nil {
+			return nil, fmt.Errorf("load the keytab %s failed, %w", b.HTTP.Keytab, err)
+		}
+		cl = client.NewWithKeytab(user, realm, kt, conf, client.DisablePAFXFAST(true))
+	case password != "":
+		cl = client.NewWithPassword(user, realm, password, conf, client.DisablePAFXFAST(true))
+	default:
+		file := b.HTTP.CCache
+		if file == "" {
+			file = defaultCCache()
+		}
+
+		var cache *credentials.CCache
+		if cache, err = credentials.LoadCCache(file); err != nil {
+			return nil, fmt.Errorf("load the credential cache %s failed, %w", file, err)
+		}
+		if cl, err = client.NewFromCCache(cache, conf, client.DisablePAFXFAST(true)); err != nil {
+			return nil, err
+		}
+	}
+
+	b.kerberos = cl
+	return cl, nil
+}
+
+// defaultCCache returns the credential cache of the current user, like kinit
+func defaultCCache() string {
+	if name := os.Getenv("KRB5CCNAME"); name != "" {
+		return strings.TrimPrefix(name, "FILE:")
+	}
+
+	return fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())
+}
+
+// closeKerberos to drop the tickets and stop renewing them if the client is logged in
+func (b *Backend) closeKerberos() {
+	kerberosClientsLock.Lock()
+	defer kerberosClientsLock.Unlock()
+
+	if b.kerberos != nil {
+		b.kerberos.Destroy()
+		b.kerberos = nil
+	}
+}
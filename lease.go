@@ -0,0 +1,339 @@
+/**
+ * File: lease.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Friday, October 30th 2026, 2:07:45 pm
+ * Last Modified: Friday, October 30th 2026, 2:07:45 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultLeaseAddr        = "127.0.0.1"
+	defaultLeaseDuration    = 3600
+	defaultLeaseMaxDuration = 86400
+)
+
+// errBackendLeased is the change of a backend reserved by a lease, release the lease first
+var errBackendLeased = errors.New("the backend is leased")
+
+type LeaseConfig struct {
+	// Addr is the host to listen on for the dedicated ports of the leases, 127.0.0.1 by default,
+	// the ports require the random credentials of each lease, so the other hosts could be allowed too
+	Addr string `yaml:"addr"`
+	// Duration is the lease duration in seconds if not requested, one hour by default
+	Duration uint `yaml:"duration"`
+	// MaxDuration is the max lease duration in seconds, one day by default
+	MaxDuration uint `yaml:"max_duration"`
+}
+
+// Lease reserves a backend exclusively, the connections to its dedicated
+// socks5 port are routed to the backend only, with the random username and password of the lease
+type Lease struct {
+	ID       string    `json:"id"`
+	Backend  string    `json:"backend"`
+	Selector string    `json:"selector"`
+	Addr     string    `json:"addr"`
+	Username string    `json:"username,omitempty"`
+	Password string    `json:"password,omitempty"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+
+	backend  *Backend
+	listener net.Listener
+	timer    *time.Timer
+}
+
+// leaseStore keeps the active leases
+type leaseStore struct {
+	config LeaseConfig
+
+	lock   sync.Mutex
+	leases map[string]*Lease
+}
+
+// newLeaseStore returns the lease store by the configuration
+func newLeaseStore(config LeaseConfig) *leaseStore {
+	if config.Addr == "" {
+		config.Addr = defaultLeaseAddr
+	}
+
+	if config.Duration == 0 {
+		config.Duration = defaultLeaseDuration
+	}
+
+	if config.MaxDuration == 0 {
+		config.MaxDuration = defaultLeaseMaxDuration
+	}
+
+	return &leaseStore{
+		config: config,
+		leases: make(map[string]*Lease),
+	}
+}
+
+// duration returns the requested duration, the default one if zero
+func (l *leaseStore) duration(duration time.Duration) (time.Duration, error) {
+	if duration == 0 {
+		return time.Duration(l.config.Duration) * time.Second, nil
+	}
+
+	if duration < 0 || duration > time.Duration(l.config.MaxDuration)*time.Second {
+		return 0, fmt.Errorf("lease duration should be in (0, %ds]", l.config.MaxDuration)
+	}
+
+	return duration, nil
+}
+
+// Lease to reserve a healthy backend matching the selector for the duration
+func (s *Server) Lease(selector Selector, duration time.Duration) (lease *Lease, err error) {
+	if duration, err = s.leases.duration(duration); err != nil {
+		return
+	}
+
+	lease = &Lease{Selector: selector.String(), Created: time.Now()}
+	lease.Expires = lease.Created.Add(duration)
+	if lease.ID, err = randomToken(); err != nil {
+		return
+	}
+
+	if lease.Username, lease.Password, err = portCredentials(); err != nil {
+		return
+	}
+
+	s.leases.lock.Lock()
+	defer s.leases.lock.Unlock()
+
+	// the leased backends are not available, so they are never leased twice
+	var backends []*Backend
+	for _, backend := range s.Pool.Select(selector) {
+		if backend.Available() {
+			backends = append(backends, backend)
+		}
+	}
+
+	if lease.backend = s.Pool.next(backends); lease.backend == nil {
+		return nil, fmt.Errorf("no available backend for %s", selector)
+	}
+	lease.Backend = lease.backend.Addr
+
+	if lease.listener, err = net.Listen("tcp", net.JoinHostPort(s.leases.config.Addr, "0")); err != nil {
+		return nil, err
+	}
+	lease.Addr = lease.listener.Addr().String()
+
+	if err = s.Pool.setLeased(lease.backend, true); err != nil {
+		_ = lease.listener.Close()
+		return nil, err
+	}
+	s.leases.leases[lease.ID] = lease
+	lease.timer = time.AfterFunc(duration, func() {
+		s.expireLease(lease.ID)
+	})
+
+	go s.serveLease(lease)
+
+	RecordEvent("lease.created", "backend %s is leased until %s, on %s", lease.Backend, lease.Expires.Format(time.RFC3339), lease.Addr)
+	return
+}
+
+// serveLease to relay the connections to the dedicated port through the leased backend
+func (s *Server) serveLease(lease *Lease) {
+	for {
+		conn, err := lease.listener.Accept()
+		if err != nil {
+			log.Debugf("[lease] stop serving lease %s, %v", lease.ID, err)
+			return
+		}
+
+		go s.relaySocks5Conn(conn, lease.backend, lease.Username, lease.Password)
+	}
+}
+
+// RenewLease to extend the lease by the duration from now
+func (s *Server) RenewLease(id string, duration time.Duration) (lease Lease, err error) {
+	if duration, err = s.leases.duration(duration); err != nil {
+		return
+	}
+
+	s.leases.lock.Lock()
+	defer s.leases.lock.Unlock()
+
+	current := s.leases.leases[id]
+	if current == nil {
+		return lease, fmt.Errorf("lease %s is not found", id)
+	}
+
+	current.timer.Reset(duration)
+	current.Expires = time.Now().Add(duration)
+	return *current, nil
+}
+
+// expireLease to end the lease if it is not renewed meanwhile
+func (s *Server) expireLease(id string) {
+	s.leases.lock.Lock()
+	defer s.leases.lock.Unlock()
+
+	lease := s.leases.leases[id]
+	if lease == nil || time.Now().Before(lease.Expires) {
+		return
+	}
+
+	s.release(lease)
+	RecordEvent("lease.expired", "lease %s of backend %s is expired", lease.ID, lease.Backend)
+}
+
+// Release to end the lease, the backend is available for the other traffic again
+func (s *Server) Release(id string) error {
+	s.leases.lock.Lock()
+	defer s.leases.lock.Unlock()
+
+	lease := s.leases.leases[id]
+	if lease == nil {
+		return fmt.Errorf("lease %s is not found", id)
+	}
+
+	s.release(lease)
+	log.Infof("[lease] lease %s of backend %s is released", id, lease.Backend)
+	return nil
+}
+
+// release to remove the lease and close its port, the lock of the leases should be held
+func (s *Server) release(lease *Lease) {
+	delete(s.leases.leases, lease.ID)
+	lease.timer.Stop()
+	_ = lease.listener.Close()
+	_ = s.Pool.setLeased(lease.backend, false)
+}
+
+// ReleaseAll to end all the leases
+func (s *Server) ReleaseAll() {
+	for _, lease := range s.Leases() {
+		_ = s.Release(lease.ID)
+	}
+}
+
+// Leases returns the active leases sorted by the expiration
+func (s *Server) Leases() []Lease {
+	s.leases.lock.Lock()
+	leases := make([]Lease, 0, len(s.leases.leases))
+	for _, lease := range s.leases.leases {
+		leases = append(leases, *lease)
+	}
+	s.leases.lock.Unlock()
+
+	sort.Slice(leases, func(i, j int) bool {
+		return leases[i].Expires.Before(leases[j].Expires)
+	})
+
+	return leases
+}
+
+// setupLeaseRouter to handle the exclusive backend leases
+func (s *Server) setupLeaseRouter(apiGroup *gin.RouterGroup) {
+	type leaseRequest struct {
+		Labels string `json:"labels"`
+		// Duration is like "30m", the default duration if empty
+		Duration string `json:"duration"`
+	}
+
+	// bind the optional request body, and respond the bad request if failed
+	bind := func(c *gin.Context) (request leaseRequest, duration time.Duration, ok bool) {
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&request); err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		var err error
+		if request.Duration != "" {
+			if duration, err = time.ParseDuration(request.Duration); err != nil {
+				c.String(http.StatusBadRequest, "invalid duration %s", request.Duration)
+				return
+			}
+		}
+
+		if _, err = s.leases.duration(duration); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		return request, duration, true
+	}
+
+	// list the active leases, the credentials of the ports are shown to the admins only
+	apiGroup.GET("leases", func(c *gin.Context) {
+		leases := s.Leases()
+		if !canViewSecrets(c) {
+			for i := range leases {
+				leases[i].Username, leases[i].Password = "", ""
+			}
+		}
+
+		c.JSON(http.StatusOK, leases)
+	})
+
+	// reserve a healthy backend chosen by the labels
+	apiGroup.POST("leases", func(c *gin.Context) {
+		request, duration, ok := bind(c)
+		if !ok {
+			return
+		}
+
+		selector, err := ParseSelector(request.Labels)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		lease, err := s.Lease(selector, duration)
+		if err != nil {
+			c.String(http.StatusServiceUnavailable, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, lease)
+	})
+
+	// extend the lease from now
+	apiGroup.POST("leases/:id/renew", func(c *gin.Context) {
+		_, duration, ok := bind(c)
+		if !ok {
+			return
+		}
+
+		lease, err := s.RenewLease(c.Param("id"), duration)
+		if err != nil {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, lease)
+	})
+
+	// release the lease early
+	apiGroup.DELETE("leases/:id", func(c *gin.Context) {
+		if err := s.Release(c.Param("id")); err != nil {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+
+		c.String(http.StatusOK, "lease %s is released", c.Param("id"))
+	})
+}
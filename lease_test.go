@@ -0,0 +1,151 @@
+package socks5lb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/txthinking/socks5"
+)
+
+func TestServer_Lease(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer target.Close()
+
+	pool := &Pool{backends: make(map[string]*Backend)}
+	leased := NewBackend(NewTestSocks5Server(t), BackendCheckConfig{InitialAlive: true, Timeout: 5})
+	leased.Labels = map[string]string{"country": "us"}
+	other := NewBackend("10.40.0.1:1080", BackendCheckConfig{InitialAlive: true})
+	other.Labels = map[string]string{"country": "jp"}
+	assert.NoError(t, pool.Add(leased))
+	assert.NoError(t, pool.Add(other))
+
+	server, err := NewServer(pool, ServerConfig{Lease: LeaseConfig{MaxDuration: 3600}})
+	assert.NoError(t, err)
+	defer server.ReleaseAll()
+
+	router, err := server.newRouter()
+	assert.NoError(t, err)
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(http.MethodPost, "/api/leases", `{"labels": "country=us", "duration": "10m"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var lease Lease
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &lease))
+	assert.Equal(t, leased.Addr, lease.Backend)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), lease.Expires, 5*time.Second)
+	assert.True(t, NewBackendView(leased).Leased)
+
+	// the other traffic skips the leased backend, and it is not leased twice
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, other, pool.Next())
+	}
+	assert.Equal(t, http.StatusServiceUnavailable, request(http.MethodPost, "/api/leases", `{"labels": "country=us"}`).Code)
+
+	// the dedicated port requires the credentials of the lease
+	for _, credentials := range [][2]string{{"", ""}, {lease.Username, "wrong"}} {
+		client, err := socks5.NewClient(lease.Addr, credentials[0], credentials[1], 5, 5)
+		assert.NoError(t, err)
+		_, err = (&http.Client{Transport: &http.Transport{Dial: client.Dial}}).Get(target.URL)
+		assert.Error(t, err)
+	}
+
+	// the dedicated port routes to the leased backend only
+	client, err := socks5.NewClient(lease.Addr, lease.Username, lease.Password, 5, 5)
+	assert.NoError(t, err)
+	resp, err := (&http.Client{Transport: &http.Transport{Dial: client.Dial}}).Get(target.URL)
+	if assert.NoError(t, err) {
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	}
+
+	w = request(http.MethodPost, "/api/leases/"+lease.ID+"/renew", `{"duration": "30m"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &lease))
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), lease.Expires, 5*time.Second)
+
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/api/leases/"+lease.ID+"/renew", `{"duration": "2h"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/api/leases", `{"duration": "soon"}`).Code)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodPost, "/api/leases/unknown/renew", ``).Code)
+
+	w = request(http.MethodGet, "/api/leases", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), lease.ID)
+
+	// released early, the backend is available again and the port is closed
+	assert.Equal(t, http.StatusOK, request(http.MethodDelete, "/api/leases/"+lease.ID, "").Code)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodDelete, "/api/leases/"+lease.ID, "").Code)
+	assert.True(t, leased.Available())
+	assert.Empty(t, server.Leases())
+
+	_, err = client.Dial("tcp", target.Listener.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestServer_LeaseRemove(t *testing.T) {
+	pool := &Pool{backends: make(map[string]*Backend)}
+	backend := NewBackend("10.40.0.3:1080", BackendCheckConfig{InitialAlive: true})
+	assert.NoError(t, pool.Add(backend))
+
+	server, err := NewServer(pool, ServerConfig{})
+	assert.NoError(t, err)
+	defer server.ReleaseAll()
+
+	lease, err := server.Lease(nil, time.Minute)
+	assert.NoError(t, err)
+
+	// the leased backend is neither removed nor replaced
+	assert.ErrorIs(t, pool.Remove(backend.Addr), errBackendLeased)
+
+	changed := []Backend{{Addr: backend.Addr, Weight: 3}}
+	added, removed, updated := server.ApplyBackends(changed)
+	assert.Equal(t, []int{0, 0, 0}, []int{added, removed, updated})
+	assert.Equal(t, backend, pool.Get(backend.Addr))
+
+	_, removed, _ = server.ApplyBackends(nil)
+	assert.Zero(t, removed)
+	assert.Equal(t, backend, pool.Get(backend.Addr))
+
+	assert.NoError(t, server.Release(lease.ID))
+	assert.NoError(t, pool.Remove(backend.Addr))
+
+	// the backend removed meanwhile is not leased
+	assert.Error(t, pool.setLeased(backend, true))
+	assert.False(t, backend.Leased())
+}
+
+func TestServer_LeaseExpire(t *testing.T) {
+	pool := &Pool{backends: make(map[string]*Backend)}
+	backend := NewBackend("10.40.0.2:1080", BackendCheckConfig{InitialAlive: true})
+	assert.NoError(t, pool.Add(backend))
+
+	server, err := NewServer(pool, ServerConfig{})
+	assert.NoError(t, err)
+	defer server.ReleaseAll()
+
+	_, err = server.Lease(nil, 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.False(t, backend.Available())
+	assert.Nil(t, pool.Next())
+
+	assert.Eventually(t, func() bool {
+		return len(server.Leases()) == 0
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, backend, pool.Next())
+
+	_, err = server.Lease(nil, 25*time.Hour)
+	assert.Error(t, err)
+}
@@ -0,0 +1,537 @@
+/**
+ * File: mdns.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Friday, October 23rd 2026, 10:17:52 am
+ * Last Modified: Friday, October 23rd 2026, 10:17:52 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	MDNSSocksService     = "_socks._tcp.local."
+	MDNSHTTPProxyService = "_http-proxy._tcp.local."
+
+	defaultMDNSGroup    = "224.0.0.251:5353"
+	defaultMDNSGroupTag = "mdns"
+	defaultMDNSInterval = 60
+	// mdnsTTL is the ttl of the advertised records in seconds
+	mdnsTTL = 120
+	// mdnsAnnounceInterval is well below the ttl, so the advertised records never expire
+	// on the peers, whatever the interval of the discovery is
+	mdnsAnnounceInterval = mdnsTTL * time.Second / 4
+
+	// MDNSSourceLabel is the label value of the "source" label of the discovered backends
+	MDNSSourceLabel = "mdns"
+)
+
+type MDNSConfig struct {
+	// Advertise the socks5 and the http proxy listeners as _socks._tcp and _http-proxy._tcp
+	Advertise bool `yaml:"advertise"`
+	// Name is the instance name of the services, the hostname by default
+	Name string `yaml:"name"`
+	// Interface is the network interface to use, all the multicast interfaces if empty
+	Interface string `yaml:"interface"`
+	// Group is the multicast group address, 224.0.0.251:5353 by default
+	Group string `yaml:"group"`
+
+	Discover MDNSDiscoverConfig `yaml:"discover"`
+}
+
+type MDNSDiscoverConfig struct {
+	// Enable to add the _socks._tcp services on the lan as the backends
+	Enable bool `yaml:"enable"`
+	// Group is the "group" label of the discovered backends, "mdns" by default
+	Group string `yaml:"group"`
+	// Interval is the interval to query the services in seconds, 60 by default
+	Interval    uint               `yaml:"interval"`
+	CheckConfig BackendCheckConfig `yaml:"check_config"`
+}
+
+// MDNSService is an advertised or a discovered service
+type MDNSService struct {
+	Instance string    `json:"instance"`
+	Service  string    `json:"service"`
+	Addr     string    `json:"addr"`
+	Expires  time.Time `json:"expires,omitempty"`
+}
+
+// MDNS advertises the listeners and discovers the socks5 proxies on the lan
+type MDNS struct {
+	config MDNSConfig
+	pool   *Pool
+	conn   *net.UDPConn
+	group  *net.UDPAddr
+	host   string
+
+	lock       sync.Mutex
+	advertised []MDNSService
+	discovered map[string]*MDNSService
+	closed     chan struct{}
+}
+
+// mdnsLabel returns the name as a single dns label
+func mdnsLabel(name string) string {
+	return strings.NewReplacer(".", "-", " ", "-").Replace(name)
+}
+
+// mdnsInterfaceIPs returns the ipv4 addresses of the interface, or all the up interfaces if nil
+func mdnsInterfaceIPs(ifi *net.Interface) (ips []net.IP) {
+	var addrs []net.Addr
+	if ifi != nil {
+		addrs, _ = ifi.Addrs()
+	} else {
+		addrs, _ = net.InterfaceAddrs()
+	}
+
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil && !ipnet.IP.IsLoopback() {
+			ips = append(ips, ipnet.IP.To4())
+		}
+	}
+
+	return
+}
+
+// NewMDNS to join the multicast group, the listeners of the config are advertised if enabled
+func NewMDNS(pool *Pool, config ServerConfig) (m *MDNS, err error) {
+	mdns := config.MDNS
+	if mdns.Group == "" {
+		mdns.Group = defaultMDNSGroup
+	}
+
+	if mdns.Name == "" {
+		if mdns.Name, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+
+	if mdns.Discover.Group == "" {
+		mdns.Discover.Group = defaultMDNSGroupTag
+	}
+
+	if mdns.Discover.Interval == 0 {
+		mdns.Discover.Interval = defaultMDNSInterval
+	}
+
+	m = &MDNS{
+		config:     mdns,
+		pool:       pool,
+		host:       mdnsLabel(mdns.Name) + ".local.",
+		discovered: make(map[string]*MDNSService),
+		closed:     make(chan struct{}),
+	}
+
+	if m.group, err = net.ResolveUDPAddr("udp4", mdns.Group); err != nil {
+		return nil, fmt.Errorf("invalid mdns group %s, %v", mdns.Group, err)
+	}
+
+	var ifi *net.Interface
+	if mdns.Interface != "" {
+		if ifi, err = net.InterfaceByName(mdns.Interface); err != nil {
+			return nil, err
+		}
+	}
+
+	if mdns.Advertise {
+		for _, listener := range []struct{ service, addr string }{
+			{MDNSSocksService, config.Sock5.Addr},
+			{MDNSHTTPProxyService, config.HTTPProxy.Addr},
+		} {
+			host, port, err := net.SplitHostPort(listener.addr)
+			if err != nil || port == "0" {
+				continue
+			}
+
+			// the listener on the specified address is advertised by it, otherwise by the interface addresses
+			ips := mdnsInterfaceIPs(ifi)
+			if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+				ips = []net.IP{ip}
+			}
+
+			for _, ip := range ips {
+				m.advertised = append(m.advertised, MDNSService{
+					Instance: mdnsLabel(mdns.Name) + "." + listener.service,
+					Service:  listener.service,
+					Addr:     net.JoinHostPort(ip.String(), port),
+				})
+			}
+		}
+	}
+
+	if m.conn, err = net.ListenMulticastUDP("udp4", ifi, m.group); err != nil {
+		return nil, fmt.Errorf("join the mdns group %s failed, %v", mdns.Group, err)
+	}
+
+	// the other instances on this host are on the lan too
+	if err = ipv4.NewPacketConn(m.conn).SetMulticastLoopback(true); err != nil {
+		_ = m.conn.Close()
+		return nil, err
+	}
+
+	return
+}
+
+// mdnsHeader returns the header of the record in the internet class
+func mdnsHeader(name string, ttl uint32) (dnsmessage.ResourceHeader, error) {
+	n, err := dnsmessage.NewName(name)
+	return dnsmessage.ResourceHeader{Name: n, Class: dnsmessage.ClassINET, TTL: ttl}, err
+}
+
+// response returns the records of the advertised services of the type, all if empty
+func (m *MDNS) response(service string, ttl uint32) (data []byte, err error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true, Authoritative: true})
+	b.EnableCompression()
+	if err = b.StartAnswers(); err != nil {
+		return
+	}
+
+	var (
+		host, _ = dnsmessage.NewName(m.host)
+		count   int
+		hdr     dnsmessage.ResourceHeader
+	)
+
+	for _, s := range m.advertised {
+		if service != "" && s.Service != service {
+			continue
+		}
+
+		ip, port, _ := net.SplitHostPort(s.Addr)
+		n, _ := strconv.ParseUint(port, 10, 16)
+		instance, err := dnsmessage.NewName(s.Instance)
+		if err != nil {
+			return nil, err
+		}
+
+		if hdr, err = mdnsHeader(s.Service, ttl); err == nil {
+			err = b.PTRResource(hdr, dnsmessage.PTRResource{PTR: instance})
+		}
+		if err == nil {
+			hdr.Name = instance
+			err = b.SRVResource(hdr, dnsmessage.SRVResource{Target: host, Port: uint16(n)})
+		}
+		if err == nil {
+			err = b.TXTResource(hdr, dnsmessage.TXTResource{TXT: []string{"txtvers=1"}})
+		}
+		if err == nil {
+			hdr.Name = host
+			var a dnsmessage.AResource
+			copy(a.A[:], net.ParseIP(ip).To4())
+			err = b.AResource(hdr, a)
+		}
+		if err != nil {
+			return nil, err
+		}
+		count++
+	}
+
+	if count == 0 {
+		return nil, nil
+	}
+
+	return b.Finish()
+}
+
+// announce to send the records of the advertised services to the group
+func (m *MDNS) announce(service string, ttl uint32) {
+	data, err := m.response(service, ttl)
+	if err != nil {
+		log.Errorf("[mdns] build the response failed, %v", err)
+		return
+	}
+
+	if data != nil {
+		if _, err = m.conn.WriteToUDP(data, m.group); err != nil {
+			log.Warnf("[mdns] announce failed, %v", err)
+		}
+	}
+}
+
+// query to ask for the socks5 services on the lan
+func (m *MDNS) query() {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	name, _ := dnsmessage.NewName(MDNSSocksService)
+
+	err := b.StartQuestions()
+	if err == nil {
+		err = b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET})
+	}
+
+	var data []byte
+	if err == nil {
+		data, err = b.Finish()
+	}
+
+	if err == nil {
+		_, err = m.conn.WriteToUDP(data, m.group)
+	}
+
+	if err != nil {
+		log.Warnf("[mdns] query failed, %v", err)
+	}
+}
+
+// handle to answer the queries and learn the services from the responses
+func (m *MDNS) handle(data []byte, from *net.UDPAddr) {
+	var p dnsmessage.Parser
+	header, err := p.Start(data)
+	if err != nil {
+		return
+	}
+
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return
+	}
+
+	if !header.Response {
+		for _, q := range questions {
+			if q.Type != dnsmessage.TypePTR && q.Type != dnsmessage.TypeALL {
+				continue
+			}
+
+			switch name := strings.ToLower(q.Name.String()); name {
+			case MDNSSocksService, MDNSHTTPProxyService:
+				m.announce(name, mdnsTTL)
+			}
+		}
+		return
+	}
+
+	if !m.config.Discover.Enable {
+		return
+	}
+
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return
+	}
+	_ = p.SkipAllAuthorities()
+	additionals, _ := p.AllAdditionals()
+
+	var (
+		ptrs = make(map[string]uint32)
+		srvs = make(map[string]*dnsmessage.SRVResource)
+		ips  = make(map[string]net.IP)
+	)
+
+	for _, r := range append(answers, additionals...) {
+		name := strings.ToLower(r.Header.Name.String())
+		switch body := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if name == MDNSSocksService {
+				ptrs[body.PTR.String()] = r.Header.TTL
+			}
+		case *dnsmessage.SRVResource:
+			srvs[r.Header.Name.String()] = body
+		case *dnsmessage.AResource:
+			ips[name] = net.IP(body.A[:])
+		}
+	}
+
+	for instance, ttl := range ptrs {
+		srv := srvs[instance]
+		if srv == nil || m.own(instance) {
+			continue
+		}
+
+		// the sender address is used if the host record is missing
+		ip := ips[strings.ToLower(srv.Target.String())]
+		if ip == nil {
+			ip = from.IP
+		}
+
+		m.learn(instance, net.JoinHostPort(ip.String(), strconv.Itoa(int(srv.Port))), ttl)
+	}
+}
+
+// own returns true if the instance is advertised by itself
+func (m *MDNS) own(instance string) bool {
+	for _, s := range m.advertised {
+		if strings.EqualFold(s.Instance, instance) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// learn to add the discovered service to the pool, or remove it if the ttl is zero
+func (m *MDNS) learn(instance, addr string, ttl uint32) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	current := m.discovered[instance]
+	if ttl == 0 {
+		if current != nil {
+			m.forget(current)
+		}
+		return
+	}
+
+	if current != nil && current.Addr == addr {
+		current.Expires = time.Now().Add(time.Duration(ttl) * time.Second)
+		return
+	}
+
+	if current != nil {
+		m.forget(current)
+	}
+
+	// the backends of the configuration are kept as they are
+	if m.pool.Get(addr) != nil {
+		return
+	}
+
+	backend := NewBackend(addr, m.config.Discover.CheckConfig)
+	backend.Labels = map[string]string{
+		"group":  m.config.Discover.Group,
+		"source": MDNSSourceLabel,
+	}
+
+	if err := m.pool.Add(backend); err != nil {
+		log.Error(err)
+		return
+	}
+
+	m.discovered[instance] = &MDNSService{
+		Instance: instance,
+		Service:  MDNSSocksService,
+		Addr:     addr,
+		Expires:  time.Now().Add(time.Duration(ttl) * time.Second),
+	}
+	RecordEvent("mdns.discovered", "socks5 proxy %s is discovered at %s", instance, addr)
+
+	if backend.CheckConfig.CheckURL != "" {
+		go func() {
+			if err := backend.Check(); err != nil {
+				log.Debugf("[mdns] check the discovered backend %s failed, %v", addr, err)
+			}
+		}()
+	}
+}
+
+// forget to remove the discovered service from the pool, the lock is held by the caller
+func (m *MDNS) forget(service *MDNSService) {
+	delete(m.discovered, service.Instance)
+	if backend := m.pool.Get(service.Addr); backend != nil && backend.Labels["source"] == MDNSSourceLabel {
+		if err := m.pool.Remove(service.Addr); err != nil {
+			log.Warnf("[mdns] remove the discovered backend %s failed, %v", service.Addr, err)
+		}
+	}
+	RecordEvent("mdns.removed", "socks5 proxy %s at %s is gone", service.Instance, service.Addr)
+}
+
+// expire to forget the services not announced before their ttl
+func (m *MDNS) expire() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	for _, service := range m.discovered {
+		if now.After(service.Expires) {
+			m.forget(service)
+		}
+	}
+}
+
+// Serve to answer the queries, and query the services periodically if the discovery is enabled,
+// it returns nil after closed
+func (m *MDNS) Serve() error {
+	for _, s := range m.advertised {
+		log.Infof("[mdns] advertise %s at %s", s.Instance, s.Addr)
+	}
+
+	go func() {
+		announces := time.NewTicker(mdnsAnnounceInterval)
+		defer announces.Stop()
+
+		m.announce("", mdnsTTL)
+
+		// the queries are never sent if the discovery is disabled
+		var queries <-chan time.Time
+		if m.config.Discover.Enable {
+			ticker := time.NewTicker(time.Duration(m.config.Discover.Interval) * time.Second)
+			defer ticker.Stop()
+			queries = ticker.C
+
+			m.query()
+			m.expire()
+		}
+
+		for {
+			select {
+			case <-m.closed:
+				return
+			case <-announces.C:
+				m.announce("", mdnsTTL)
+			case <-queries:
+				m.query()
+				m.expire()
+			}
+		}
+	}()
+
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := m.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-m.closed:
+				return nil
+			default:
+				return fmt.Errorf("[mdns] read failed, %v", err)
+			}
+		}
+
+		m.handle(buf[:n], from)
+	}
+}
+
+// Close to say goodbye to the lan, and leave the multicast group
+func (m *MDNS) Close() error {
+	select {
+	case <-m.closed:
+		return nil
+	default:
+		close(m.closed)
+	}
+
+	m.announce("", 0)
+	return m.conn.Close()
+}
+
+// Advertised returns the advertised services
+func (m *MDNS) Advertised() []MDNSService {
+	return append([]MDNSService{}, m.advertised...)
+}
+
+// Discovered returns the discovered services in the pool
+func (m *MDNS) Discovered() (services []MDNSService) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	services = []MDNSService{}
+	for _, service := range m.discovered {
+		services = append(services, *service)
+	}
+
+	return
+}
@@ -0,0 +1,119 @@
+package socks5lb
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// MDNSGroup returns a multicast group on a free port, skip the test if the
+// multicast packets are not looped back on this host
+func MDNSGroup(t *testing.T) string {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+	_ = conn.Close()
+
+	group, err := net.ResolveUDPAddr("udp4", "224.0.0.251:"+port)
+	assert.NoError(t, err)
+
+	listener, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		t.Skipf("multicast is not available, %v", err)
+	}
+	defer listener.Close()
+
+	sender, err := net.DialUDP("udp4", nil, group)
+	assert.NoError(t, err)
+	defer sender.Close()
+
+	if _, err = sender.Write([]byte("ping")); err != nil {
+		t.Skipf("multicast is not available, %v", err)
+	}
+
+	_ = listener.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err = listener.ReadFromUDP(make([]byte, 16)); err != nil {
+		t.Skipf("multicast is not looped back, %v", err)
+	}
+
+	return group.String()
+}
+
+func TestMDNS_Discover(t *testing.T) {
+	group := MDNSGroup(t)
+	addr := NewTestSocks5Server(t)
+
+	config := ServerConfig{}
+	config.Sock5.Addr = addr
+	config.HTTPProxy.Addr = ":0"
+	config.MDNS = MDNSConfig{Advertise: true, Name: "lan.proxy", Group: group}
+
+	advertiser, err := NewMDNS(&Pool{backends: make(map[string]*Backend)}, config)
+	assert.NoError(t, err)
+	assert.Len(t, advertiser.Advertised(), 1)
+	assert.Equal(t, "lan-proxy."+MDNSSocksService, advertiser.Advertised()[0].Instance)
+
+	pool := &Pool{backends: make(map[string]*Backend)}
+	discoverer, err := NewMDNS(pool, ServerConfig{MDNS: MDNSConfig{
+		Name:  "discoverer",
+		Group: group,
+		Discover: MDNSDiscoverConfig{
+			Enable:      true,
+			Group:       "lan",
+			CheckConfig: BackendCheckConfig{InitialAlive: true},
+		},
+	}})
+	assert.NoError(t, err)
+	defer discoverer.Close()
+
+	go func() { _ = discoverer.Serve() }()
+	go func() { _ = advertiser.Serve() }()
+
+	assert.Eventually(t, func() bool {
+		return pool.Get(addr) != nil
+	}, 5*time.Second, 50*time.Millisecond)
+
+	backend := pool.Get(addr)
+	assert.Equal(t, "lan", backend.Labels["group"])
+	assert.Equal(t, MDNSSourceLabel, backend.Labels["source"])
+	assert.Len(t, discoverer.Discovered(), 1)
+
+	selector, err := ParseSelector("group=lan")
+	assert.NoError(t, err)
+	assert.Len(t, pool.Select(selector), 1)
+
+	// the goodbye removes the backend
+	assert.NoError(t, advertiser.Close())
+	assert.Eventually(t, func() bool {
+		return pool.Get(addr) == nil
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Empty(t, discoverer.Discovered())
+}
+
+func TestMDNS_SkipOwnAndConfigured(t *testing.T) {
+	group := MDNSGroup(t)
+	addr := NewTestSocks5Server(t)
+
+	configured := NewBackend(addr, BackendCheckConfig{InitialAlive: true})
+	pool := &Pool{backends: make(map[string]*Backend)}
+	assert.NoError(t, pool.Add(configured))
+
+	config := ServerConfig{}
+	config.Sock5.Addr = addr
+	config.MDNS = MDNSConfig{Advertise: true, Name: "self", Group: group, Discover: MDNSDiscoverConfig{Enable: true}}
+
+	mdns, err := NewMDNS(pool, config)
+	assert.NoError(t, err)
+	defer mdns.Close()
+
+	mdns.learn("other."+MDNSSocksService, addr, mdnsTTL)
+	assert.Empty(t, mdns.Discovered())
+	assert.Same(t, configured, pool.Get(addr))
+
+	go func() { _ = mdns.Serve() }()
+	time.Sleep(200 * time.Millisecond)
+	assert.Empty(t, mdns.Discovered())
+	assert.Len(t, pool.All(), 1)
+}
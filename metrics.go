@@ -0,0 +1,60 @@
+/**
+ * File: metrics.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Saturday, October 17th 2026, 7:12:36 am
+ * Last Modified: Saturday, October 17th 2026, 7:12:36 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// metricsContentType is the content type of the prometheus text exposition format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricLabelEscaper escapes the label values of the prometheus text format
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteMetrics to write the hit statistics of the routing rules in the prometheus text format
+func (r *Rules) WriteMetrics(w io.Writer) (err error) {
+	stats := r.Stats()
+
+	for _, metric := range []struct {
+		name, kind, help string
+		value            func(stat RuleStats) float64
+	}{
+		{"socks5lb_rule_matches_total", "counter", "The destinations matched by the routing rule.",
+			func(stat RuleStats) float64 { return float64(stat.Matches) }},
+		{"socks5lb_rule_sessions_total", "counter", "The sessions relayed by the routing rule.",
+			func(stat RuleStats) float64 { return float64(stat.Sessions) }},
+		{"socks5lb_rule_bytes_total", "counter", "The bytes transferred by the sessions of the routing rule.",
+			func(stat RuleStats) float64 { return float64(stat.Bytes) }},
+		{"socks5lb_rule_last_hit_timestamp_seconds", "gauge", "The last time the routing rule is matched, zero if never.",
+			func(stat RuleStats) float64 {
+				if stat.LastHit.IsZero() {
+					return 0
+				}
+				return float64(stat.LastHit.UnixNano()) / 1e9
+			}},
+	} {
+		if _, err = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind); err != nil {
+			return
+		}
+
+		for _, stat := range stats {
+			if _, err = fmt.Fprintf(w, "%s{rule=\"%s\",selector=\"%s\"} %g\n", metric.name,
+				metricLabelEscaper.Replace(stat.Name), metricLabelEscaper.Replace(stat.Selector), metric.value(stat)); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
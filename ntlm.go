@@ -0,0 +1,167 @@
+/**
+ * File: ntlm.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Monday, October 26th 2026, 11:05:37 am
+ * Last Modified: Monday, October 26th 2026, 11:05:37 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+// the NTLM messages, see [MS-NLMP] https://learn.microsoft.com/openspecs/windows_protocols/ms-nlmp
+const (
+	ntlmNegotiateUnicode          = 0x00000001
+	ntlmRequestTarget             = 0x00000004
+	ntlmNegotiateNTLM             = 0x00000200
+	ntlmNegotiateAlwaysSign       = 0x00008000
+	ntlmNegotiateExtendedSecurity = 0x00080000
+	ntlmNegotiateTargetInfo       = 0x00800000
+	ntlmNegotiate128              = 0x20000000
+	ntlmNegotiate56               = 0x80000000
+
+	ntlmNegotiateFlags = ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign |
+		ntlmNegotiateExtendedSecurity | ntlmNegotiateTargetInfo | ntlmNegotiate128 | ntlmNegotiate56
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// ntlmChallenge is the challenge message from the server
+type ntlmChallenge struct {
+	flags      uint32
+	challenge  []byte
+	targetInfo []byte
+}
+
+// ntlmUTF16 returns the string in UTF-16LE
+func ntlmUTF16(s string) []byte {
+	codes := utf16.Encode([]rune(s))
+	data := make([]byte, len(codes)*2)
+	for i, code := range codes {
+		binary.LittleEndian.PutUint16(data[i*2:], code)
+	}
+
+	return data
+}
+
+// ntlmNegotiateMessage returns the first message of the NTLM handshake
+func ntlmNegotiateMessage() []byte {
+	msg := make([]byte, 32)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], ntlmNegotiateFlags)
+
+	// the empty domain and workstation fields point to the end of the message
+	binary.LittleEndian.PutUint32(msg[20:], 32)
+	binary.LittleEndian.PutUint32(msg[28:], 32)
+
+	return msg
+}
+
+// ntlmField returns the payload of the field at the offset of the message
+func ntlmField(msg []byte, offset int) ([]byte, error) {
+	if len(msg) < offset+8 {
+		return nil, errors.New("the ntlm message is truncated")
+	}
+
+	length := int(binary.LittleEndian.Uint16(msg[offset:]))
+	start := int(binary.LittleEndian.Uint32(msg[offset+4:]))
+	if start+length > len(msg) {
+		return nil, errors.New("the ntlm field is out of the message")
+	}
+
+	return msg[start : start+length], nil
+}
+
+// parseNTLMChallenge parses the second message of the NTLM handshake
+func parseNTLMChallenge(msg []byte) (challenge *ntlmChallenge, err error) {
+	if len(msg) < 32 || !bytes.Equal(msg[:8], ntlmSignature) || binary.LittleEndian.Uint32(msg[8:]) != 2 {
+		return nil, errors.New("not a ntlm challenge message")
+	}
+
+	challenge = &ntlmChallenge{
+		flags:     binary.LittleEndian.Uint32(msg[20:]),
+		challenge: msg[24:32],
+	}
+
+	if challenge.flags&ntlmNegotiateTargetInfo != 0 && len(msg) >= 48 {
+		if challenge.targetInfo, err = ntlmField(msg, 40); err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
+
+// ntlmV2Hash returns the NTOWFv2 of the credentials
+func ntlmV2Hash(user, domain, password string) []byte {
+	hash := md4.New()
+	hash.Write(ntlmUTF16(password))
+
+	mac := hmac.New(md5.New, hash.Sum(nil))
+	mac.Write(ntlmUTF16(strings.ToUpper(user) + domain))
+	return mac.Sum(nil)
+}
+
+// ntlmHMAC returns the HMAC-MD5 of the data by the key
+func ntlmHMAC(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(md5.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+
+	return mac.Sum(nil)
+}
+
+// ntlmAuthenticateMessage returns the last message of the NTLM handshake with the NTLMv2 responses
+func ntlmAuthenticateMessage(challenge *ntlmChallenge, user, domain, password, workstation string) []byte {
+	clientChallenge := make([]byte, 8)
+	_, _ = rand.Read(clientChallenge)
+
+	// the windows file time, 100ns since 1601
+	timestamp := make([]byte, 8)
+	binary.LittleEndian.PutUint64(timestamp, uint64(time.Now().UnixNano()/100+116444736000000000))
+
+	var blob bytes.Buffer
+	blob.Write([]byte{1, 1, 0, 0, 0, 0, 0, 0})
+	blob.Write(timestamp)
+	blob.Write(clientChallenge)
+	blob.Write([]byte{0, 0, 0, 0})
+	blob.Write(challenge.targetInfo)
+	blob.Write([]byte{0, 0, 0, 0})
+
+	key := ntlmV2Hash(user, domain, password)
+	nt := append(ntlmHMAC(key, challenge.challenge, blob.Bytes()), blob.Bytes()...)
+	lm := append(ntlmHMAC(key, challenge.challenge, clientChallenge), clientChallenge...)
+
+	fields := [][]byte{lm, nt, ntlmUTF16(domain), ntlmUTF16(user), ntlmUTF16(workstation), nil}
+	msg := make([]byte, 64)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+	binary.LittleEndian.PutUint32(msg[60:], ntlmNegotiateFlags&challenge.flags|ntlmNegotiateUnicode)
+
+	for i, field := range fields {
+		offset := 12 + i*8
+		binary.LittleEndian.PutUint16(msg[offset:], uint16(len(field)))
+		binary.LittleEndian.PutUint16(msg[offset+2:], uint16(len(field)))
+		binary.LittleEndian.PutUint32(msg[offset+4:], uint32(len(msg)))
+		msg = append(msg, field...)
+	}
+
+	return msg
+}
@@ -0,0 +1,332 @@
+/**
+ * File: pick.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Thursday, October 29th 2026, 9:18:04 am
+ * Last Modified: Thursday, October 29th 2026, 9:18:04 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultPickAddr = "127.0.0.1"
+	defaultPickTTL  = 300
+	// maxPicks is the max number of the outstanding picks
+	maxPicks = 65536
+)
+
+type PickConfig struct {
+	// Addr is the host to listen on for the ports of the picked backends with the credentials, 127.0.0.1 by default,
+	// the ports require the random credentials of each pick, so the other hosts could be allowed too
+	Addr string `yaml:"addr"`
+	// TTL is how long the picked backend and its port are valid in seconds, 300 by default
+	TTL uint `yaml:"ttl"`
+}
+
+// Pick is a backend picked for an external application, the backends with the
+// credentials are picked with a socks5 port of their own instead of the credentials,
+// and the port requires the random username and password of the pick
+type Pick struct {
+	ID       string    `json:"id"`
+	Backend  string    `json:"backend"`
+	Addr     string    `json:"addr"`
+	Protocol string    `json:"protocol"`
+	Username string    `json:"username,omitempty"`
+	Password string    `json:"password,omitempty"`
+	Expires  time.Time `json:"expires"`
+
+	key      string
+	backend  *Backend
+	listener net.Listener
+}
+
+// PickResult is the outcome of the connection reported by the application
+type PickResult struct {
+	// Error is empty if the connection is successful
+	Error string `json:"error"`
+	// Latency is the connect latency in milliseconds, the success without it is counted only
+	Latency int64 `json:"latency"`
+}
+
+// PickStats are the picks and the reported outcomes of a backend
+type PickStats struct {
+	Picks     uint64 `json:"picks"`
+	Successes uint64 `json:"successes"`
+	Failures  uint64 `json:"failures"`
+	// Expired are the picks without any result reported in time
+	Expired uint64 `json:"expired"`
+}
+
+// pickStore keeps the outstanding picks until their results are reported
+type pickStore struct {
+	addr  string
+	lock  sync.Mutex
+	ttl   time.Duration
+	picks map[string]*Pick
+	stats map[string]*PickStats
+}
+
+// newPickStore returns the pick store by the configuration
+func newPickStore(config PickConfig) *pickStore {
+	if config.Addr == "" {
+		config.Addr = defaultPickAddr
+	}
+
+	if config.TTL == 0 {
+		config.TTL = defaultPickTTL
+	}
+
+	return &pickStore{
+		addr:  config.Addr,
+		ttl:   time.Duration(config.TTL) * time.Second,
+		picks: make(map[string]*Pick),
+		stats: make(map[string]*PickStats),
+	}
+}
+
+// statsOf returns the stats of the backend, the lock should be held
+func (p *pickStore) statsOf(addr string) *PickStats {
+	stats := p.stats[addr]
+	if stats == nil {
+		stats = &PickStats{}
+		p.stats[addr] = stats
+	}
+
+	return stats
+}
+
+// expire to forget the picks without any result reported in time, the lock should be held
+func (p *pickStore) expire() {
+	now := time.Now()
+	for id, pick := range p.picks {
+		if now.After(pick.Expires) {
+			p.statsOf(pick.Backend).Expired++
+			p.remove(id)
+		}
+	}
+}
+
+// remove to forget the pick and close its port, the lock should be held
+func (p *pickStore) remove(id string) {
+	if pick := p.picks[id]; pick != nil && pick.listener != nil {
+		_ = pick.listener.Close()
+	}
+
+	delete(p.picks, id)
+}
+
+// Create to keep the pick of the backend for the destination key, the port is opened for
+// the backend with the credentials, which are never handed out, the port has the credentials of its own
+func (p *pickStore) Create(backend *Backend, key string) (pick *Pick, err error) {
+	pick = &Pick{
+		Backend:  backend.Addr,
+		Addr:     backend.Addr,
+		Protocol: backend.Protocol,
+		Expires:  time.Now().Add(p.ttl),
+		key:      key,
+		backend:  backend,
+	}
+
+	if pick.Protocol == "" {
+		pick.Protocol = ProtocolSocks5
+	}
+
+	if pick.ID, err = randomToken(); err != nil {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.expire()
+	if len(p.picks) >= maxPicks {
+		return nil, errors.New("too many outstanding picks")
+	}
+
+	if backend.UserName != "" || backend.Password != "" {
+		if pick.Username, pick.Password, err = portCredentials(); err != nil {
+			return nil, err
+		}
+
+		if pick.listener, err = net.Listen("tcp", net.JoinHostPort(p.addr, "0")); err != nil {
+			return nil, err
+		}
+		pick.Addr, pick.Protocol = pick.listener.Addr().String(), ProtocolSocks5
+
+		// close the port in time, even if nothing is picked or reported after it
+		time.AfterFunc(p.ttl, func() {
+			p.lock.Lock()
+			defer p.lock.Unlock()
+
+			p.expire()
+		})
+	}
+
+	p.picks[pick.ID] = pick
+	p.statsOf(pick.Backend).Picks++
+	return
+}
+
+// Complete to remove the pick by the id and count its result
+func (p *pickStore) Complete(id string, result PickResult) (*Pick, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.expire()
+	pick := p.picks[id]
+	if pick == nil {
+		return nil, fmt.Errorf("pick %s is not found or expired", id)
+	}
+	p.remove(id)
+
+	if result.Error == "" {
+		p.statsOf(pick.Backend).Successes++
+	} else {
+		p.statsOf(pick.Backend).Failures++
+	}
+
+	return pick, nil
+}
+
+// Close to forget the outstanding picks and close their ports
+func (p *pickStore) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for id := range p.picks {
+		p.remove(id)
+	}
+}
+
+// Stats returns the pick stats of each backend
+func (p *pickStore) Stats() map[string]PickStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.expire()
+	stats := make(map[string]PickStats, len(p.stats))
+	for addr, v := range p.stats {
+		stats[addr] = *v
+	}
+
+	return stats
+}
+
+// Pick returns a healthy backend matching the selector by the balancing strategy,
+// the key is the destination address for the rules and the destination learning.
+// The local backends are dialed inside socks5lb, so they are never picked
+func (b *Pool) Pick(selector Selector, key string) *Backend {
+	backends := make([]*Backend, 0)
+	for _, backend := range b.Select(selector) {
+		if backend.Available() && !backend.Local() && !backend.Command.configured() {
+			backends = append(backends, backend)
+		}
+	}
+
+	backend, _ := b.routeFrom(backends, "", key)
+	return backend
+}
+
+// servePick to relay the connections to the port of the pick through the picked backend
+func (s *Server) servePick(pick *Pick) {
+	for {
+		conn, err := pick.listener.Accept()
+		if err != nil {
+			log.Debugf("[pick] stop serving pick %s, %v", pick.ID, err)
+			return
+		}
+
+		go s.relaySocks5Conn(conn, pick.backend, pick.Username, pick.Password)
+	}
+}
+
+// setupPickRouter to handle the backend picks for the external applications
+func (s *Server) setupPickRouter(apiGroup *gin.RouterGroup) {
+
+	// pick a healthy backend by the group, the labels and the destination key
+	apiGroup.GET("pick", func(c *gin.Context) {
+		selector, err := ParseSelector(c.Query("labels"))
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if group := c.Query("group"); group != "" {
+			selector = append(selector, selectorRequirement{key: "group", op: selectorEquals, value: group})
+		}
+
+		backend := s.Pool.Pick(selector, c.Query("key"))
+		if backend == nil {
+			c.String(http.StatusServiceUnavailable, "no available backend for %s", selector)
+			return
+		}
+
+		pick, err := s.picks.Create(backend, c.Query("key"))
+		if err != nil {
+			c.String(http.StatusServiceUnavailable, err.Error())
+			return
+		}
+
+		if pick.listener != nil {
+			go s.servePick(pick)
+		}
+
+		c.JSON(http.StatusOK, pick)
+	})
+
+	// report the outcome of the picked backend
+	apiGroup.POST("pick/:id/result", func(c *gin.Context) {
+		var result PickResult
+		if err := c.ShouldBindJSON(&result); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		pick, err := s.picks.Complete(c.Param("id"), result)
+		if err != nil {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+
+		// the backend may be removed after picked
+		backend := s.Pool.Get(pick.Backend)
+		if backend == nil {
+			c.String(http.StatusGone, "backend %s is removed", pick.Backend)
+			return
+		}
+
+		// the time since picked is not the latency of the connection, so it is not learned
+		latency := time.Duration(result.Latency) * time.Millisecond
+		if latency <= 0 && result.Error == "" {
+			c.String(http.StatusOK, "result of pick %s is counted without the latency", pick.ID)
+			return
+		}
+
+		var resultErr error
+		if result.Error != "" {
+			resultErr = errors.New(result.Error)
+		}
+
+		s.Pool.Observe(pick.key, backend, latency, resultErr)
+		c.String(http.StatusOK, "result of pick %s is recorded", pick.ID)
+	})
+
+	// show the picks and the reported outcomes of each backend
+	apiGroup.GET("picks", func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.picks.Stats())
+	})
+}
@@ -0,0 +1,183 @@
+package socks5lb
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/txthinking/socks5"
+)
+
+func TestServer_HTTPPick(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer target.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+
+	upstream, err := socks5.NewClassicServer(l.Addr().String(), "127.0.0.1", "alice", "s3cret", 5, 5)
+	assert.NoError(t, err)
+	go serveClassicSocks5(upstream, l)
+
+	pool := &Pool{backends: make(map[string]*Backend)}
+	pool.SetBandit(NewBandit(BanditConfig{Enable: true}))
+
+	lan := NewBackend(l.Addr().String(), BackendCheckConfig{InitialAlive: true, Timeout: 5})
+	lan.Labels = map[string]string{"group": "lan", "country": "cn"}
+	lan.UserName, lan.Password = "alice", "s3cret"
+	down := NewBackend("192.168.1.11:1080", BackendCheckConfig{})
+	down.Labels = map[string]string{"group": "lan", "country": "cn"}
+	direct := NewBackend("direct", BackendCheckConfig{InitialAlive: true})
+	direct.Labels = map[string]string{"group": "lan", "country": "cn"}
+	direct.Protocol = ProtocolDirect
+	wan := NewBackend("203.0.113.1:1080", BackendCheckConfig{InitialAlive: true})
+	wan.Labels = map[string]string{"group": "wan", "country": "us"}
+	wan.Protocol = ProtocolHTTP
+	for _, backend := range []*Backend{lan, down, direct, wan} {
+		assert.NoError(t, pool.Add(backend))
+	}
+
+	server, err := NewServer(pool, ServerConfig{Pick: PickConfig{TTL: 60}})
+	assert.NoError(t, err)
+	defer server.picks.Close()
+	router, err := server.newRouter()
+	assert.NoError(t, err)
+
+	pick := func(query string) (int, Pick) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/api/pick?"+query, nil)
+		router.ServeHTTP(w, req)
+
+		var pick Pick
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &pick))
+		}
+		return w.Code, pick
+	}
+
+	report := func(id, body string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/pick/"+id+"/result", strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// only the healthy remote backend in the group is picked, on a port of its own
+	for i := 0; i < 3; i++ {
+		code, lanPick := pick("group=lan")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, lan.Addr, lanPick.Backend)
+	}
+
+	code, lanPick := pick("group=lan&key=example.com:443")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, lan.Addr, lanPick.Backend)
+	assert.NotEqual(t, lan.Addr, lanPick.Addr)
+	assert.Equal(t, ProtocolSocks5, lanPick.Protocol)
+	assert.NotEmpty(t, lanPick.ID)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), lanPick.Expires, 5*time.Second)
+
+	// the credentials of the backend are never handed out
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/pick?group=lan", nil)
+	router.ServeHTTP(w, req)
+	assert.NotContains(t, w.Body.String(), "s3cret")
+
+	// the port requires the credentials of the pick
+	assert.NotEmpty(t, lanPick.Username)
+	assert.NotEmpty(t, lanPick.Password)
+	for _, credentials := range [][2]string{{"", ""}, {lanPick.Username, "s3cret"}} {
+		client, err := socks5.NewClient(lanPick.Addr, credentials[0], credentials[1], 5, 5)
+		assert.NoError(t, err)
+		_, err = (&http.Client{Transport: &http.Transport{Dial: client.Dial}}).Get(target.URL)
+		assert.Error(t, err)
+	}
+
+	client, err := socks5.NewClient(lanPick.Addr, lanPick.Username, lanPick.Password, 5, 5)
+	assert.NoError(t, err)
+	resp, err := (&http.Client{Transport: &http.Transport{Dial: client.Dial}}).Get(target.URL)
+	if assert.NoError(t, err) {
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	}
+
+	code, wanPick := pick("labels=country=us")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, wan.Addr, wanPick.Addr)
+	assert.Equal(t, ProtocolHTTP, wanPick.Protocol)
+	assert.Empty(t, wanPick.Password)
+
+	code, _ = pick("group=lan&labels=country=us")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	code, _ = pick("labels=!=us")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// the outcome feeds the destination learning, once for each pick
+	assert.Equal(t, http.StatusOK, report(lanPick.ID, `{"latency": 120}`))
+	assert.Equal(t, http.StatusNotFound, report(lanPick.ID, `{}`))
+	_, err = net.DialTimeout("tcp", lanPick.Addr, time.Second)
+	assert.Error(t, err)
+
+	// the success without the latency is counted, but not learned
+	code, lanPick = pick("group=lan&key=example.org:443")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, http.StatusOK, report(lanPick.ID, `{}`))
+	assert.Nil(t, pool.Bandit().Snapshot()["example.org"])
+
+	assert.Equal(t, http.StatusOK, report(wanPick.ID, `{"error": "connection refused"}`))
+	assert.Equal(t, http.StatusBadRequest, report("unknown", `not json`))
+
+	arms := pool.Bandit().Snapshot()["example.com"]
+	if assert.NotNil(t, arms) {
+		assert.Equal(t, uint64(1), arms[lan.Addr].Successes)
+		assert.Equal(t, 120*time.Millisecond, arms[lan.Addr].Latency)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/api/picks", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var stats map[string]PickStats
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, PickStats{Picks: 6, Successes: 2}, stats[lan.Addr])
+	assert.Empty(t, stats[direct.Addr])
+	assert.Equal(t, PickStats{Picks: 1, Failures: 1}, stats[wan.Addr])
+}
+
+func TestPickStore_Expire(t *testing.T) {
+	store := newPickStore(PickConfig{TTL: 1})
+	store.ttl = time.Millisecond
+
+	backend := NewBackend("10.30.0.1:1080", BackendCheckConfig{InitialAlive: true})
+	pick, err := store.Create(backend, "")
+	assert.NoError(t, err)
+	assert.Nil(t, pick.listener)
+
+	time.Sleep(5 * time.Millisecond)
+	_, err = store.Complete(pick.ID, PickResult{})
+	assert.Error(t, err)
+	assert.Equal(t, PickStats{Picks: 1, Expired: 1}, store.Stats()[backend.Addr])
+
+	// the port is closed in time without any other request
+	backend.UserName, backend.Password = "alice", "s3cret"
+	store.ttl = 50 * time.Millisecond
+	pick, err = store.Create(backend, "")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", pick.Addr)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+}
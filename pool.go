@@ -12,16 +12,25 @@ package socks5lb
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
 type Pool struct {
-	current  uint64
-	backends map[string]*Backend
-	lock     sync.Mutex
+	current   uint64
+	backends  map[string]*Backend
+	lock      sync.Mutex
+	bandit    *Bandit
+	targets   *CheckTargets
+	rules     *Rules
+	diversity *Diversity
+	commands  bool
+	// selector is the default backend group of the destinations not matched by the rules
+	selector Selector
 }
 
 // Add add a backend to the pool
@@ -33,6 +42,10 @@ func (b *Pool) Add(backend *Backend) (err error) {
 	}
 
 	b.backends[backend.Addr] = backend
+	if b.commands {
+		backend.startCommand()
+	}
+	RecordEvent("backend.added", "backend %s is added", backend.Addr)
 	return
 }
 
@@ -44,12 +57,73 @@ func (b *Pool) Remove(addr string) (err error) {
 	if b.backends[addr] == nil {
 		return fmt.Errorf("server %s is not exists", addr)
 	}
+
+	// the connections to the dedicated port of the lease are still relayed by the backend
+	if b.backends[addr].leased {
+		return fmt.Errorf("server %s is refused to remove, %w", addr, errBackendLeased)
+	}
+	b.backends[addr].closeWireGuard()
+	b.backends[addr].closeKerberos()
+	b.backends[addr].stopCommand()
 	delete(b.backends, addr)
+	if b.bandit != nil {
+		b.bandit.Forget(addr)
+	}
+	if b.targets != nil {
+		b.targets.Forget(addr)
+	}
+	RecordEvent("backend.removed", "backend %s is removed", addr)
 	return
 }
 
+// StartCommands to supervise the helper processes of the backends, also the ones added later
+func (b *Pool) StartCommands() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.commands = true
+	for _, backend := range b.backends {
+		backend.startCommand()
+	}
+}
+
+// StopCommands to stop the helper processes of the backends and wait for them
+func (b *Pool) StopCommands() {
+	b.lock.Lock()
+	b.commands = false
+
+	var stopped []<-chan struct{}
+	for _, backend := range b.backends {
+		if done := backend.stopCommand(); done != nil {
+			stopped = append(stopped, done)
+		}
+	}
+	b.lock.Unlock()
+
+	for _, done := range stopped {
+		<-done
+	}
+}
+
+// HasCommands returns true if any backend has a helper process
+func (b *Pool) HasCommands() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, backend := range b.backends {
+		if len(backend.Command.Args) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
 // All returns all backends
 func (b *Pool) All() (backends []*Backend) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
 	for _, v := range b.backends {
 		backends = append(backends, v)
 	}
@@ -58,6 +132,9 @@ func (b *Pool) All() (backends []*Backend) {
 
 // AllHealthy returns all healthy backends
 func (b *Pool) AllHealthy() (backends []*Backend) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
 	for _, v := range b.backends {
 		if v.Alive() {
 			backends = append(backends, v)
@@ -67,17 +144,120 @@ func (b *Pool) AllHealthy() (backends []*Backend) {
 	return
 }
 
+// AllAvailable returns all backends which are able to accept new connections
+func (b *Pool) AllAvailable() (backends []*Backend) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, v := range b.backends {
+		if v.Available() {
+			backends = append(backends, v)
+		}
+	}
+
+	return
+}
+
+// Get returns the backend by the given address, nil if not exists
+func (b *Pool) Get(addr string) *Backend {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.backends[addr]
+}
+
+// Select returns the backends matching the label selector, sorted by address
+func (b *Pool) Select(selector Selector) (backends []*Backend) {
+	b.lock.Lock()
+	for _, v := range b.backends {
+		if selector.Matches(v.Labels) {
+			backends = append(backends, v)
+		}
+	}
+	b.lock.Unlock()
+
+	sort.Slice(backends, func(i, j int) bool {
+		return backends[i].Addr < backends[j].Addr
+	})
+
+	return
+}
+
+// SetWeight to change the balancing weight of the backend
+func (b *Pool) SetWeight(backend *Backend, weight uint) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	backend.Weight = weight
+	backend.currentWeight = 0
+}
+
+// setLeased to reserve the backend for a lease or release it, which is refused
+// if the backend is removed from the pool meanwhile
+func (b *Pool) setLeased(backend *Backend, leased bool) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if leased && b.backends[backend.Addr] != backend {
+		return fmt.Errorf("server %s is removed", backend.Addr)
+	}
+
+	backend.leased = leased
+	return nil
+}
+
+// nextWeighted picks a backend by the smooth weighted round-robin algorithm
+func (b *Pool) nextWeighted(backends []*Backend) (best *Backend) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	total := 0
+	for _, v := range backends {
+		weight := v.EffectiveWeight()
+		v.currentWeight += weight
+		total += weight
+
+		if best == nil || v.currentWeight > best.currentWeight {
+			best = v
+		}
+	}
+
+	if best != nil {
+		best.currentWeight -= total
+	}
+
+	return
+}
+
 // NextIndex returns the next index for loadbalancer interface
 func (b *Pool) NextIndex() int {
 	return int(atomic.AddUint64(&b.current, uint64(1)) % uint64(len(b.backends)))
 }
 
 // Next returns the next index in the pool if there is one available
-// Only supports round-robin operations by default
+// Only supports round-robin operations by default, weighted if any backend has a weight
 func (b *Pool) Next() *Backend {
 
 	// return healthy backends first
-	backends := b.AllHealthy()
+	return b.next(b.selectDefault(b.AllAvailable()))
+}
+
+// NextForUser returns the next backend for the connection of the user, whose destination
+// is not known, the networks recently used by the user are avoided if the diversity is enabled
+func (b *Pool) NextForUser(user string) (backend *Backend) {
+	backends := b.selectDefault(b.AllAvailable())
+	if diversity := b.Diversity(); diversity != nil {
+		backends = diversity.Filter(user, backends)
+		defer func() {
+			diversity.Record(user, backend)
+		}()
+	}
+
+	return b.next(backends)
+}
+
+// next returns the next backend of the given available backends
+func (b *Pool) next(backends []*Backend) *Backend {
 	log.Tracef("found all %d available backends", len(backends))
 
 	// can not found any backends available
@@ -85,6 +265,12 @@ func (b *Pool) Next() *Backend {
 		return nil
 	}
 
+	for _, v := range backends {
+		if v.EffectiveWeight() > 1 {
+			return b.nextWeighted(backends)
+		}
+	}
+
 	// loop entire backends to find out an Alive backend
 	next := b.NextIndex()
 	// start from next and move a full cycle
@@ -95,7 +281,7 @@ func (b *Pool) Next() *Backend {
 		idx := i % len(backends)
 
 		// if we have an alive backend, use it and store if its not the original one
-		if backends[idx].Alive() {
+		if backends[idx].Available() {
 			if i != next {
 				atomic.StoreUint64(&b.current, uint64(idx))
 			}
@@ -107,10 +293,213 @@ func (b *Pool) Next() *Backend {
 	return nil
 }
 
+// SetBandit to learn the best backend for each destination
+func (b *Pool) SetBandit(bandit *Bandit) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.bandit = bandit
+}
+
+// Bandit returns the destination learning, nil if it is disabled
+func (b *Pool) Bandit() *Bandit {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.bandit
+}
+
+// SetCheckTargets to check the most accessed destinations through the backends
+func (b *Pool) SetCheckTargets(targets *CheckTargets) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.targets = targets
+}
+
+// CheckTargets returns the adaptive check targets, nil if it is disabled
+func (b *Pool) CheckTargets() *CheckTargets {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.targets
+}
+
+// SetDiversity to spread the sessions of each user across the distinct networks
+func (b *Pool) SetDiversity(diversity *Diversity) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.diversity = diversity
+}
+
+// Diversity returns the egress diversity strategy, nil if it is disabled
+func (b *Pool) Diversity() *Diversity {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.diversity
+}
+
+// SetRules to route the destinations to the backends selected by the rules
+func (b *Pool) SetRules(rules *Rules) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.rules = rules
+}
+
+// Rules returns the routing rules, nil if there is no rule
+func (b *Pool) Rules() *Rules {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.rules
+}
+
+// SetDefaultSelector to use the selected backends only for the destinations not matched by the rules
+func (b *Pool) SetDefaultSelector(selector Selector) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.selector = selector
+}
+
+// selectDefault returns the backends in the default backend group
+func (b *Pool) selectDefault(backends []*Backend) []*Backend {
+	b.lock.Lock()
+	selector := b.selector
+	b.lock.Unlock()
+
+	if len(selector) == 0 {
+		return backends
+	}
+
+	selected := make([]*Backend, 0, len(backends))
+	for _, backend := range backends {
+		if selector.Matches(backend.Labels) {
+			selected = append(selected, backend)
+		}
+	}
+
+	return selected
+}
+
+// NextFor returns the next backend for the destination address, the learned
+// best one first, falls back to Next for the unseen destinations
+func (b *Pool) NextFor(addr string) *Backend {
+	backend, _ := b.route("", addr)
+	return backend
+}
+
+// route returns the next backend for the destination address with the matched
+// routing rule, only the backends selected by the rule are used if any, the
+// networks recently used by the user are avoided if the diversity is enabled
+func (b *Pool) route(user, addr string) (backend *Backend, matched *rule) {
+	backend, matched = b.routeFrom(b.AllAvailable(), user, addr)
+	matched.hit(addr)
+	return
+}
+
+// routeFrom returns the next backend of the given available backends for the destination address,
+// the matched rule is not counted, since the backend may be picked without any connection relayed
+func (b *Pool) routeFrom(backends []*Backend, user, addr string) (backend *Backend, matched *rule) {
+	matched = b.Rules().lookup(addr)
+	if matched != nil {
+		selected := make([]*Backend, 0, len(backends))
+		for _, backend := range backends {
+			if matched.selector.Matches(backend.Labels) {
+				selected = append(selected, backend)
+			}
+		}
+		backends = selected
+	} else {
+		backends = b.selectDefault(backends)
+	}
+
+	// avoid the backends which failed to reach the destination in the last check
+	if targets := b.CheckTargets(); targets != nil {
+		if dest, ok := anonymizeDestination(addr); ok {
+			if filtered := targets.Filter(dest, backends); len(filtered) > 0 {
+				backends = filtered
+			}
+		}
+	}
+
+	diversity := b.Diversity()
+	if diversity != nil {
+		backends = diversity.Filter(user, backends)
+		defer func() {
+			diversity.Record(user, backend)
+		}()
+	}
+
+	if bandit := b.Bandit(); bandit != nil {
+		if backend = bandit.Select(addr, backends); backend != nil {
+			return
+		}
+	}
+
+	return b.next(backends), matched
+}
+
+// Observe to feed the result of a connection to the destination learning
+func (b *Pool) Observe(addr string, backend *Backend, latency time.Duration, err error) {
+	if err == nil {
+		backend.recordLatency(latency)
+	} else {
+		backend.recordFailure(latency, err)
+	}
+
+	// keep nothing about the destination in the privacy mode if it is dropped
+	addr, ok := anonymizeDestination(addr)
+	if !ok {
+		return
+	}
+
+	if bandit := b.Bandit(); bandit != nil {
+		bandit.Observe(addr, backend, latency, err)
+	}
+
+	if targets := b.CheckTargets(); targets != nil {
+		targets.Record(addr)
+	}
+}
+
 // Check if we have an alive backend
 func (b *Pool) Check() {
-	for _, b := range b.backends {
+	targets := b.CheckTargets()
+	if targets != nil {
+		defer targets.Decay()
+	}
+
+	// the checks are slow, so they run on a snapshot of the backends
+	for _, b := range b.All() {
+		if b.Disabled() {
+			log.Debugf("backend %s is disabled, skip checking", b.Addr)
+			continue
+		}
+
+		alive := b.Alive()
 		err := b.Check()
+
+		// mark the backend down only if all the learned targets are unreachable
+		if targets != nil && b.Alive() {
+			targets.checkTargets(b)
+			if targets.Unreachable(b.Addr) {
+				err = fmt.Errorf("all the check targets are unreachable")
+				b.failCheck(err)
+			}
+		}
+
+		if alive != b.Alive() {
+			if b.Alive() {
+				RecordEvent("backend.up", "backend %s is up", b.Addr)
+			} else {
+				RecordEvent("backend.down", "backend %s is down, %v", b.Addr, err)
+			}
+		}
+
 		if err != nil {
 			log.Errorf("check backend %s is failed, error %v", b.Addr, err)
 		} else {
@@ -62,3 +62,30 @@ func TestPool_NextCheck(t *testing.T) {
 		assert.NotNil(t, next)
 	}
 }
+
+func TestPool_ConcurrentChanges(t *testing.T) {
+	pool := &Pool{backends: make(map[string]*Backend)}
+
+	// the backends are added and removed like the discovery, while the pool is iterated
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			addr := fmt.Sprintf("10.70.0.%d:1080", i%10)
+			_ = pool.Add(NewBackend(addr, BackendCheckConfig{InitialAlive: true}))
+			_ = pool.Remove(addr)
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			pool.Check()
+			_ = pool.All()
+			_ = pool.AllHealthy()
+			_ = pool.AllAvailable()
+		}
+	}
+}
@@ -0,0 +1,259 @@
+/**
+ * File: privacy.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Thursday, October 22nd 2026, 9:48:03 am
+ * Last Modified: Thursday, October 22nd 2026, 9:48:03 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/netip"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/publicsuffix"
+)
+
+const (
+	// PrivacyClientIPHash replaces the client ips by their keyed hashes
+	PrivacyClientIPHash = "hash"
+	// PrivacyClientIPTruncate keeps the /24 or /48 network of the client ips only
+	PrivacyClientIPTruncate = "truncate"
+	PrivacyClientIPDrop     = "drop"
+
+	// PrivacyDestinationETLD1 reduces the destinations to their eTLD+1
+	PrivacyDestinationETLD1 = "etld1"
+	PrivacyDestinationDrop  = "drop"
+
+	defaultPrivacyRetention = 3600
+
+	// privacyRedacted replaces the dropped ips and destinations in the logs
+	privacyRedacted = "[redacted]"
+)
+
+type PrivacyConfig struct {
+	Enable bool `yaml:"enable" json:"enable"`
+	// ClientIP is how to keep the client ips, "hash" by default, "truncate" or "drop"
+	ClientIP string `yaml:"client_ip" json:"client_ip"`
+	// Destination is how to keep the destinations, "etld1" by default, or "drop"
+	Destination string `yaml:"destination" json:"destination"`
+	// Retention is how long to keep the learned destinations and the recent logs in seconds, 3600 by default
+	Retention uint `yaml:"retention" json:"retention"`
+}
+
+var (
+	privacyConfig PrivacyConfig
+	privacyLock   sync.RWMutex
+	// privacySalt is the key of the client ip hashes, it changes on every start
+	privacySalt []byte
+
+	// privacyClientFields are the log fields of the client ips, the other fields are kept
+	privacyClientFields = map[string]bool{"client": true}
+
+	ipv4Pattern = regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}\b`)
+	ipv6Pattern = regexp.MustCompile(`\b[0-9a-fA-F]{0,4}(:[0-9a-fA-F]{0,4}){2,7}\b`)
+	hostPattern = regexp.MustCompile(`\b([a-zA-Z0-9-]+\.)+[a-zA-Z]{2,}\b`)
+)
+
+// SetPrivacy to apply the privacy mode to the process, the debug logs are disabled while it is on
+func SetPrivacy(config PrivacyConfig) {
+	if config.Enable {
+		if config.ClientIP == "" {
+			config.ClientIP = PrivacyClientIPHash
+		}
+
+		if config.Destination == "" {
+			config.Destination = PrivacyDestinationETLD1
+		}
+
+		if config.Retention == 0 {
+			config.Retention = defaultPrivacyRetention
+		}
+	}
+
+	privacyLock.Lock()
+	if config.Enable && privacySalt == nil {
+		privacySalt = make([]byte, 32)
+		_, _ = rand.Read(privacySalt)
+	}
+	privacyConfig = config
+	privacyLock.Unlock()
+
+	if config.Enable {
+		// scrub the entries before the other hooks, like the recent logs
+		hooks := make(log.LevelHooks)
+		for _, level := range log.AllLevels {
+			hooks[level] = append(hooks[level], privacyLogHook{})
+			for _, hook := range log.StandardLogger().Hooks[level] {
+				if _, ok := hook.(privacyLogHook); !ok {
+					hooks[level] = append(hooks[level], hook)
+				}
+			}
+		}
+		log.StandardLogger().ReplaceHooks(hooks)
+
+		if log.GetLevel() > log.InfoLevel {
+			log.SetLevel(log.InfoLevel)
+			log.Warn("privacy mode is enabled, the debug logs are disabled")
+		}
+	}
+}
+
+// Privacy returns the privacy mode of the process
+func Privacy() PrivacyConfig {
+	privacyLock.RLock()
+	defer privacyLock.RUnlock()
+
+	return privacyConfig
+}
+
+// anonymizeClient returns the client ip by the privacy mode, empty if it is dropped
+func anonymizeClient(ip string) string {
+	privacyLock.RLock()
+	defer privacyLock.RUnlock()
+
+	if !privacyConfig.Enable {
+		return ip
+	}
+
+	switch privacyConfig.ClientIP {
+	case PrivacyClientIPTruncate:
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return ""
+		}
+
+		bits := 48
+		if addr = addr.Unmap(); addr.Is4() {
+			bits = 24
+		}
+
+		prefix, _ := addr.Prefix(bits)
+		return prefix.Addr().String()
+	case PrivacyClientIPDrop:
+		return ""
+	default:
+		mac := hmac.New(sha256.New, privacySalt)
+		mac.Write([]byte(ip))
+		return "client-" + hex.EncodeToString(mac.Sum(nil)[:8])
+	}
+}
+
+// anonymizeDestination returns the destination address by the privacy mode, false if it is dropped
+func anonymizeDestination(addr string) (string, bool) {
+	privacyLock.RLock()
+	defer privacyLock.RUnlock()
+
+	if !privacyConfig.Enable {
+		return addr, true
+	}
+
+	if privacyConfig.Destination == PrivacyDestinationDrop {
+		return "", false
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return destinationKey(addr, true), true
+	}
+
+	return net.JoinHostPort(destinationKey(host, true), port), true
+}
+
+// scrubText replaces the ips and the hosts in the text by the privacy mode
+func scrubText(text string) string {
+	text = ipv4Pattern.ReplaceAllStringFunc(text, scrubIP)
+	text = ipv6Pattern.ReplaceAllStringFunc(text, scrubIP)
+
+	return hostPattern.ReplaceAllStringFunc(text, func(host string) string {
+		if _, ok := anonymizeDestination(host); !ok {
+			return privacyRedacted
+		}
+
+		if domain, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(host)); err == nil {
+			return domain
+		}
+
+		return host
+	})
+}
+
+// scrubIP replaces the ip by the client ip policy, the other text is kept
+func scrubIP(s string) string {
+	if net.ParseIP(s) == nil {
+		return s
+	}
+
+	if ip := anonymizeClient(s); ip != "" {
+		return ip
+	}
+
+	return privacyRedacted
+}
+
+// privacyLogHook scrubs the ips and the hosts of the log entries
+type privacyLogHook struct{}
+
+func (privacyLogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (privacyLogHook) Fire(entry *log.Entry) error {
+	if !Privacy().Enable {
+		return nil
+	}
+
+	entry.Message = scrubText(entry.Message)
+	for key, value := range entry.Data {
+		if s, ok := value.(string); ok && privacyClientFields[key] {
+			entry.Data[key] = scrubIP(s)
+		}
+	}
+
+	return nil
+}
+
+// enforceRetention to forget the learned destinations and the recent logs older than the retention
+func (s *Server) enforceRetention() {
+	retention := time.Duration(Privacy().Retention) * time.Second
+	interval := retention / 4
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+
+	timer := time.NewTicker(interval)
+	defer timer.Stop()
+
+	for range timer.C {
+		if !Privacy().Enable {
+			return
+		}
+
+		before := time.Now().Add(-retention)
+		if bandit := s.Pool.Bandit(); bandit != nil {
+			bandit.Expire(before)
+		}
+
+		if targets := s.Pool.CheckTargets(); targets != nil {
+			targets.Expire(before)
+		}
+
+		if diversity := s.Pool.Diversity(); diversity != nil {
+			diversity.Expire(before)
+		}
+
+		recentLogs.Expire(before)
+	}
+}
@@ -0,0 +1,130 @@
+package socks5lb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrivacy(t *testing.T) {
+	level := log.GetLevel()
+	defer func() {
+		SetPrivacy(PrivacyConfig{})
+		log.SetLevel(level)
+	}()
+
+	// nothing is changed if disabled
+	SetPrivacy(PrivacyConfig{})
+	assert.Equal(t, "192.168.1.23", anonymizeClient("192.168.1.23"))
+	dest, ok := anonymizeDestination("www.example.com:443")
+	assert.True(t, ok)
+	assert.Equal(t, "www.example.com:443", dest)
+
+	log.SetLevel(log.TraceLevel)
+	SetPrivacy(PrivacyConfig{Enable: true})
+	assert.Equal(t, log.InfoLevel, log.GetLevel())
+	assert.Equal(t, PrivacyClientIPHash, Privacy().ClientIP)
+	assert.Equal(t, uint(defaultPrivacyRetention), Privacy().Retention)
+
+	hashed := anonymizeClient("192.168.1.23")
+	assert.True(t, strings.HasPrefix(hashed, "client-"))
+	assert.Equal(t, hashed, anonymizeClient("192.168.1.23"))
+	assert.NotEqual(t, hashed, anonymizeClient("192.168.1.24"))
+
+	dest, ok = anonymizeDestination("www.example.co.uk:443")
+	assert.True(t, ok)
+	assert.Equal(t, "example.co.uk:443", dest)
+
+	// the logs are scrubbed before kept in memory
+	log.Errorf("[socks5-relay] 192.168.1.23:5555 -> www.example.com:443 failed")
+	lines := recentLogs.Lines()
+	line := lines[len(lines)-1]
+	assert.NotContains(t, line, "192.168.1.23")
+	assert.NotContains(t, line, "www.example.com")
+	assert.Contains(t, line, hashed+":5555 -> example.com:443")
+
+	// only the client fields are scrubbed, like the client of the access log
+	router := gin.New()
+	router.Use(accessLogger)
+	req := httptest.NewRequest(http.MethodGet, "/api/backends/10.0.0.1:1080", nil)
+	req.RemoteAddr = "192.168.1.23:5555"
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	lines = recentLogs.Lines()
+	line = lines[len(lines)-1]
+	assert.NotContains(t, line, "192.168.1.23")
+	assert.Contains(t, line, "client="+hashed)
+	assert.Contains(t, line, `path="/api/backends/10.0.0.1:1080"`)
+
+	log.WithField("agent", "Chrome/120.0.0.0").Warn("unknown agent")
+	lines = recentLogs.Lines()
+	assert.Contains(t, lines[len(lines)-1], "Chrome/120.0.0.0")
+
+	SetPrivacy(PrivacyConfig{Enable: true, ClientIP: PrivacyClientIPTruncate, Destination: PrivacyDestinationDrop})
+	assert.Equal(t, "192.168.1.0", anonymizeClient("192.168.1.23"))
+	assert.Equal(t, "2001:db8:1::", anonymizeClient("2001:db8:1:2::1"))
+	_, ok = anonymizeDestination("www.example.com:443")
+	assert.False(t, ok)
+	assert.Equal(t, "192.168.1.0 -> [redacted]:443", scrubText("192.168.1.23 -> www.example.com:443"))
+
+	SetPrivacy(PrivacyConfig{Enable: true, ClientIP: PrivacyClientIPDrop})
+	assert.Empty(t, anonymizeClient("192.168.1.23"))
+	assert.Equal(t, "[redacted] -> example.com:443", scrubText("192.168.1.23 -> www.example.com:443"))
+}
+
+func TestPrivacy_Retention(t *testing.T) {
+	bandit := NewBandit(BanditConfig{Enable: true})
+	backend := NewBackend("10.91.0.1:1086", BackendCheckConfig{})
+	bandit.Observe("old.example.com:443", backend, time.Millisecond, nil)
+	middle := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	bandit.Observe("new.example.com:443", backend, time.Millisecond, nil)
+
+	bandit.Expire(middle)
+	snapshot := bandit.Snapshot()
+	assert.NotContains(t, snapshot, "old.example.com")
+	assert.Contains(t, snapshot, "new.example.com")
+
+	targets := NewCheckTargets(AdaptiveCheckConfig{Enable: true})
+	targets.Record("old.example.com:443")
+	middle = time.Now()
+	time.Sleep(10 * time.Millisecond)
+	targets.Record("new.example.com:443")
+
+	targets.Expire(middle)
+	top := targets.Top()
+	if assert.Len(t, top, 1) {
+		assert.Equal(t, "new.example.com:443", top[0].Target)
+	}
+
+	log.Error("an old line")
+	recentLogs.Expire(time.Now().Add(time.Second))
+	assert.Empty(t, recentLogs.Lines())
+}
+
+func TestServer_PrivacyLearning(t *testing.T) {
+	level := log.GetLevel()
+	t.Cleanup(func() {
+		SetPrivacy(PrivacyConfig{})
+		log.SetLevel(level)
+	})
+
+	config := ServerConfig{}
+	config.Privacy = PrivacyConfig{Enable: true}
+	config.Bandit = BanditConfig{Enable: true, Key: BanditKeyDomain}
+	config.AdaptiveCheck = AdaptiveCheckConfig{Enable: true}
+
+	pool := &Pool{backends: make(map[string]*Backend)}
+	server, err := NewServer(pool, config)
+	assert.NoError(t, err)
+
+	// the destinations are learned by their eTLD+1, but never probed by the checks
+	assert.Equal(t, BanditKeyETLD1, pool.Bandit().config.Key)
+	assert.Nil(t, pool.CheckTargets())
+	assert.False(t, server.Config.AdaptiveCheck.Enable)
+}
@@ -0,0 +1,274 @@
+/**
+ * File: profile.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Saturday, October 24th 2026, 9:36:21 am
+ * Last Modified: Saturday, October 24th 2026, 9:36:21 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Profile is a named set of the routing options, like "office" or "travel"
+type Profile struct {
+	Name string `yaml:"name" json:"name"`
+	// Rules replace the top level rules if any
+	Rules []RoutingRule `yaml:"rules" json:"rules,omitempty"`
+	// Selector is the default backend group of the destinations not matched
+	// by the rules, like "group=office", all the backends if empty
+	Selector string `yaml:"selector" json:"selector,omitempty"`
+	// Socks5Mode is the mode of the new socks5 connections, the socks5.mode if empty
+	Socks5Mode string `yaml:"socks5_mode" json:"socks5_mode,omitempty"`
+	// DNS are the servers to resolve the destinations dialed by socks5lb itself, like the direct
+	// and the wireguard backends without the dns in the tunnel, the system resolver if empty
+	DNS []string `yaml:"dns" json:"dns,omitempty"`
+}
+
+// ProfileStatus is the active profile and the available ones
+type ProfileStatus struct {
+	Active     string    `json:"active"`
+	Profiles   []string  `json:"profiles"`
+	SwitchedAt time.Time `json:"switched_at,omitempty"`
+}
+
+// profile is the parsed profile
+type profile struct {
+	Profile
+
+	rules    *Rules
+	selector Selector
+	resolver *net.Resolver
+}
+
+var (
+	resolverLock sync.RWMutex
+	resolver     = net.DefaultResolver
+)
+
+// Resolver returns the resolver of the destinations by the active profile
+func Resolver() *net.Resolver {
+	resolverLock.RLock()
+	defer resolverLock.RUnlock()
+
+	return resolver
+}
+
+// SetResolver to resolve the destinations by the resolver, the system one if nil
+func SetResolver(r *net.Resolver) {
+	resolverLock.Lock()
+	defer resolverLock.Unlock()
+
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	resolver = r
+}
+
+// newResolver returns the resolver querying the dns servers in order, the port is 53 if omitted
+func newResolver(servers []string) (*net.Resolver, error) {
+	if len(servers) == 0 {
+		return net.DefaultResolver, nil
+	}
+
+	addrs := make([]string, 0, len(servers))
+	for _, server := range servers {
+		addr := server
+		if net.ParseIP(server) != nil {
+			addr = net.JoinHostPort(server, "53")
+		}
+
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("invalid dns server %s", server)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (conn net.Conn, err error) {
+			var dialer net.Dialer
+			for _, addr := range addrs {
+				if conn, err = dialer.DialContext(ctx, network, addr); err == nil {
+					return
+				}
+			}
+			return
+		},
+	}, nil
+}
+
+// profileState is the parsed profiles and the active one of the server
+type profileState struct {
+	lock       sync.RWMutex
+	profiles   []*profile
+	active     *profile
+	switchedAt time.Time
+	signals    chan os.Signal
+}
+
+// newProfiles returns the parsed profiles, the top level rules are used by the profiles without any rule
+func newProfiles(config ServerConfig) (profiles []*profile, err error) {
+	names := make(map[string]bool, len(config.Profiles))
+	for _, p := range config.Profiles {
+		if p.Name == "" {
+			return nil, fmt.Errorf("the name of the profile is empty")
+		}
+
+		if names[p.Name] {
+			return nil, fmt.Errorf("profile %s is duplicated", p.Name)
+		}
+		names[p.Name] = true
+
+		switch p.Socks5Mode {
+		case "", Socks5ModePassthrough, Socks5ModeRelay:
+		default:
+			return nil, fmt.Errorf("unknown socks5 mode %s of profile %s", p.Socks5Mode, p.Name)
+		}
+
+		parsed := &profile{Profile: p}
+		if parsed.selector, err = ParseSelector(p.Selector); err != nil {
+			return nil, fmt.Errorf("invalid selector of profile %s, %v", p.Name, err)
+		}
+
+		if parsed.resolver, err = newResolver(p.DNS); err != nil {
+			return nil, fmt.Errorf("%v of profile %s", err, p.Name)
+		}
+
+		rules := p.Rules
+		if len(rules) == 0 {
+			rules = config.Rules
+		}
+
+		if len(rules) > 0 {
+			if parsed.rules, err = NewRules(rules); err != nil {
+				return nil, fmt.Errorf("invalid rules of profile %s, %v", p.Name, err)
+			}
+		}
+
+		profiles = append(profiles, parsed)
+	}
+
+	return
+}
+
+// SwitchProfile to apply the routing options of the profile, the established
+// sessions keep their backends, only the new connections are affected
+func (s *Server) SwitchProfile(name string) error {
+	s.profiles.lock.Lock()
+	defer s.profiles.lock.Unlock()
+
+	var found *profile
+	for _, p := range s.profiles.profiles {
+		if p.Name == name {
+			found = p
+		}
+	}
+
+	if found == nil {
+		return fmt.Errorf("profile %s is not found", name)
+	}
+
+	// the rules of each profile are kept with their statistics
+	s.Pool.SetRules(found.rules)
+	s.Pool.SetDefaultSelector(found.selector)
+	SetResolver(found.resolver)
+	s.profiles.active, s.profiles.switchedAt = found, time.Now()
+
+	RecordEvent("profile.switched", "switch to profile %s", name)
+	return nil
+}
+
+// NextProfile to switch to the profile after the active one, in the configured order
+func (s *Server) NextProfile() error {
+	s.profiles.lock.RLock()
+	profiles, active := s.profiles.profiles, s.profiles.active
+	s.profiles.lock.RUnlock()
+
+	if len(profiles) == 0 {
+		return fmt.Errorf("no profile is configured")
+	}
+
+	next := profiles[0]
+	for i, p := range profiles {
+		if p == active {
+			next = profiles[(i+1)%len(profiles)]
+		}
+	}
+
+	return s.SwitchProfile(next.Name)
+}
+
+// ProfileStatus returns the active profile, empty if no profile is configured
+func (s *Server) ProfileStatus() ProfileStatus {
+	s.profiles.lock.RLock()
+	defer s.profiles.lock.RUnlock()
+
+	status := ProfileStatus{Profiles: []string{}, SwitchedAt: s.profiles.switchedAt}
+	for _, p := range s.profiles.profiles {
+		status.Profiles = append(status.Profiles, p.Name)
+	}
+
+	if s.profiles.active != nil {
+		status.Active = s.profiles.active.Name
+	}
+
+	return status
+}
+
+// socks5Mode returns the mode of the new socks5 connections by the active profile
+func (s *Server) socks5Mode() string {
+	s.profiles.lock.RLock()
+	defer s.profiles.lock.RUnlock()
+
+	if s.profiles.active != nil && s.profiles.active.Socks5Mode != "" {
+		return s.profiles.active.Socks5Mode
+	}
+
+	return s.Config.Sock5.Mode
+}
+
+// watchProfileSignal to switch to the next profile on the signal
+func (s *Server) watchProfileSignal() {
+	if len(profileSignals) == 0 {
+		return
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, profileSignals...)
+
+	s.profiles.lock.Lock()
+	s.profiles.signals = signals
+	s.profiles.lock.Unlock()
+
+	for range signals {
+		if err := s.NextProfile(); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// stopProfileSignal to stop watching the signal
+func (s *Server) stopProfileSignal() {
+	s.profiles.lock.Lock()
+	defer s.profiles.lock.Unlock()
+
+	if s.profiles.signals != nil {
+		signal.Stop(s.profiles.signals)
+		close(s.profiles.signals)
+		s.profiles.signals = nil
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+/**
+ * File: profile_other.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Saturday, October 24th 2026, 10:02:47 am
+ * Last Modified: Saturday, October 24th 2026, 10:02:47 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import "os"
+
+// profileSignals is empty, the profiles are switched by the api only
+var profileSignals []os.Signal
@@ -0,0 +1,211 @@
+package socks5lb
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/txthinking/socks5"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestServer_SwitchProfile(t *testing.T) {
+	// the echo destination of the long session
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer echo.Close()
+
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					_, _ = conn.Write(append(scanner.Bytes(), '\n'))
+				}
+			}()
+		}
+	}()
+
+	pool := &Pool{backends: make(map[string]*Backend)}
+	office := NewBackend(NewTestSocks5Server(t), BackendCheckConfig{InitialAlive: true, Timeout: 5})
+	office.Labels = map[string]string{"group": "office"}
+	home := NewBackend(NewTestSocks5Server(t), BackendCheckConfig{InitialAlive: true, Timeout: 5})
+	home.Labels = map[string]string{"group": "home"}
+	assert.NoError(t, pool.Add(office))
+	assert.NoError(t, pool.Add(home))
+
+	config := ServerConfig{
+		Rules: []RoutingRule{{Name: "intranet", CIDRs: []string{"10.0.0.0/8"}, Selector: "group=office"}},
+		Profiles: []Profile{
+			{Name: "office", Selector: "group=office", Socks5Mode: Socks5ModeRelay},
+			{Name: "home", Selector: "group=home", Rules: []RoutingRule{{Name: "all-home", Selector: "group=home"}}},
+		},
+	}
+	server, err := NewServer(pool, config)
+	assert.NoError(t, err)
+	assert.Equal(t, "office", server.ProfileStatus().Active)
+	assert.Equal(t, Socks5ModeRelay, server.socks5Mode())
+	assert.Equal(t, office, pool.NextFor("example.com:443"))
+	assert.Equal(t, office, pool.Next())
+	assert.Equal(t, "intranet", pool.Rules().Stats()[0].Name)
+
+	addr := FreeAddr(t)
+	go server.ListenSocks5(addr)
+	defer server.Stop()
+
+	client, err := socks5.NewClient(addr, "", "", 5, 5)
+	assert.NoError(t, err)
+
+	var conn net.Conn
+	assert.Eventually(t, func() bool {
+		conn, err = client.Dial("tcp", echo.Addr().String())
+		return err == nil
+	}, 3*time.Second, 50*time.Millisecond)
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	_, err = conn.Write([]byte("before\n"))
+	assert.NoError(t, err)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "before\n", line)
+
+	// switch by the api
+	router, err := server.newRouter()
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/profile/home", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"active":"home"`)
+
+	assert.Equal(t, home, pool.NextFor("10.1.2.3:22"))
+	assert.Equal(t, home, pool.Next())
+	assert.Equal(t, "all-home", pool.Rules().Stats()[0].Name)
+	assert.Equal(t, "", server.socks5Mode())
+
+	// the established session is kept
+	_, err = conn.Write([]byte("after\n"))
+	assert.NoError(t, err)
+	line, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "after\n", line)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "/api/profile/travel", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	assert.NoError(t, server.NextProfile())
+	assert.Equal(t, "office", server.ProfileStatus().Active)
+}
+
+func TestServer_ProfileConfig(t *testing.T) {
+	for _, profiles := range [][]Profile{
+		{{Name: ""}},
+		{{Name: "a"}, {Name: "a"}},
+		{{Name: "a", Selector: "group in (x"}},
+		{{Name: "a", Socks5Mode: "unknown"}},
+	} {
+		_, err := NewServer(&Pool{backends: make(map[string]*Backend)}, ServerConfig{Profiles: profiles})
+		assert.Error(t, err)
+	}
+
+	_, err := NewServer(&Pool{backends: make(map[string]*Backend)}, ServerConfig{
+		Profiles: []Profile{{Name: "a"}},
+		Profile:  "b",
+	})
+	assert.Error(t, err)
+}
+
+// NewTestDNSServer answers the A queries of the name by 127.0.0.1, and refuses the others
+func NewTestDNSServer(t *testing.T, name string) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var parser dnsmessage.Parser
+			header, err := parser.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			question, err := parser.Question()
+			if err != nil {
+				continue
+			}
+
+			matched := question.Name.String() == name+"." && question.Type == dnsmessage.TypeA
+			header.Response, header.RCode = true, dnsmessage.RCodeRefused
+			if matched {
+				header.RCode = dnsmessage.RCodeSuccess
+			}
+
+			builder := dnsmessage.NewBuilder(nil, header)
+			_ = builder.StartQuestions()
+			_ = builder.Question(question)
+			if matched {
+				_ = builder.StartAnswers()
+				_ = builder.AResource(dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60},
+					dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}})
+			}
+
+			if msg, err := builder.Finish(); err == nil {
+				_, _ = conn.WriteTo(msg, from)
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestServer_ProfileDNS(t *testing.T) {
+	t.Cleanup(func() { SetResolver(nil) })
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer target.Close()
+	_, port, _ := net.SplitHostPort(target.Listener.Addr().String())
+
+	pool := &Pool{backends: make(map[string]*Backend)}
+	direct := NewBackend("direct", BackendCheckConfig{InitialAlive: true, Timeout: 2})
+	direct.Protocol = ProtocolDirect
+	assert.NoError(t, pool.Add(direct))
+
+	server, err := NewServer(pool, ServerConfig{Profiles: []Profile{
+		{Name: "office", DNS: []string{NewTestDNSServer(t, "intranet.socks5lb.test")}},
+		{Name: "home"},
+	}})
+	assert.NoError(t, err)
+
+	// the intranet name is only resolved by the dns of the office profile
+	conn, err := direct.DialFor("", "tcp", net.JoinHostPort("intranet.socks5lb.test", port), 2)
+	if assert.NoError(t, err) {
+		_ = conn.Close()
+	}
+
+	assert.NoError(t, server.SwitchProfile("home"))
+	_, err = direct.DialFor("", "tcp", net.JoinHostPort("intranet.socks5lb.test", port), 2)
+	assert.Error(t, err)
+
+	_, err = NewServer(pool, ServerConfig{Profiles: []Profile{{Name: "office", DNS: []string{"dns.example.com"}}}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+/**
+ * File: profile_unix.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Saturday, October 24th 2026, 10:02:47 am
+ * Last Modified: Saturday, October 24th 2026, 10:02:47 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"os"
+	"syscall"
+)
+
+// profileSignals switch to the next profile, like `kill -USR1 $(pidof socks5lb)`
+var profileSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,282 @@
+/**
+ * File: quic.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Saturday, October 17th 2026, 4:02:45 pm
+ * Last Modified: Saturday, October 17th 2026, 4:02:45 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// quicALPN is the application protocol between the socks5lb instances
+const quicALPN = "socks5lb"
+
+// quicKeepAlivePeriod keeps the idle tunnel and the NAT mapping alive
+const quicKeepAlivePeriod = 15 * time.Second
+
+// errQUICClientAuth is the quic listener reachable from the other hosts without the client certificates,
+// which relays anyone completing the tls handshake to the backends
+var errQUICClientAuth = errors.New("the quic listener on a non-loopback address requires the client_ca_file")
+
+type BackendQUICConfig struct {
+	ServerName         string `yaml:"server_name" json:"server_name"`
+	CAFile             string `yaml:"ca_file" json:"ca_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" json:"insecure_skip_verify"`
+	// CertFile and KeyFile are the client certificate, verified by the client_ca_file of the listener
+	CertFile string `yaml:"cert_file" json:"cert_file"`
+	KeyFile  string `yaml:"key_file" json:"key_file"`
+	// Allow0RTT to send the first socks5 request before the handshake is completed
+	// when resuming, the receiver holds it until the handshake is confirmed
+	Allow0RTT bool `yaml:"allow_0rtt" json:"allow_0rtt"`
+}
+
+// quicStreamConn wraps the quic stream as a net.Conn, every stream is a socks5 session
+type quicStreamConn struct {
+	*quic.Stream
+	conn *quic.Conn
+}
+
+func (c *quicStreamConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *quicStreamConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close to close both directions of the stream
+func (c *quicStreamConn) Close() error {
+	c.Stream.CancelRead(0)
+	return c.Stream.Close()
+}
+
+// quicClient keeps a shared quic connection to the remote socks5lb instance
+type quicClient struct {
+	lock    sync.Mutex
+	conn    *quic.Conn
+	tlsConf *tls.Config
+}
+
+var quicClientsLock sync.Mutex
+
+// loadCertPool returns the certificate pool of the PEM file
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", file)
+	}
+
+	return pool, nil
+}
+
+// loopbackAddr returns true if the address is only reachable from this host
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// tlsConfig returns the tls configuration for the quic backend
+func (c BackendQUICConfig) tlsConfig(addr string) (conf *tls.Config, err error) {
+	conf = &tls.Config{
+		NextProtos:         []string{quicALPN},
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		ClientSessionCache: tls.NewLRUClientSessionCache(16),
+	}
+
+	if conf.ServerName == "" {
+		if conf.ServerName, _, err = net.SplitHostPort(addr); err != nil {
+			return
+		}
+	}
+
+	if c.CAFile != "" {
+		if conf.RootCAs, err = loadCertPool(c.CAFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	return
+}
+
+// connection returns the alive quic connection, or dial a new one
+func (c *quicClient) connection(ctx context.Context, addr string, config BackendQUICConfig) (conn *quic.Conn, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.conn != nil {
+		select {
+		case <-c.conn.Context().Done():
+			log.Debugf("[quic] connection to %s is closed, redial it", addr)
+			c.conn = nil
+		default:
+			return c.conn, nil
+		}
+	}
+
+	if c.tlsConf == nil {
+		if c.tlsConf, err = config.tlsConfig(addr); err != nil {
+			return
+		}
+	}
+
+	// the session tickets are kept by the tls config, so the connection can be resumed
+	if c.conn, err = quic.DialAddrEarly(ctx, addr, c.tlsConf, &quic.Config{
+		KeepAlivePeriod: quicKeepAlivePeriod,
+	}); err != nil {
+		return
+	}
+
+	log.Debugf("[quic] connected to %s, resumed %v", addr, c.conn.ConnectionState().TLS.DidResume)
+	return c.conn, nil
+}
+
+// dialQUIC to open a new stream on the quic connection of the backend
+func (b *Backend) dialQUIC(timeout int) (net.Conn, error) {
+	quicClientsLock.Lock()
+	if b.quic == nil {
+		b.quic = &quicClient{}
+	}
+	client := b.quic
+	quicClientsLock.Unlock()
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+
+	conn, err := client.connection(ctx, b.Addr, b.QUIC)
+	if err != nil {
+		return nil, err
+	}
+
+	if !b.QUIC.Allow0RTT {
+		select {
+		case <-conn.HandshakeComplete():
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &quicStreamConn{Stream: stream, conn: conn}, nil
+}
+
+// handleQUICConn to accept the streams of the quic connection as socks5 sessions
+func (s *Server) handleQUICConn(conn *quic.Conn) {
+	// the early data may be replayed, hold the streams until the handshake is confirmed
+	select {
+	case <-conn.HandshakeComplete():
+	case <-conn.Context().Done():
+		return
+	}
+
+	log.Debugf("[quic] accepted connection from %s", conn.RemoteAddr())
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			log.Debugf("[quic] connection from %s is closed, %v", conn.RemoteAddr(), err)
+			return
+		}
+
+		go s.handleSocks5Conn(&quicStreamConn{Stream: stream, conn: conn})
+	}
+}
+
+// ListenQUIC to accept the proxied streams from the other socks5lb instances over QUIC,
+// the other instances are authenticated by their client certificates
+func (s *Server) ListenQUIC(addr string) (err error) {
+	config := s.Config.QUIC
+	if config.CertFile == "" || config.KeyFile == "" {
+		s.listening()
+		return errors.New("the certificate and the key are required by the quic listener")
+	}
+
+	cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		s.listening()
+		return
+	}
+
+	tlsConf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{quicALPN},
+	}
+
+	if config.ClientCAFile != "" {
+		if tlsConf.ClientCAs, err = loadCertPool(config.ClientCAFile); err != nil {
+			s.listening()
+			return
+		}
+		tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+	} else if !loopbackAddr(addr) {
+		s.listening()
+		return errQUICClientAuth
+	}
+
+	s.quicListener, err = quic.ListenAddrEarly(addr, tlsConf, &quic.Config{
+		Allow0RTT:       true,
+		KeepAlivePeriod: quicKeepAlivePeriod,
+	})
+	s.listening()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	defer s.quicListener.Close()
+
+	for {
+		conn, err := s.quicListener.Accept(context.Background())
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+
+		go s.handleQUICConn(conn)
+	}
+}
@@ -0,0 +1,137 @@
+package socks5lb
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// NewTestCertificate writes a self-signed certificate and returns the file paths
+func NewTestCertificate(t *testing.T) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+	return
+}
+
+func TestServer_QUICTunnel(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+
+	// the central instance forwards the quic streams to its socks5 backend
+	pool := &Pool{backends: make(map[string]*Backend)}
+	assert.NoError(t, pool.Add(NewBackend(NewTestSocks5Server(t), BackendCheckConfig{InitialAlive: true})))
+
+	config := ServerConfig{}
+	config.QUIC.CertFile, config.QUIC.KeyFile = NewTestCertificate(t)
+	central, err := NewServer(pool, config)
+	assert.NoError(t, err)
+
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.LocalAddr().String()
+	_ = l.Close()
+	go central.ListenQUIC(addr)
+
+	// the edge instance uses the central one as a quic backend, the check target is a domain
+	backend := NewBackend(addr, BackendCheckConfig{
+		CheckURL: strings.Replace(target.URL, "127.0.0.1", "localhost", 1),
+		Timeout:  5,
+	})
+	backend.Protocol = ProtocolQUIC
+	backend.QUIC.InsecureSkipVerify = true
+
+	assert.Eventually(t, func() bool {
+		return backend.Check() == nil
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// the streams share the same quic connection
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, backend.Check())
+		assert.True(t, backend.Alive())
+	}
+}
+
+func TestServer_QUICClientAuth(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+
+	pool := &Pool{backends: make(map[string]*Backend)}
+	assert.NoError(t, pool.Add(NewBackend(NewTestSocks5Server(t), BackendCheckConfig{InitialAlive: true})))
+
+	// the self-signed client certificate is the ca of itself
+	clientCert, clientKey := NewTestCertificate(t)
+	config := ServerConfig{}
+	config.QUIC.CertFile, config.QUIC.KeyFile = NewTestCertificate(t)
+	config.QUIC.ClientCAFile = clientCert
+	central, err := NewServer(pool, config)
+	assert.NoError(t, err)
+
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.LocalAddr().String()
+	_ = l.Close()
+	go central.ListenQUIC(addr)
+
+	anonymous := NewBackend(addr, BackendCheckConfig{CheckURL: target.URL, Timeout: 2})
+	anonymous.Protocol = ProtocolQUIC
+	anonymous.QUIC.InsecureSkipVerify = true
+
+	backend := NewBackend(addr, BackendCheckConfig{CheckURL: target.URL, Timeout: 2})
+	backend.Protocol = ProtocolQUIC
+	backend.QUIC.InsecureSkipVerify = true
+	backend.QUIC.CertFile, backend.QUIC.KeyFile = clientCert, clientKey
+
+	assert.Eventually(t, func() bool {
+		return backend.Check() == nil
+	}, 3*time.Second, 50*time.Millisecond)
+	assert.Error(t, anonymous.Check())
+
+	// the listener reachable from the other hosts requires the client certificates
+	server, err := NewServer(pool, ServerConfig{})
+	assert.NoError(t, err)
+	server.Config.QUIC.CertFile, server.Config.QUIC.KeyFile = config.QUIC.CertFile, config.QUIC.KeyFile
+	assert.ErrorIs(t, server.ListenQUIC(":0"), errQUICClientAuth)
+
+	configure := &Configure{}
+	configure.ServerConfig.QUIC.Addr = ":4433"
+	assert.ErrorIs(t, configure.Validate(), errQUICClientAuth)
+	configure.ServerConfig.QUIC.Addr = "127.0.0.1:4433"
+	assert.NoError(t, configure.Validate())
+}
@@ -0,0 +1,334 @@
+/**
+ * File: remoteconfig.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Monday, October 19th 2026, 2:15:08 pm
+ * Last Modified: Monday, October 19th 2026, 2:15:08 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultRemoteConfigInterval = 60
+	defaultRemoteConfigTimeout  = 10
+	// maxRemoteConfigSize is the limit of the remote configuration
+	maxRemoteConfigSize = 16 << 20
+)
+
+type RemoteConfig struct {
+	// URL is the http(s) url of the full configuration, disabled if empty
+	URL string `yaml:"url"`
+	// Interval to poll the configuration in seconds, 60 by default
+	Interval uint `yaml:"interval"`
+	Timeout  uint `yaml:"timeout"`
+	// AuthHeader is the value of the Authorization header, like "Bearer xxx"
+	AuthHeader string `yaml:"auth_header"`
+	// StatusURL to post the applied version and the errors, optional
+	StatusURL string `yaml:"status_url"`
+	// SignatureURL is the url of the detached signature, the url with ".minisig" by default,
+	// only required if the public keys of the signature are configured
+	SignatureURL string `yaml:"signature_url"`
+}
+
+// RemoteConfigStatus is the state of the remote configuration
+type RemoteConfigStatus struct {
+	Instance  string    `json:"instance"`
+	URL       string    `json:"url"`
+	Version   string    `json:"version,omitempty"`
+	AppliedAt time.Time `json:"applied_at,omitempty"`
+	CheckedAt time.Time `json:"checked_at,omitempty"`
+	Added     int       `json:"added"`
+	Removed   int       `json:"removed"`
+	Updated   int       `json:"updated"`
+	// RestartRequired is true if the server section is changed, it is not applied until restarted
+	RestartRequired bool   `json:"restart_required"`
+	Error           string `json:"error,omitempty"`
+}
+
+// remoteConfigState keeps the etag and the status of the remote configuration
+type remoteConfigState struct {
+	lock   sync.Mutex
+	etag   string
+	status RemoteConfigStatus
+}
+
+// backendSpec returns the configuration of the backend to compare
+func backendSpec(backend *Backend) string {
+	data, err := yaml.Marshal(backend)
+	if err != nil {
+		return ""
+	}
+
+	return string(data)
+}
+
+// ApplyBackends to reconcile the pool with the backends, the changed backends are replaced,
+// and the backends with a helper process are refused
+func (s *Server) ApplyBackends(backends []Backend) (added, removed, updated int) {
+	wanted := make(map[string]bool, len(backends))
+	for i := range backends {
+		backend := &backends[i]
+		wanted[backend.Addr] = true
+
+		if err := backend.localOnlyOption(); err != nil {
+			log.Errorf("backend %s is refused, %v", backend.Addr, err)
+			continue
+		}
+
+		if current := s.Pool.Get(backend.Addr); current != nil {
+			if backendSpec(current) == backendSpec(backend) {
+				continue
+			}
+
+			// the leased backend is kept until the lease is released
+			if err := s.Pool.Remove(backend.Addr); err != nil {
+				log.Errorf("backend %s is not updated, %v", backend.Addr, err)
+				continue
+			}
+			updated++
+		} else {
+			added++
+		}
+
+		backend.alive = backend.CheckConfig.InitialAlive
+		if err := s.Pool.Add(backend); err != nil {
+			log.Error(err)
+		}
+	}
+
+	for _, backend := range s.Pool.Select(nil) {
+		// the discovered backends are managed by the mdns discovery
+		if !wanted[backend.Addr] && backend.Labels["source"] != MDNSSourceLabel {
+			if err := s.Pool.Remove(backend.Addr); err != nil {
+				log.Errorf("backend %s is not removed, %v", backend.Addr, err)
+				continue
+			}
+			removed++
+		}
+	}
+
+	return
+}
+
+// serverConfigChanged returns true if the server section is changed, except the remote configuration
+func (s *Server) serverConfigChanged(config ServerConfig) bool {
+	current := *s.Config
+	current.RemoteConfig, config.RemoteConfig = RemoteConfig{}, RemoteConfig{}
+
+	a, _ := yaml.Marshal(current)
+	b, _ := yaml.Marshal(config)
+	return !bytes.Equal(a, b)
+}
+
+// fetchRemoteConfig returns the remote configuration, nil if it is not modified
+func (s *Server) fetchRemoteConfig() (data []byte, etag string, err error) {
+	config := s.Config.RemoteConfig
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = defaultRemoteConfigTimeout
+	}
+
+	req, err := http.NewRequest(http.MethodGet, config.URL, nil)
+	if err != nil {
+		return
+	}
+
+	if config.AuthHeader != "" {
+		req.Header.Set("Authorization", config.AuthHeader)
+	}
+
+	s.remoteConfig.lock.Lock()
+	if s.remoteConfig.etag != "" {
+		req.Header.Set("If-None-Match", s.remoteConfig.etag)
+	}
+	s.remoteConfig.lock.Unlock()
+
+	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, "", nil
+	case http.StatusOK:
+	default:
+		return nil, "", fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	if data, err = io.ReadAll(io.LimitReader(resp.Body, maxRemoteConfigSize+1)); err != nil {
+		return
+	}
+
+	if len(data) > maxRemoteConfigSize {
+		return nil, "", errors.New("the remote configuration is too large")
+	}
+
+	return data, resp.Header.Get("ETag"), nil
+}
+
+// PullRemoteConfig to fetch, validate and apply the remote configuration
+func (s *Server) PullRemoteConfig() (err error) {
+	status, report, err := s.pullRemoteConfig()
+
+	// the status callback may be slow, so it is not posted with the lock held
+	if report {
+		s.reportRemoteConfig(status)
+	}
+
+	return
+}
+
+// pullRemoteConfig returns the status to report if the remote configuration is changed
+func (s *Server) pullRemoteConfig() (_ RemoteConfigStatus, report bool, err error) {
+	data, etag, err := s.fetchRemoteConfig()
+
+	s.remoteConfig.lock.Lock()
+	defer s.remoteConfig.lock.Unlock()
+
+	status := &s.remoteConfig.status
+	status.URL, status.CheckedAt = s.Config.RemoteConfig.URL, time.Now()
+	if status.Instance == "" {
+		status.Instance, _ = os.Hostname()
+	}
+
+	// keep the last status if the configuration is not modified
+	if err == nil && data == nil {
+		log.Debugf("remote configuration %s is not modified", status.URL)
+		return
+	}
+
+	if err == nil {
+		remote := s.Config.RemoteConfig
+		err = s.verifyRemotePayload("remote configuration", remote.URL, remote.SignatureURL, remote.AuthHeader, data)
+	}
+
+	var config *Configure
+	if err == nil {
+		if config, err = ParseConfigure(data); err == nil && len(config.Backends) == 0 {
+			err = errors.New("refuse to apply the remote configuration without backends")
+		}
+	}
+
+	if err == nil {
+		for _, backend := range config.Backends {
+			if err = backend.localOnlyOption(); err != nil {
+				err = fmt.Errorf("refuse to apply the remote configuration, backend %s: %w", backend.Addr, err)
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		status.Error = err.Error()
+		return *status, true, err
+	}
+
+	version := etag
+	if version == "" {
+		sum := sha256.Sum256(data)
+		version = "sha256:" + hex.EncodeToString(sum[:8])
+	}
+
+	s.remoteConfig.etag = etag
+	if version == status.Version && status.Error == "" {
+		return
+	}
+
+	status.Added, status.Removed, status.Updated = s.ApplyBackends(config.Backends)
+	status.RestartRequired = s.serverConfigChanged(config.ServerConfig)
+	status.Version, status.AppliedAt, status.Error = version, time.Now(), ""
+
+	RecordEvent("config.applied", "remote configuration %s is applied, %d added, %d removed, %d updated",
+		version, status.Added, status.Removed, status.Updated)
+	if status.RestartRequired {
+		log.Warnf("the server section of the remote configuration %s is changed, restart to apply it", version)
+	}
+
+	return *status, true, nil
+}
+
+// reportRemoteConfig to post the status to the status callback
+func (s *Server) reportRemoteConfig(status RemoteConfigStatus) {
+	config := s.Config.RemoteConfig
+	if config.StatusURL == "" {
+		return
+	}
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, config.StatusURL, bytes.NewReader(data))
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if config.AuthHeader != "" {
+		req.Header.Set("Authorization", config.AuthHeader)
+	}
+
+	client := &http.Client{Timeout: defaultRemoteConfigTimeout * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Errorf("report the remote configuration status to %s failed, %v", config.StatusURL, err)
+		return
+	}
+	_ = resp.Body.Close()
+}
+
+// RemoteConfigStatus returns the state of the remote configuration
+func (s *Server) RemoteConfigStatus() RemoteConfigStatus {
+	s.remoteConfig.lock.Lock()
+	defer s.remoteConfig.lock.Unlock()
+
+	return s.remoteConfig.status
+}
+
+// watchRemoteConfig to poll the remote configuration by the interval until stopped
+func (s *Server) watchRemoteConfig(stop <-chan struct{}) error {
+	interval := s.Config.RemoteConfig.Interval
+	if interval == 0 {
+		interval = defaultRemoteConfigInterval
+	}
+
+	timer := time.NewTicker(time.Duration(interval) * time.Second)
+	defer timer.Stop()
+
+	log.Infof("poll the remote configuration from %s, every %ds", s.Config.RemoteConfig.URL, interval)
+	for {
+		if err := s.PullRemoteConfig(); err != nil {
+			log.Errorf("pull the remote configuration failed, %v", err)
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-timer.C:
+		}
+	}
+}
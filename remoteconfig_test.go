@@ -0,0 +1,151 @@
+package socks5lb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_PullRemoteConfig(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		config   = "backends:\n  - addr: 10.60.0.1:1086\n  - addr: 10.60.0.2:1086\n"
+		etag     = `"v1"`
+		requests int
+		reports  []RemoteConfigStatus
+		server   *Server
+		err      error
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			// the status is posted without holding the lock
+			done := make(chan struct{})
+			go func() {
+				_ = server.RemoteConfigStatus()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Error("the status is reported with the lock held")
+			}
+
+			var status RemoteConfigStatus
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&status))
+			reports = append(reports, status)
+			return
+		}
+
+		requests++
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write([]byte(config))
+	}))
+	defer ts.Close()
+
+	pool := &Pool{backends: make(map[string]*Backend)}
+	assert.NoError(t, pool.Add(NewBackend("10.60.0.3:1086", BackendCheckConfig{})))
+
+	serverConfig := ServerConfig{}
+	serverConfig.RemoteConfig = RemoteConfig{URL: ts.URL, AuthHeader: "Bearer token", StatusURL: ts.URL}
+	server, err = NewServer(pool, serverConfig)
+	assert.NoError(t, err)
+
+	assert.NoError(t, server.PullRemoteConfig())
+	status := server.RemoteConfigStatus()
+	assert.Equal(t, `"v1"`, status.Version)
+	assert.Equal(t, 2, status.Added)
+	assert.Equal(t, 1, status.Removed)
+	assert.Nil(t, pool.Get("10.60.0.3:1086"))
+	assert.NotNil(t, pool.Get("10.60.0.1:1086"))
+
+	// not modified
+	assert.NoError(t, server.PullRemoteConfig())
+	assert.Equal(t, 2, requests)
+
+	// the invalid configuration is not applied
+	lock.Lock()
+	config, etag = "backends:\n  - addr: 10.60.0.1:1086\n  - addr: 10.60.0.1:1086\n", `"v2"`
+	lock.Unlock()
+	assert.Error(t, server.PullRemoteConfig())
+	assert.Equal(t, `"v1"`, server.RemoteConfigStatus().Version)
+	assert.Len(t, pool.All(), 2)
+
+	// the changed backend is replaced
+	lock.Lock()
+	config, etag = "backends:\n  - addr: 10.60.0.1:1086\n    weight: 3\n", `"v3"`
+	lock.Unlock()
+	assert.NoError(t, server.PullRemoteConfig())
+	status = server.RemoteConfigStatus()
+	assert.Equal(t, `"v3"`, status.Version)
+	assert.Equal(t, 1, status.Updated)
+	assert.Equal(t, 1, status.Removed)
+	assert.Equal(t, uint(3), pool.Get("10.60.0.1:1086").Weight)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Len(t, reports, 3)
+	assert.NotEmpty(t, reports[1].Error)
+}
+
+func TestServer_ApplyBackendsLocalOnly(t *testing.T) {
+	server, err := NewServer(&Pool{backends: make(map[string]*Backend)}, ServerConfig{})
+	assert.NoError(t, err)
+
+	backends := []Backend{{Addr: "10.60.0.4:1086"}, {Addr: "127.0.0.1:1081"}, {Addr: "10.60.0.5:3128", Protocol: ProtocolHTTP}}
+	backends[1].Command.Args = []string{"sh", "-c", "id"}
+	backends[2].HTTP.PasswordFile = "/etc/shadow"
+
+	// the kerberos logs in by the keytab or the credential cache of this host, unless the password is given
+	backends = append(backends,
+		Backend{Addr: "10.60.0.7:3128", Protocol: ProtocolHTTP, HTTP: BackendHTTPConfig{Auth: HTTPAuthNegotiate, Keytab: "/etc/krb5.keytab"}},
+		Backend{Addr: "10.60.0.8:3128", Protocol: ProtocolHTTP, HTTP: BackendHTTPConfig{Auth: HTTPAuthNegotiate}},
+		Backend{Addr: "10.60.0.9:3128", Protocol: ProtocolHTTP, UserName: "bob@CORP.EXAMPLE.COM", Password: "s3cret",
+			HTTP: BackendHTTPConfig{Auth: HTTPAuthNegotiate}})
+
+	added, _, _ := server.ApplyBackends(backends)
+	assert.Equal(t, 2, added)
+	assert.Nil(t, server.Pool.Get("127.0.0.1:1081"))
+	assert.Nil(t, server.Pool.Get("10.60.0.5:3128"))
+	assert.Nil(t, server.Pool.Get("10.60.0.7:3128"))
+	assert.Nil(t, server.Pool.Get("10.60.0.8:3128"))
+	assert.NotNil(t, server.Pool.Get("10.60.0.9:3128"))
+
+	// the remote configuration with a helper process is refused as a whole
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("backends:\n  - addr: 127.0.0.1:1082\n    command:\n      args: [sh, -c, id]\n"))
+	}))
+	defer ts.Close()
+
+	server.Config.RemoteConfig.URL = ts.URL
+	assert.ErrorIs(t, server.PullRemoteConfig(), errCommandNotLocal)
+	assert.Nil(t, server.Pool.Get("127.0.0.1:1082"))
+
+	// so is the one with a password file
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("backends:\n  - addr: 10.60.0.6:3128\n    protocol: http\n    http:\n      password_file: /etc/shadow\n"))
+	}))
+	defer files.Close()
+
+	server.Config.RemoteConfig.URL = files.URL
+	assert.ErrorIs(t, server.PullRemoteConfig(), errPasswordFileNotLocal)
+	assert.Nil(t, server.Pool.Get("10.60.0.6:3128"))
+}
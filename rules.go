@@ -0,0 +1,356 @@
+/**
+ * File: rules.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Wednesday, October 21st 2026, 10:04:19 am
+ * Last Modified: Wednesday, October 21st 2026, 10:04:19 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultRuleReportWindow is the window to find out the unused routing rules
+const defaultRuleReportWindow = 24 * time.Hour
+
+// RoutingRule routes the matched destinations to the backends selected by the labels
+type RoutingRule struct {
+	Name string `yaml:"name" json:"name"`
+	// Domains match the domains and their subdomains, like "example.com"
+	Domains []string `yaml:"domains" json:"domains,omitempty"`
+	// CIDRs match the ip destinations, like "10.0.0.0/8"
+	CIDRs []string `yaml:"cidrs" json:"cidrs,omitempty"`
+	// Ports match the destination ports, all the ports if empty
+	Ports []uint16 `yaml:"ports" json:"ports,omitempty"`
+	// Selector is the label selector of the backends, like "country=jp"
+	Selector string `yaml:"selector" json:"selector"`
+}
+
+// RuleStats is the hit statistics of a routing rule
+type RuleStats struct {
+	Name     string    `json:"name"`
+	Selector string    `json:"selector"`
+	Matches  uint64    `json:"matches"`
+	Sessions uint64    `json:"sessions"`
+	Bytes    uint64    `json:"bytes"`
+	LastHit  time.Time `json:"last_hit,omitempty"`
+}
+
+// ShadowedRule is a rule never matched, all its destinations are matched by the earlier rules
+type ShadowedRule struct {
+	Name       string   `json:"name"`
+	ShadowedBy []string `json:"shadowed_by"`
+}
+
+// RuleReport is the report of the routing rules which do not matter
+type RuleReport struct {
+	Since  time.Time     `json:"since"`
+	Window time.Duration `json:"window"`
+	// Unused are the rules not matched in the window
+	Unused   []string       `json:"unused"`
+	Shadowed []ShadowedRule `json:"shadowed"`
+}
+
+// rule is the parsed routing rule with its statistics
+type rule struct {
+	RoutingRule
+
+	domains  []string
+	prefixes []netip.Prefix
+	ports    map[uint16]bool
+	selector Selector
+
+	matches  atomic.Uint64
+	sessions atomic.Uint64
+	bytes    atomic.Uint64
+	lastHit  atomic.Int64
+}
+
+// Rules are the ordered routing rules, the first matched rule wins
+type Rules struct {
+	rules []*rule
+	since time.Time
+}
+
+// NewRules returns the parsed routing rules
+func NewRules(configs []RoutingRule) (*Rules, error) {
+	rules := &Rules{since: time.Now()}
+	names := make(map[string]bool, len(configs))
+
+	for i, config := range configs {
+		if config.Name == "" {
+			config.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+
+		if names[config.Name] {
+			return nil, fmt.Errorf("routing rule %s is duplicated", config.Name)
+		}
+		names[config.Name] = true
+
+		r := &rule{RoutingRule: config, ports: make(map[uint16]bool)}
+		for _, domain := range config.Domains {
+			r.domains = append(r.domains, strings.ToLower(strings.Trim(domain, ".")))
+		}
+
+		for _, cidr := range config.CIDRs {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				if addr, e := netip.ParseAddr(cidr); e == nil {
+					prefix, err = addr.Prefix(addr.BitLen())
+				}
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid cidr %s of routing rule %s", cidr, config.Name)
+			}
+			r.prefixes = append(r.prefixes, prefix.Masked())
+		}
+
+		for _, port := range config.Ports {
+			r.ports[port] = true
+		}
+
+		var err error
+		if r.selector, err = ParseSelector(config.Selector); err != nil {
+			return nil, fmt.Errorf("invalid selector of routing rule %s, %v", config.Name, err)
+		}
+
+		rules.rules = append(rules.rules, r)
+	}
+
+	return rules, nil
+}
+
+// matchAll returns true if the rule matches all the destinations, except the ports
+func (r *rule) matchAll() bool {
+	return len(r.domains) == 0 && len(r.prefixes) == 0
+}
+
+// domainMatches returns true if the host is the domain or its subdomain
+func domainMatches(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// match returns true if the destination host and port are matched by the rule
+func (r *rule) match(host string, port uint16) bool {
+	if len(r.ports) > 0 && !r.ports[port] {
+		return false
+	}
+
+	if r.matchAll() {
+		return true
+	}
+
+	if ip, err := netip.ParseAddr(host); err == nil {
+		for _, prefix := range r.prefixes {
+			if prefix.Contains(ip.Unmap()) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, domain := range r.domains {
+		if domainMatches(host, domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lookup returns the first rule matched by the destination address without counting it
+func (r *Rules) lookup(addr string) *rule {
+	if r == nil {
+		return nil
+	}
+
+	host := destinationKey(addr, false)
+	var port uint16
+	if _, p, err := net.SplitHostPort(addr); err == nil {
+		n, _ := strconv.ParseUint(p, 10, 16)
+		port = uint16(n)
+	}
+
+	for _, rule := range r.rules {
+		if rule.match(host, port) {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// hit to count the destination address routed by the rule, nothing if the rule is nil
+func (r *rule) hit(addr string) {
+	if r == nil {
+		return
+	}
+
+	r.matches.Add(1)
+	r.lastHit.Store(time.Now().UnixNano())
+	log.Tracef("[rules] %s is matched by rule %s", addr, r.Name)
+}
+
+// ruleConn counts the transferred bytes of the session for the rule
+type ruleConn struct {
+	net.Conn
+	rule *rule
+}
+
+func (c *ruleConn) Read(p []byte) (n int, err error) {
+	n, err = c.Conn.Read(p)
+	c.rule.bytes.Add(uint64(n))
+	return
+}
+
+func (c *ruleConn) Write(p []byte) (n int, err error) {
+	n, err = c.Conn.Write(p)
+	c.rule.bytes.Add(uint64(n))
+	return
+}
+
+// track to count the session and its bytes by the rule, nothing if the rule is nil
+func (r *rule) track(conn net.Conn) net.Conn {
+	if r == nil {
+		return conn
+	}
+
+	r.sessions.Add(1)
+	return &ruleConn{Conn: conn, rule: r}
+}
+
+// Stats returns the hit statistics of the rules in order
+func (r *Rules) Stats() (stats []RuleStats) {
+	for _, rule := range r.rules {
+		stat := RuleStats{
+			Name:     rule.Name,
+			Selector: rule.Selector,
+			Matches:  rule.matches.Load(),
+			Sessions: rule.sessions.Load(),
+			Bytes:    rule.bytes.Load(),
+		}
+
+		if lastHit := rule.lastHit.Load(); lastHit > 0 {
+			stat.LastHit = time.Unix(0, lastHit)
+		}
+
+		stats = append(stats, stat)
+	}
+
+	return
+}
+
+// portsCovered returns true if all the ports of the rule are matched by the other
+func (r *rule) portsCovered(by *rule) bool {
+	if len(by.ports) == 0 {
+		return true
+	}
+
+	if len(r.ports) == 0 {
+		return false
+	}
+
+	for port := range r.ports {
+		if !by.ports[port] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// shadowedBy returns the earlier rules which match all the destinations of the
+// rule, nil if some of its destinations are reachable
+func (r *Rules) shadowedBy(i int) []string {
+	var (
+		current = r.rules[i]
+		names   = make(map[string]bool)
+	)
+
+	// covered returns true if an earlier rule matches the destination
+	covered := func(matches func(earlier *rule) bool) bool {
+		for _, earlier := range r.rules[:i] {
+			if current.portsCovered(earlier) && (earlier.matchAll() || matches(earlier)) {
+				names[earlier.Name] = true
+				return true
+			}
+		}
+		return false
+	}
+
+	if current.matchAll() {
+		if !covered(func(*rule) bool { return false }) {
+			return nil
+		}
+	}
+
+	for _, domain := range current.domains {
+		if !covered(func(earlier *rule) bool {
+			for _, d := range earlier.domains {
+				if domainMatches(domain, d) {
+					return true
+				}
+			}
+			return false
+		}) {
+			return nil
+		}
+	}
+
+	for _, prefix := range current.prefixes {
+		if !covered(func(earlier *rule) bool {
+			for _, p := range earlier.prefixes {
+				if p.Bits() <= prefix.Bits() && p.Contains(prefix.Addr()) {
+					return true
+				}
+			}
+			return false
+		}) {
+			return nil
+		}
+	}
+
+	var shadowedBy []string
+	for _, earlier := range r.rules[:i] {
+		if names[earlier.Name] {
+			shadowedBy = append(shadowedBy, earlier.Name)
+		}
+	}
+
+	return shadowedBy
+}
+
+// Report returns the rules not matched in the window, and the rules fully shadowed by the earlier rules
+func (r *Rules) Report(window time.Duration) (report RuleReport) {
+	if window <= 0 {
+		window = defaultRuleReportWindow
+	}
+
+	report = RuleReport{Since: r.since, Window: window, Unused: []string{}, Shadowed: []ShadowedRule{}}
+	from := time.Now().Add(-window).UnixNano()
+
+	for i, rule := range r.rules {
+		if rule.lastHit.Load() < from {
+			report.Unused = append(report.Unused, rule.Name)
+		}
+
+		if shadowedBy := r.shadowedBy(i); len(shadowedBy) > 0 {
+			report.Shadowed = append(report.Shadowed, ShadowedRule{Name: rule.Name, ShadowedBy: shadowedBy})
+		}
+	}
+
+	return
+}
@@ -0,0 +1,143 @@
+package socks5lb
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRules_Match(t *testing.T) {
+	rules, err := NewRules([]RoutingRule{
+		{Name: "intranet", CIDRs: []string{"10.0.0.0/8", "192.168.1.1"}, Selector: "site=office"},
+		{Name: "jp", Domains: []string{"example.jp"}, Ports: []uint16{443}, Selector: "country=jp"},
+		{Domains: []string{"example.com"}, Selector: "country=us"},
+	})
+	assert.NoError(t, err)
+
+	for addr, name := range map[string]string{
+		"10.1.2.3:22":          "intranet",
+		"192.168.1.1:80":       "intranet",
+		"www.example.jp:443":   "jp",
+		"www.example.jp:80":    "",
+		"EXAMPLE.com.:80":      "rule-3",
+		"notexample.com:80":    "",
+		"[::ffff:10.0.0.1]:80": "intranet",
+	} {
+		matched := rules.lookup(addr)
+		if name == "" {
+			assert.Nil(t, matched, addr)
+		} else if assert.NotNil(t, matched, addr) {
+			assert.Equal(t, name, matched.Name, addr)
+		}
+	}
+
+	_, err = NewRules([]RoutingRule{{CIDRs: []string{"10.0.0.0/33"}}})
+	assert.Error(t, err)
+	_, err = NewRules([]RoutingRule{{Name: "a"}, {Name: "a"}})
+	assert.Error(t, err)
+}
+
+func TestRules_Report(t *testing.T) {
+	rules, err := NewRules([]RoutingRule{
+		{Name: "example", Domains: []string{"example.com"}},
+		{Name: "intranet", CIDRs: []string{"10.0.0.0/8"}, Ports: []uint16{80, 443}},
+		{Name: "www", Domains: []string{"www.example.com"}},
+		{Name: "web", CIDRs: []string{"10.1.0.0/16"}, Ports: []uint16{443}},
+		{Name: "mixed", Domains: []string{"a.example.com"}, CIDRs: []string{"10.2.0.0/16"}},
+		{Name: "ssh", CIDRs: []string{"10.3.0.0/16"}, Ports: []uint16{22}},
+		{Name: "catch-all"},
+		{Name: "after-all", Domains: []string{"example.org"}},
+	})
+	assert.NoError(t, err)
+
+	rules.lookup("example.com:443").hit("example.com:443")
+	report := rules.Report(time.Hour)
+	assert.NotContains(t, report.Unused, "example")
+	assert.Contains(t, report.Unused, "ssh")
+
+	shadowed := make(map[string][]string)
+	for _, rule := range report.Shadowed {
+		shadowed[rule.Name] = rule.ShadowedBy
+	}
+
+	assert.Equal(t, map[string][]string{
+		"www":       {"example"},
+		"web":       {"intranet"},
+		"after-all": {"catch-all"},
+	}, shadowed)
+}
+
+func TestPool_Route(t *testing.T) {
+	pool := &Pool{backends: make(map[string]*Backend)}
+	jp := NewBackend("10.90.0.1:1086", BackendCheckConfig{InitialAlive: true})
+	jp.Labels = map[string]string{"country": "jp"}
+	us := NewBackend("10.90.0.2:1086", BackendCheckConfig{InitialAlive: true})
+	us.Labels = map[string]string{"country": "us"}
+	assert.NoError(t, pool.Add(jp))
+	assert.NoError(t, pool.Add(us))
+
+	rules, err := NewRules([]RoutingRule{{Name: "jp", Domains: []string{"example.jp"}, Selector: "country=jp"}})
+	assert.NoError(t, err)
+	pool.SetRules(rules)
+
+	for i := 0; i < 4; i++ {
+		backend, matched := pool.route("", "www.example.jp:443")
+		assert.Equal(t, jp, backend)
+		assert.Equal(t, "jp", matched.Name)
+
+		// count the session and its bytes
+		client, server := net.Pipe()
+		conn := matched.track(client)
+		go func() {
+			buf := make([]byte, 5)
+			_, _ = server.Read(buf)
+			_ = server.Close()
+		}()
+		_, _ = conn.Write([]byte("hello"))
+		_ = conn.Close()
+	}
+
+	_, matched := pool.route("", "example.com:443")
+	assert.Nil(t, matched)
+
+	// the picks are not counted, no connection is relayed for them
+	assert.Equal(t, jp, pool.Pick(nil, "www.example.jp:443"))
+
+	stats := rules.Stats()
+	assert.Equal(t, uint64(4), stats[0].Matches)
+	assert.Equal(t, uint64(4), stats[0].Sessions)
+	assert.Equal(t, uint64(20), stats[0].Bytes)
+	assert.False(t, stats[0].LastHit.IsZero())
+}
+
+func TestServer_HTTPMetrics(t *testing.T) {
+	rules, err := NewRules([]RoutingRule{
+		{Name: `jp "office"`, Domains: []string{"example.jp"}, Selector: "country=jp"},
+		{Name: "unused", Domains: []string{"example.org"}},
+	})
+	assert.NoError(t, err)
+	rules.lookup("www.example.jp:443").hit("www.example.jp:443")
+
+	pool := &Pool{backends: make(map[string]*Backend)}
+	pool.SetRules(rules)
+	server, err := NewServer(pool, ServerConfig{})
+	assert.NoError(t, err)
+	router, err := server.newRouter()
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/metrics", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, metricsContentType, w.Header().Get("Content-Type"))
+
+	body := w.Body.String()
+	assert.Contains(t, body, "# TYPE socks5lb_rule_matches_total counter\n")
+	assert.Contains(t, body, `socks5lb_rule_matches_total{rule="jp \"office\"",selector="country=jp"} 1`+"\n")
+	assert.Contains(t, body, `socks5lb_rule_matches_total{rule="unused",selector=""} 0`+"\n")
+	assert.Contains(t, body, `socks5lb_rule_last_hit_timestamp_seconds{rule="unused",selector=""} 0`+"\n")
+}
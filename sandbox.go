@@ -0,0 +1,191 @@
+/**
+ * File: sandbox.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Sunday, October 18th 2026, 4:05:37 pm
+ * Last Modified: Sunday, October 18th 2026, 4:05:37 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// SandboxActionErrno to fail the disallowed syscalls with EPERM
+	SandboxActionErrno = "errno"
+	// SandboxActionLog to allow the disallowed syscalls but log them only
+	SandboxActionLog = "log"
+	// SandboxActionKill to kill the process on the disallowed syscalls
+	SandboxActionKill = "kill"
+)
+
+// sandboxListenerTimeout is how long to wait for the listeners before installing the sandbox
+const sandboxListenerTimeout = 10 * time.Second
+
+type SandboxConfig struct {
+	Enable bool `yaml:"enable" json:"enable"`
+	// Action on the disallowed syscalls, "errno" by default, "log" or "kill"
+	Action string `yaml:"action" json:"action"`
+	// Syscalls are the extra syscalls to allow
+	Syscalls []string `yaml:"syscalls" json:"syscalls"`
+}
+
+// SandboxStatus is the state of the seccomp sandbox of the process
+type SandboxStatus struct {
+	Enabled  bool      `json:"enabled"`
+	Active   bool      `json:"active"`
+	Action   string    `json:"action,omitempty"`
+	Syscalls int       `json:"syscalls,omitempty"`
+	Time     time.Time `json:"time,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+var (
+	sandboxStatus SandboxStatus
+	sandboxLock   sync.Mutex
+)
+
+// sandboxBaseSyscalls are needed by the go runtime, the listeners and the backend connections
+var sandboxBaseSyscalls = []string{
+	// go runtime
+	"read", "write", "close", "fstat", "newfstatat", "lseek", "mmap", "munmap", "mprotect", "madvise",
+	"mincore", "brk", "rt_sigaction", "rt_sigprocmask", "rt_sigreturn", "sigaltstack", "ioctl",
+	"pread64", "pwrite64", "readv", "writev", "fstatat", "sched_yield", "sched_getaffinity", "nanosleep",
+	"clock_gettime", "clock_nanosleep", "gettimeofday", "getpid", "gettid", "getppid", "tgkill", "tkill",
+	"kill", "clone", "clone3", "exit", "exit_group", "futex", "set_robust_list", "get_robust_list",
+	"rseq", "arch_prctl", "set_tid_address", "prlimit64", "getrlimit", "uname", "getrandom",
+	"membarrier", "sysinfo", "restart_syscall", "timer_create", "timer_settime", "timer_delete",
+	"getuid", "geteuid", "getgid", "getegid", "getgroups",
+	// poller and file descriptors
+	"epoll_create", "epoll_create1", "epoll_ctl", "epoll_wait", "epoll_pwait", "epoll_pwait2",
+	"eventfd2", "pipe", "pipe2", "dup", "dup2", "dup3", "fcntl", "poll", "ppoll", "select", "pselect6",
+	// reading the configuration, the certificates, /etc/resolv.conf and /proc
+	"open", "openat", "stat", "lstat", "statx", "readlink", "readlinkat", "getdents64", "access",
+	"faccessat", "faccessat2", "getcwd",
+	// network
+	"socket", "socketpair", "connect", "accept", "accept4", "bind", "listen", "getsockname",
+	"getpeername", "setsockopt", "getsockopt", "sendto", "recvfrom", "sendmsg", "recvmsg",
+	"sendmmsg", "recvmmsg", "shutdown",
+	// relaying the connections by io.Copy, the tcp connections are spliced through a pipe
+	"splice", "sendfile",
+}
+
+// sandboxCacheSyscalls are needed to store the http proxy cache on disk
+var sandboxCacheSyscalls = []string{
+	"mkdir", "mkdirat", "unlink", "unlinkat", "rename", "renameat", "renameat2", "fsync",
+	"fdatasync", "ftruncate", "fchmod", "fchmodat",
+}
+
+// sandboxCommandSyscalls are needed to start the helper processes of the backends,
+// the processes inherit the sandbox too
+var sandboxCommandSyscalls = []string{
+	"execve", "execveat", "vfork", "wait4", "waitid", "pidfd_open", "pidfd_send_signal", "setpgid",
+	"setsid", "getpgid", "prctl", "chdir", "fchdir",
+}
+
+// SandboxSyscalls returns the syscalls needed by the configured features
+func SandboxSyscalls(config *ServerConfig) []string {
+	syscalls := append([]string(nil), sandboxBaseSyscalls...)
+	if config.HTTPProxy.Cache.Enable && config.HTTPProxy.Cache.Dir != "" {
+		syscalls = append(syscalls, sandboxCacheSyscalls...)
+	}
+
+	return append(syscalls, config.Sandbox.Syscalls...)
+}
+
+// seccompMode returns the seccomp mode of the process, 2 is the filter mode
+func seccompMode() string {
+	file, err := os.Open("/proc/self/status")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value := strings.TrimPrefix(scanner.Text(), "Seccomp:"); value != scanner.Text() {
+			return strings.TrimSpace(value)
+		}
+	}
+
+	return ""
+}
+
+// Sandbox returns the state of the seccomp sandbox
+func Sandbox() SandboxStatus {
+	sandboxLock.Lock()
+	defer sandboxLock.Unlock()
+
+	status := sandboxStatus
+	status.Active = status.Enabled && status.Error == "" && seccompMode() == "2"
+	return status
+}
+
+// InstallSandbox to install the seccomp filter for the process, it can not be removed
+func InstallSandbox(config *ServerConfig) (err error) {
+	action := config.Sandbox.Action
+	if action == "" {
+		action = SandboxActionErrno
+	}
+
+	syscalls := SandboxSyscalls(config)
+	count, err := installSandbox(action, syscalls)
+
+	sandboxLock.Lock()
+	defer sandboxLock.Unlock()
+
+	sandboxStatus = SandboxStatus{Enabled: true, Action: action, Syscalls: count, Time: time.Now()}
+	if err != nil {
+		sandboxStatus.Error = err.Error()
+		return
+	}
+
+	log.Infof("seccomp sandbox is installed, %d syscalls are allowed, action %s", count, action)
+	return
+}
+
+// listening to tell the sandbox the listener is set up, or failed to, the restarted listeners are ignored
+func (s *Server) listening() {
+	s.listenersLock.Lock()
+	defer s.listenersLock.Unlock()
+
+	if s.listeners != nil && s.pendingListeners > 0 {
+		s.pendingListeners--
+		s.listeners.Done()
+	}
+}
+
+// startSandbox to install the sandbox after all the listeners are set up
+func (s *Server) startSandbox() {
+	done := make(chan struct{})
+	go func() {
+		s.listeners.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(sandboxListenerTimeout):
+		log.Warn("timeout to wait for the listeners, install the sandbox anyway")
+	}
+
+	config := *s.Config
+	if s.Pool.HasCommands() {
+		config.Sandbox.Syscalls = append(append([]string(nil), config.Sandbox.Syscalls...), sandboxCommandSyscalls...)
+	}
+
+	if err := InstallSandbox(&config); err != nil {
+		log.Errorf("install the seccomp sandbox failed, %v", err)
+		RecordEvent("sandbox.failed", "install the seccomp sandbox failed, %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+//go:build linux && (amd64 || arm64)
+
+/**
+ * File: sandbox_linux.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Sunday, October 18th 2026, 4:18:44 pm
+ * Last Modified: Sunday, October 18th 2026, 4:18:44 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"fmt"
+	"sort"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// the seccomp constants, see linux/seccomp.h
+const (
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTSync = 1 << 0
+	seccompFilterFlagLog   = 1 << 1
+
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetLog         = 0x7ffc0000
+	seccompRetAllow       = 0x7fff0000
+
+	// seccompDataNr and seccompDataArch are the offsets in the seccomp data
+	seccompDataNr   = 0
+	seccompDataArch = 4
+
+	// x32 syscalls have this bit set on amd64
+	x32SyscallBit = 0x40000000
+)
+
+// sandboxDefaultAction returns the seccomp return value of the disallowed syscalls
+func sandboxDefaultAction(action string) (uint32, error) {
+	switch action {
+	case SandboxActionErrno:
+		return seccompRetErrno | uint32(unix.EPERM), nil
+	case SandboxActionLog:
+		return seccompRetLog, nil
+	case SandboxActionKill:
+		return seccompRetKillProcess, nil
+	}
+
+	return 0, fmt.Errorf("unknown sandbox action %s", action)
+}
+
+// sandboxNumbers returns the sorted unique syscall numbers, the syscalls missing
+// on this architecture are skipped, like open on arm64
+func sandboxNumbers(syscalls []string) (numbers []uint32) {
+	seen := make(map[uintptr]bool)
+	for _, name := range syscalls {
+		nr, ok := sandboxSyscallNumbers[name]
+		if !ok {
+			log.Tracef("syscall %s is not available on this architecture, skip it", name)
+			continue
+		}
+
+		if !seen[nr] {
+			seen[nr] = true
+			numbers = append(numbers, uint32(nr))
+		}
+	}
+
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return
+}
+
+// sandboxFilter returns the bpf program to allow the syscalls only
+func sandboxFilter(numbers []uint32, defaultAction uint32) ([]unix.SockFilter, error) {
+	// the jump offsets are 8 bits
+	if len(numbers) > 250 {
+		return nil, fmt.Errorf("too many syscalls to allow, %d", len(numbers))
+	}
+
+	stmt := func(code uint16, k uint32) unix.SockFilter {
+		return unix.SockFilter{Code: code, K: k}
+	}
+	jump := func(code uint16, k uint32, jt, jf int) unix.SockFilter {
+		return unix.SockFilter{Code: code, Jt: uint8(jt), Jf: uint8(jf), K: k}
+	}
+
+	// the instructions before the default action and the allow action
+	n := len(numbers)
+	deny := 4 + n
+
+	filter := []unix.SockFilter{
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataArch),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, sandboxAuditArch, 0, deny-2),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataNr),
+		jump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, x32SyscallBit, deny-4, 0),
+	}
+
+	for i, nr := range numbers {
+		filter = append(filter, jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nr, n-i, 0))
+	}
+
+	return append(filter,
+		stmt(unix.BPF_RET|unix.BPF_K, defaultAction),
+		stmt(unix.BPF_RET|unix.BPF_K, seccompRetAllow),
+	), nil
+}
+
+// installSandbox to install the seccomp filter for all threads, the disallowed
+// syscalls are logged by the kernel audit
+func installSandbox(action string, syscalls []string) (count int, err error) {
+	defaultAction, err := sandboxDefaultAction(action)
+	if err != nil {
+		return
+	}
+
+	for _, name := range syscalls {
+		if _, ok := sandboxSyscallNumbers[name]; !ok && !isKnownSyscall(name) {
+			return 0, fmt.Errorf("unknown syscall %s", name)
+		}
+	}
+
+	numbers := sandboxNumbers(syscalls)
+	filter, err := sandboxFilter(numbers, defaultAction)
+	if err != nil {
+		return
+	}
+
+	if err = unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return 0, fmt.Errorf("set no new privileges failed, %v", err)
+	}
+
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if _, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter,
+		seccompFilterFlagTSync|seccompFilterFlagLog, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return 0, fmt.Errorf("install the seccomp filter failed, %v", errno)
+	}
+
+	return len(numbers), nil
+}
+
+// isKnownSyscall returns true if the syscall is required by the features on some architectures
+func isKnownSyscall(name string) bool {
+	for _, syscalls := range [][]string{sandboxBaseSyscalls, sandboxCacheSyscalls, sandboxCommandSyscalls} {
+		for _, syscall := range syscalls {
+			if syscall == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,96 @@
+//go:build linux && (amd64 || arm64)
+
+package socks5lb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSandboxFilter(t *testing.T) {
+	numbers := sandboxNumbers([]string{"read", "write", "read", "open"})
+	filter, err := sandboxFilter(numbers, seccompRetLog)
+	assert.NoError(t, err)
+	assert.Len(t, filter, 4+len(numbers)+2)
+	assert.Equal(t, uint32(seccompRetAllow), filter[len(filter)-1].K)
+
+	_, err = installSandbox("unknown", nil)
+	assert.Error(t, err)
+
+	_, err = installSandbox(SandboxActionErrno, []string{"not_a_syscall"})
+	assert.Error(t, err)
+}
+
+// the sandbox can not be removed, so install it in a child process
+func TestSandbox_Install(t *testing.T) {
+	if os.Getenv("SOCKS5LB_SANDBOX_CHILD") != "1" {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestSandbox_Install$", "-test.v")
+		cmd.Env = append(os.Environ(), "SOCKS5LB_SANDBOX_CHILD=1")
+		output, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(output))
+		return
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer ts.Close()
+
+	assert.NoError(t, InstallSandbox(&ServerConfig{}))
+	assert.True(t, Sandbox().Active)
+
+	// the network is still available
+	resp, err := http.Get(ts.URL)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "ok", string(body))
+
+	// relay tcp to tcp like the sessions, which splices the connections
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		upstream, err := net.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			return
+		}
+		defer upstream.Close()
+
+		go io.Copy(upstream, conn)
+		_, _ = io.Copy(conn, upstream)
+	}()
+
+	relay := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial("tcp", l.Addr().String())
+		},
+	}}
+	resp, err = relay.Get(ts.URL)
+	if assert.NoError(t, err) {
+		body, _ = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(t, "ok", string(body))
+	}
+
+	// the disk cache is not enabled, so creating directories is not allowed
+	err = os.Mkdir(filepath.Join(os.TempDir(), "socks5lb-sandbox-test"), 0o755)
+	assert.True(t, errors.Is(err, syscall.EPERM), "%v", err)
+}
@@ -0,0 +1,20 @@
+//go:build !linux || !(amd64 || arm64)
+
+/**
+ * File: sandbox_other.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Sunday, October 18th 2026, 4:18:44 pm
+ * Last Modified: Sunday, October 18th 2026, 4:18:44 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import "errors"
+
+// installSandbox is not supported on this platform
+func installSandbox(_ string, _ []string) (int, error) {
+	return 0, errors.New("seccomp sandbox is only supported on Linux amd64 and arm64")
+}
@@ -0,0 +1,123 @@
+/**
+ * File: selector.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Saturday, October 17th 2026, 10:20:11 am
+ * Last Modified: Saturday, October 17th 2026, 10:20:11 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"fmt"
+	"strings"
+)
+
+type selectorOp int
+
+const (
+	selectorEquals selectorOp = iota
+	selectorNotEquals
+	selectorExists
+	selectorNotExists
+)
+
+type selectorRequirement struct {
+	key   string
+	op    selectorOp
+	value string
+}
+
+// Selector is a parsed label selector, like `provider=x,country!=us`
+type Selector []selectorRequirement
+
+// ParseSelector parses a comma separated label selector, supports the
+// `key=value`, `key==value`, `key!=value`, `key` and `!key` requirements
+func ParseSelector(str string) (selector Selector, err error) {
+	for _, part := range strings.Split(str, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var req selectorRequirement
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			req = selectorRequirement{key: kv[0], op: selectorNotEquals, value: kv[1]}
+		case strings.Contains(part, "=="):
+			kv := strings.SplitN(part, "==", 2)
+			req = selectorRequirement{key: kv[0], op: selectorEquals, value: kv[1]}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(part, "=", 2)
+			req = selectorRequirement{key: kv[0], op: selectorEquals, value: kv[1]}
+		case strings.HasPrefix(part, "!"):
+			req = selectorRequirement{key: part[1:], op: selectorNotExists}
+		default:
+			req = selectorRequirement{key: part, op: selectorExists}
+		}
+
+		req.key, req.value = strings.TrimSpace(req.key), strings.TrimSpace(req.value)
+		if req.key == "" || strings.ContainsAny(req.key, "=! ") {
+			return nil, fmt.Errorf("invalid selector requirement %q", part)
+		}
+
+		selector = append(selector, req)
+	}
+
+	return
+}
+
+// Empty returns true if the selector has no requirements and matches everything
+func (s Selector) Empty() bool {
+	return len(s) == 0
+}
+
+// Matches returns true if the given labels satisfy all requirements
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, req := range s {
+		value, ok := labels[req.key]
+
+		switch req.op {
+		case selectorEquals:
+			if !ok || value != req.value {
+				return false
+			}
+		case selectorNotEquals:
+			if ok && value == req.value {
+				return false
+			}
+		case selectorExists:
+			if !ok {
+				return false
+			}
+		case selectorNotExists:
+			if ok {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// String returns the canonical form of the selector
+func (s Selector) String() string {
+	parts := make([]string, 0, len(s))
+	for _, req := range s {
+		switch req.op {
+		case selectorEquals:
+			parts = append(parts, req.key+"="+req.value)
+		case selectorNotEquals:
+			parts = append(parts, req.key+"!="+req.value)
+		case selectorExists:
+			parts = append(parts, req.key)
+		case selectorNotExists:
+			parts = append(parts, "!"+req.key)
+		}
+	}
+
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,40 @@
+package socks5lb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelector_Matches(t *testing.T) {
+	labels := map[string]string{
+		"provider": "x",
+		"country":  "jp",
+	}
+
+	cases := map[string]bool{
+		"":                        true,
+		"provider=x":              true,
+		"provider==x":             true,
+		"provider=x,country!=us":  true,
+		"provider=x,country!=jp":  false,
+		"provider=y":              false,
+		"country":                 true,
+		"!country":                false,
+		"!region":                 true,
+		" provider = x , region ": false,
+	}
+
+	for str, expected := range cases {
+		selector, err := ParseSelector(str)
+		assert.NoError(t, err, str)
+		assert.Equal(t, expected, selector.Matches(labels), str)
+	}
+}
+
+func TestSelector_Invalid(t *testing.T) {
+	for _, str := range []string{"=x", "!=x", "!", "a b=c"} {
+		_, err := ParseSelector(str)
+		assert.Error(t, err, str)
+	}
+}
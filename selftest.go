@@ -0,0 +1,369 @@
+/**
+ * File: selftest.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Tuesday, October 20th 2026, 3:12:40 pm
+ * Last Modified: Tuesday, October 20th 2026, 3:12:40 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/txthinking/socks5"
+)
+
+// selfTestTimeout is the timeout of each self-test step in seconds
+const selfTestTimeout = 5
+
+// SelfTestResult is the result of a single self-test check
+type SelfTestResult struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+	// Fix is the suggestion to fix the failed check
+	Fix string `json:"fix,omitempty"`
+}
+
+// selfTestResult returns the passed result if err is nil, or the failed one with the fix
+func selfTestResult(name string, err error, fix string) SelfTestResult {
+	if err != nil {
+		return SelfTestResult{Name: name, Detail: err.Error(), Fix: fix}
+	}
+
+	return SelfTestResult{Name: name, Passed: true}
+}
+
+// selfTestSkipped returns the skipped result with the reason
+func selfTestSkipped(name, reason string) SelfTestResult {
+	return SelfTestResult{Name: name, Passed: true, Skipped: true, Detail: reason}
+}
+
+// selfTestListener is a configured listener, named like the diagnostic listeners
+type selfTestListener struct {
+	name, network, addr string
+}
+
+// selfTestListeners returns the configured listeners
+func selfTestListeners(config ServerConfig) (listeners []selfTestListener) {
+	for _, l := range []selfTestListener{
+		{"socks5", "tcp", config.Sock5.Addr},
+		{"http_proxy", "tcp", config.HTTPProxy.Addr},
+		{"quic", "udp", config.QUIC.Addr},
+		{"tproxy", "tcp", config.TProxy.Addr},
+		{"http_admin", "tcp", config.HTTP.Addr},
+	} {
+		if l.addr != "" {
+			listeners = append(listeners, l)
+		}
+	}
+
+	return
+}
+
+// checkListenAddr to check the address of the listener is available
+func checkListenAddr(l selfTestListener) SelfTestResult {
+	var (
+		closer io.Closer
+		err    error
+	)
+
+	if l.network == "udp" {
+		closer, err = net.ListenPacket(l.network, l.addr)
+	} else {
+		closer, err = net.Listen(l.network, l.addr)
+	}
+
+	name := fmt.Sprintf("listen %s %s", l.name, l.addr)
+	switch {
+	case err == nil:
+		_ = closer.Close()
+		return selfTestResult(name, nil, "")
+	case errors.Is(err, syscall.EADDRINUSE):
+		return selfTestResult(name, err, "the address is already in use, stop the other process "+
+			"(find it by `ss -lntup`), maybe socks5lb is running, or change the address")
+	case errors.Is(err, syscall.EACCES):
+		return selfTestResult(name, err, "binding the privileged port requires root or CAP_NET_BIND_SERVICE, "+
+			"like `setcap cap_net_bind_service+ep $(which socks5lb)`, or use a port above 1024")
+	default:
+		return selfTestResult(name, err, "check the address is valid and assigned to this host")
+	}
+}
+
+// checkBackend to run the health check of the backend
+func checkBackend(backend Backend) SelfTestResult {
+	name := fmt.Sprintf("backend %s", backend.Addr)
+	if len(backend.Command.Args) > 0 {
+		return selfTestSkipped(name, "the helper process is started by the running instance")
+	}
+
+	if backend.CheckConfig.CheckURL == "" {
+		return selfTestSkipped(name, fmt.Sprintf("no check_url, initial_alive is %v", backend.CheckConfig.InitialAlive))
+	}
+
+	if backend.CheckConfig.Timeout == 0 {
+		backend.CheckConfig.Timeout = selfTestTimeout
+	}
+	defer backend.closeWireGuard()
+
+	return selfTestResult(name, backend.Check(), fmt.Sprintf("make sure the backend is running and %s "+
+		"is reachable through it, or set check_config.initial_alive without the check_url", backend.CheckConfig.CheckURL))
+}
+
+// serveClassicSocks5 to serve the tcp requests of the classic socks5 server on the listener,
+// it is stopped by closing the listener, unlike ListenAndServe and Shutdown which race on the fields
+func serveClassicSocks5(server *socks5.Server, listener net.Listener) error {
+	handle := &socks5.DefaultHandle{}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func(conn *net.TCPConn) {
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(time.Duration(server.TCPTimeout) * time.Second))
+
+			if err := server.Negotiate(conn); err != nil {
+				return
+			}
+
+			request, err := server.GetRequest(conn)
+			if err != nil {
+				return
+			}
+
+			_ = handle.TCPHandle(server, conn, request)
+		}(conn.(*net.TCPConn))
+	}
+}
+
+// freeAddr returns a free local address of the network
+func freeAddr(network string) (addr string, err error) {
+	if network == "udp" {
+		conn, err := net.ListenPacket(network, "127.0.0.1:0")
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		return conn.LocalAddr().String(), nil
+	}
+
+	l, err := net.Listen(network, "127.0.0.1:0")
+	if err != nil {
+		return
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+// selfTestGet to get the token from the url by the client, retry until the listener is ready
+func selfTestGet(client *http.Client, url, token string) (err error) {
+	client.Timeout = selfTestTimeout * time.Second
+
+	for deadline := time.Now().Add(selfTestTimeout * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		var resp *http.Response
+		if resp, err = client.Get(url); err != nil {
+			continue
+		}
+
+		data, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+
+		if token != "" && string(data) != token {
+			return errors.New("unexpected response body")
+		}
+
+		return nil
+	}
+
+	return
+}
+
+// selfTestEndToEnd runs the configured listener types in process, on the local addresses,
+// with a fake socks5 backend and a fake destination
+func selfTestEndToEnd(config ServerConfig) (results []SelfTestResult) {
+	fail := func(err error) []SelfTestResult {
+		return []SelfTestResult{selfTestResult("end-to-end setup", err, "check the loopback interface is up")}
+	}
+
+	// the fake destination responses the random token
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	token := hex.EncodeToString(buf)
+
+	destination, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fail(err)
+	}
+	defer destination.Close()
+
+	go http.Serve(destination, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(token))
+	}))
+	target := "http://" + destination.Addr().String() + "/"
+
+	// the fake backend is a classic socks5 server
+	backendListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fail(err)
+	}
+	defer backendListener.Close()
+	backendAddr := backendListener.Addr().String()
+
+	fake, err := socks5.NewClassicServer(backendAddr, "127.0.0.1", "", "", selfTestTimeout, selfTestTimeout)
+	if err != nil {
+		return fail(err)
+	}
+	go serveClassicSocks5(fake, backendListener)
+
+	pool := &Pool{backends: make(map[string]*Backend)}
+	if err = pool.Add(NewBackend(backendAddr, BackendCheckConfig{InitialAlive: true, Timeout: selfTestTimeout})); err != nil {
+		return fail(err)
+	}
+
+	// keep the listener settings only, the side effects like the audit file are disabled
+	serverConfig := ServerConfig{}
+	serverConfig.Sock5.Mode = config.Sock5.Mode
+	serverConfig.QUIC = config.QUIC
+	// the in-process client has no certificate signed by the client ca, and the loopback listener is allowed without it
+	serverConfig.QUIC.ClientCAFile = ""
+	serverConfig.HTTP.Auth = config.HTTP.Auth
+	serverConfig.HTTP.Auth.AuditFile = ""
+
+	server, err := NewServer(pool, serverConfig)
+	if err != nil {
+		return fail(err)
+	}
+	defer server.Stop()
+
+	for _, l := range selfTestListeners(config) {
+		name := fmt.Sprintf("end-to-end %s", l.name)
+		if l.name == "tproxy" {
+			results = append(results, selfTestSkipped(name, "the transparent proxy requires the iptables rules, it is not tested in process"))
+			continue
+		}
+
+		addr, err := freeAddr(l.network)
+		if err != nil {
+			results = append(results, selfTestResult(name, err, "check the loopback interface is up"))
+			continue
+		}
+
+		switch l.name {
+		case "socks5":
+			go server.ListenSocks5(addr)
+
+			client, err := socks5.NewClient(addr, "", "", selfTestTimeout, selfTestTimeout)
+			if err == nil {
+				err = selfTestGet(&http.Client{Transport: &http.Transport{Dial: client.Dial}}, target, token)
+			}
+			results = append(results, selfTestResult(name, err, "check the socks5 mode, it is passthrough or relay"))
+
+		case "http_proxy":
+			go server.ListenHTTPProxy(addr)
+
+			proxy := &url.URL{Scheme: "http", Host: addr}
+			err = selfTestGet(&http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxy)}}, target, token)
+			results = append(results, selfTestResult(name, err, "check the http proxy configuration"))
+
+		case "quic":
+			if _, err := tls.LoadX509KeyPair(config.QUIC.CertFile, config.QUIC.KeyFile); err != nil {
+				results = append(results, selfTestResult(name, err,
+					"set quic.cert_file and quic.key_file to a valid PEM certificate and its key"))
+				continue
+			}
+			go server.ListenQUIC(addr)
+
+			backend := NewBackend(addr, BackendCheckConfig{Timeout: selfTestTimeout})
+			backend.Protocol, backend.QUIC.InsecureSkipVerify = ProtocolQUIC, true
+			err = selfTestGet(&http.Client{Transport: &http.Transport{
+				Dial: func(network, addr string) (net.Conn, error) {
+					return backend.DialFor("", network, addr, selfTestTimeout)
+				},
+			}}, target, token)
+			results = append(results, selfTestResult(name, err, "allow the udp traffic of the quic address in the firewall"))
+
+		case "http_admin":
+			router, err := server.newRouter()
+			if err != nil {
+				results = append(results, selfTestResult(name, err, "check the http configuration"))
+				continue
+			}
+
+			listener, err := net.Listen(l.network, addr)
+			if err != nil {
+				results = append(results, selfTestResult(name, err, "check the loopback interface is up"))
+				continue
+			}
+			go http.Serve(listener, router)
+
+			err = selfTestGet(&http.Client{}, "http://"+addr+"/version", "")
+			_ = listener.Close()
+			results = append(results, selfTestResult(name, err, "check the http configuration"))
+		}
+	}
+
+	return
+}
+
+// SelfTest checks the prerequisites of the configuration on this host, like the
+// listening addresses, the transparent proxy and the backends, then runs the
+// configured listener types in process with a fake backend
+func SelfTest(config *Configure) (results []SelfTestResult) {
+	results = append(results, selfTestResult("configuration", config.Validate(), "fix the configuration file"))
+
+	listeners := selfTestListeners(config.ServerConfig)
+	for _, l := range listeners {
+		results = append(results, checkListenAddr(l))
+	}
+
+	if quic := config.ServerConfig.QUIC; quic.Addr != "" {
+		_, err := tls.LoadX509KeyPair(quic.CertFile, quic.KeyFile)
+		results = append(results, selfTestResult("quic certificate", err,
+			"set quic.cert_file and quic.key_file to a valid PEM certificate and its key"))
+
+		if quic.ClientCAFile != "" {
+			_, err = loadCertPool(quic.ClientCAFile)
+			results = append(results, selfTestResult("quic client ca", err,
+				"set quic.client_ca_file to the PEM certificate of the ca signing the client certificates"))
+		}
+	}
+
+	if addr := config.ServerConfig.TProxy.Addr; addr != "" {
+		results = append(results, selfTestTProxy(addr)...)
+	}
+
+	// check the backends concurrently, keep the results in order
+	checks := make([]SelfTestResult, len(config.Backends))
+	var wg sync.WaitGroup
+	for i := range config.Backends {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			checks[i] = checkBackend(config.Backends[i])
+		}(i)
+	}
+	wg.Wait()
+	results = append(results, checks...)
+
+	return append(results, selfTestEndToEnd(config.ServerConfig)...)
+}
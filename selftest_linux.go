@@ -0,0 +1,121 @@
+//go:build linux
+
+/**
+ * File: selftest_linux.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Tuesday, October 20th 2026, 3:38:17 pm
+ * Last Modified: Tuesday, October 20th 2026, 3:38:17 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// capNetAdmin is the bit of CAP_NET_ADMIN in the capability sets
+const capNetAdmin = 12
+
+var (
+	// procRoot is the mount point of the procfs, replaced in the tests
+	procRoot = "/proc"
+
+	// iptablesSave returns the nat rules of iptables, replaced in the tests
+	iptablesSave = func() ([]byte, error) {
+		if _, err := exec.LookPath("iptables-save"); err != nil {
+			return nil, err
+		}
+
+		return exec.Command("iptables-save", "-t", "nat").CombinedOutput()
+	}
+)
+
+// hasCapNetAdmin returns true if the process has the effective CAP_NET_ADMIN
+func hasCapNetAdmin() (bool, error) {
+	file, err := os.Open(filepath.Join(procRoot, "self", "status"))
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "CapEff:"); ok {
+			caps, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+			if err != nil {
+				return false, err
+			}
+			return caps&(1<<capNetAdmin) != 0, nil
+		}
+	}
+
+	return false, errors.New("no effective capabilities found")
+}
+
+// selfTestTProxy checks the capability, the ip forwarding and the iptables rules of the transparent proxy
+func selfTestTProxy(addr string) (results []SelfTestResult) {
+	ok, err := hasCapNetAdmin()
+	if err == nil && !ok {
+		err = errors.New("CAP_NET_ADMIN is missing")
+	}
+	results = append(results, selfTestResult("tproxy capability", err,
+		"run as root, or `setcap cap_net_admin,cap_net_bind_service+ep $(which socks5lb)`"))
+
+	data, err := os.ReadFile(filepath.Join(procRoot, "sys", "net", "ipv4", "ip_forward"))
+	if err == nil && strings.TrimSpace(string(data)) != "1" {
+		err = errors.New("ip forwarding is disabled")
+	}
+	results = append(results, selfTestResult("tproxy ip_forward", err,
+		"`sysctl -w net.ipv4.ip_forward=1`, and persist it in /etc/sysctl.conf"))
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return append(results, selfTestResult("tproxy iptables", err, "fix the tproxy address"))
+	}
+
+	fix := fmt.Sprintf("redirect the traffic to the port, like `iptables -t nat -I PREROUTING -p tcp "+
+		"-m set --match-set redrock dst -j REDIRECT --to-ports %s`", port)
+	rules, err := iptablesSave()
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		return append(results, selfTestSkipped("tproxy iptables", "iptables-save is not found, maybe nftables is used"))
+	case err != nil:
+		return append(results, selfTestResult("tproxy iptables", fmt.Errorf("%v, %s", err, bytes.TrimSpace(rules)),
+			"run the selftest as root to read the iptables rules"))
+	}
+
+	for _, rule := range strings.Split(string(rules), "\n") {
+		if redirectsTo(strings.Fields(rule), port) {
+			return append(results, selfTestResult("tproxy iptables", nil, ""))
+		}
+	}
+
+	return append(results, selfTestResult("tproxy iptables", fmt.Errorf("no REDIRECT rule to port %s found", port), fix))
+}
+
+// redirectsTo returns true if the fields of the iptables rule redirect to the port
+func redirectsTo(fields []string, port string) bool {
+	var redirect, toPort bool
+	for i, field := range fields {
+		switch {
+		case field == "REDIRECT":
+			redirect = true
+		case field == "--to-ports" && i+1 < len(fields) && fields[i+1] == port:
+			toPort = true
+		}
+	}
+
+	return redirect && toPort
+}
@@ -0,0 +1,47 @@
+//go:build linux
+
+package socks5lb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelfTestTProxy(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "self"), 0o755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "sys", "net", "ipv4"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "self", "status"), []byte("Name:\tsocks5lb\nCapEff:\t0000000000001000\n"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "sys", "net", "ipv4", "ip_forward"), []byte("0\n"), 0o644))
+
+	defer func(root string, save func() ([]byte, error)) {
+		procRoot, iptablesSave = root, save
+	}(procRoot, iptablesSave)
+
+	procRoot = root
+	iptablesSave = func() ([]byte, error) {
+		return []byte("*nat\n-A PREROUTING -p tcp -m set --match-set redrock dst -j REDIRECT --to-ports 8848\nCOMMIT\n"), nil
+	}
+
+	results := selfTestTProxy("0.0.0.0:8848")
+	assert.Len(t, results, 3)
+	assert.True(t, results[0].Passed)
+	assert.False(t, results[1].Passed)
+	assert.Contains(t, results[1].Fix, "net.ipv4.ip_forward=1")
+	assert.True(t, results[2].Passed)
+
+	// no rule redirects to the port
+	results = selfTestTProxy("0.0.0.0:8849")
+	assert.False(t, results[2].Passed)
+	assert.Contains(t, results[2].Fix, "--to-ports 8849")
+
+	// the port is matched as a whole
+	results = selfTestTProxy("0.0.0.0:884")
+	assert.False(t, results[2].Passed)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "self", "status"), []byte("CapEff:\t0000000000000000\n"), 0o644))
+	assert.False(t, selfTestTProxy("0.0.0.0:8848")[0].Passed)
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+/**
+ * File: selftest_other.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Tuesday, October 20th 2026, 3:40:05 pm
+ * Last Modified: Tuesday, October 20th 2026, 3:40:05 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import "errors"
+
+// selfTestTProxy is not implemented by default
+func selfTestTProxy(_ string) []SelfTestResult {
+	return []SelfTestResult{selfTestResult("tproxy platform", errors.New("the transparent proxy is only supported on linux"),
+		"remove the tproxy address from the configuration")}
+}
@@ -0,0 +1,69 @@
+package socks5lb
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// SelfTestResultOf returns the result of the named check
+func SelfTestResultOf(results []SelfTestResult, name string) (result SelfTestResult, ok bool) {
+	for _, result := range results {
+		if result.Name == name {
+			return result, true
+		}
+	}
+
+	return
+}
+
+func TestSelfTest(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+
+	// the taken address fails with the fix
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer taken.Close()
+
+	config := &Configure{}
+	config.ServerConfig.Sock5.Addr = FreeAddr(t)
+	config.ServerConfig.Sock5.Mode = Socks5ModeRelay
+	config.ServerConfig.HTTPProxy.Addr = FreeAddr(t)
+	config.ServerConfig.HTTP.Addr = taken.Addr().String()
+	config.Backends = []Backend{
+		{Addr: NewTestSocks5Server(t), CheckConfig: BackendCheckConfig{CheckURL: target.URL, Timeout: 5}},
+		{Addr: "127.0.0.1:1", CheckConfig: BackendCheckConfig{CheckURL: target.URL, Timeout: 1}},
+		{Addr: "10.80.0.1:1086", CheckConfig: BackendCheckConfig{InitialAlive: true}},
+	}
+
+	results := SelfTest(config)
+	for _, c := range []struct {
+		name            string
+		passed, skipped bool
+	}{
+		{"configuration", true, false},
+		{"listen socks5 " + config.ServerConfig.Sock5.Addr, true, false},
+		{"listen http_admin " + taken.Addr().String(), false, false},
+		{"backend " + config.Backends[0].Addr, true, false},
+		{"backend 127.0.0.1:1", false, false},
+		{"backend 10.80.0.1:1086", true, true},
+		{"end-to-end socks5", true, false},
+		{"end-to-end http_proxy", true, false},
+		{"end-to-end http_admin", true, false},
+	} {
+		result, ok := SelfTestResultOf(results, c.name)
+		if assert.True(t, ok, c.name) {
+			assert.Equal(t, c.passed, result.Passed, c.name)
+			assert.Equal(t, c.skipped, result.Skipped, c.name)
+			if !result.Passed {
+				assert.NotEmpty(t, result.Fix, c.name)
+			}
+		}
+	}
+}
@@ -11,10 +11,14 @@
 package socks5lb
 
 import (
+	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"sync"
 	"time"
 
+	"github.com/quic-go/quic-go"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -31,10 +35,35 @@ type Server struct {
 	Pool   *Pool
 	Config *ServerConfig
 
-	healthCheckTimer *time.Ticker
+	socks5Listener    net.Listener
+	tproxyListener    net.Listener
+	httpProxyListener net.Listener
+	quicListener      *quic.EarlyListener
+	httpAdminListener net.Listener
 
-	socks5Listener net.Listener
-	tproxyListener net.Listener
+	httpTransport *http.Transport
+	httpCache     *HTTPCache
+
+	sessions *sessionStore
+	audit    *AuditLog
+
+	remoteConfig remoteConfigState
+	verifier     *Verifier
+
+	mdns     *MDNS
+	mdnsLock sync.Mutex
+
+	profiles profileState
+
+	// listeners are the listeners to set up before installing the sandbox
+	listeners        *sync.WaitGroup
+	pendingListeners int
+	listenersLock    sync.Mutex
+
+	supervisor *Supervisor
+
+	picks  *pickStore
+	leases *leaseStore
 }
 
 func (s *Server) AddBackend() error {
@@ -44,13 +73,38 @@ func (s *Server) AddBackend() error {
 func (s *Server) Start() (err error) {
 	duration := SecFromEnv("CHECK_TIME_INTERVAL", 60)
 
-	s.healthCheckTimer = time.NewTicker(duration)
-	go func() {
-		log.Infof("auto check backend healthy, every %v", duration)
-		for ; true; <-s.healthCheckTimer.C {
+	s.Pool.StartCommands()
+
+	if Privacy().Enable {
+		go s.enforceRetention()
+	}
+
+	log.Infof("auto check backend healthy, every %v", duration)
+	s.supervisor.Go("health_check", func(stop <-chan struct{}) error {
+		timer := time.NewTicker(duration)
+		defer timer.Stop()
+
+		for {
 			s.Pool.Check()
+
+			select {
+			case <-stop:
+				return nil
+			case <-timer.C:
+			}
 		}
-	}()
+	})
+
+	if s.Config.Sandbox.Enable {
+		s.listeners = &sync.WaitGroup{}
+		for _, addr := range []string{s.Config.HTTP.Addr, s.Config.HTTPProxy.Addr, s.Config.QUIC.Addr, s.Config.Sock5.Addr} {
+			if addr != "" {
+				s.listeners.Add(1)
+				s.pendingListeners++
+			}
+		}
+		go s.startSandbox()
+	}
 
 	//if s.Config.TProxy.Addr != "" {
 	//	log.Tracef("start tproxy address on %s", s.Config.TProxy.Addr)
@@ -61,22 +115,74 @@ func (s *Server) Start() (err error) {
 	//	}()
 	//}
 
+	if s.Config.RemoteConfig.URL != "" {
+		s.supervisor.Go("remote_config", s.watchRemoteConfig)
+	}
+
+	if len(s.Config.Profiles) > 0 {
+		go s.watchProfileSignal()
+	}
+
+	if s.Config.MDNS.Advertise || s.Config.MDNS.Discover.Enable {
+		s.supervisor.Go("mdns", s.serveMDNS)
+	}
+
 	if s.Config.HTTP.Addr != "" {
 		log.Tracef("start http admin control on %s", s.Config.HTTP.Addr)
-		go func() {
-			if err = s.ListenHTTPAdmin(s.Config.HTTP.Addr); err != nil {
-				log.Error(err)
-			}
-		}()
+		s.supervisor.Go("http_admin", func(<-chan struct{}) error {
+			return s.ListenHTTPAdmin(s.Config.HTTP.Addr)
+		})
+	}
+
+	if s.Config.HTTPProxy.Addr != "" {
+		log.Tracef("start http proxy address on %s", s.Config.HTTPProxy.Addr)
+		s.supervisor.Go("http_proxy", func(<-chan struct{}) error {
+			return s.ListenHTTPProxy(s.Config.HTTPProxy.Addr)
+		})
+	}
+
+	if s.Config.QUIC.Addr != "" {
+		log.Tracef("start quic tunnel address on %s", s.Config.QUIC.Addr)
+		s.supervisor.Go("quic", func(<-chan struct{}) error {
+			return s.ListenQUIC(s.Config.QUIC.Addr)
+		})
 	}
 
 	log.Tracef("start sock5 proxy address on %s", s.Config.Sock5.Addr)
-	return s.ListenSocks5(s.Config.Sock5.Addr)
+	s.supervisor.Go("socks5", func(<-chan struct{}) error {
+		return s.ListenSocks5(s.Config.Sock5.Addr)
+	})
+
+	// block until stopped, the failed subsystems are restarted by the supervisor
+	<-s.supervisor.Done()
+	return
+}
+
+// serveMDNS to join the multicast group and serve until it is closed
+func (s *Server) serveMDNS(<-chan struct{}) error {
+	mdns, err := NewMDNS(s.Pool, *s.Config)
+	if err != nil {
+		return fmt.Errorf("initial mdns failed, %v", err)
+	}
+
+	s.mdnsLock.Lock()
+	s.mdns = mdns
+	s.mdnsLock.Unlock()
+
+	return mdns.Serve()
+}
+
+// MDNS returns the mdns advertisement and discovery, nil if it is not running
+func (s *Server) MDNS() *MDNS {
+	s.mdnsLock.Lock()
+	defer s.mdnsLock.Unlock()
+
+	return s.mdns
 }
 
 func (s *Server) Stop() (e error) {
 	log.Debug("shutting down the server")
-	s.healthCheckTimer.Stop()
+	s.supervisor.Stop()
 
 	if s.socks5Listener != nil {
 		go s.socks5Listener.Close()
@@ -86,6 +192,27 @@ func (s *Server) Stop() (e error) {
 		go s.tproxyListener.Close()
 	}
 
+	if s.httpProxyListener != nil {
+		go s.httpProxyListener.Close()
+	}
+
+	if s.quicListener != nil {
+		go s.quicListener.Close()
+	}
+
+	if s.httpAdminListener != nil {
+		go s.httpAdminListener.Close()
+	}
+
+	if mdns := s.MDNS(); mdns != nil {
+		_ = mdns.Close()
+	}
+
+	s.stopProfileSignal()
+	s.ReleaseAll()
+	s.picks.Close()
+
+	s.Pool.StopCommands()
 	return
 }
 
@@ -113,9 +240,102 @@ func (s *Server) Transport(dst, src io.ReadWriter) (err error) {
 	return
 }
 
-func NewServer(pool *Pool, config ServerConfig) (*Server, error) {
-	return &Server{
-		Pool:   pool,
-		Config: &config,
-	}, nil
+func NewServer(pool *Pool, config ServerConfig) (server *Server, err error) {
+	server = &Server{
+		Pool:     pool,
+		Config:   &config,
+		sessions: newSessionStore(config.HTTP.Auth.SessionTTL),
+		audit:    NewAuditLog(config.HTTP.Auth.AuditFile),
+
+		supervisor: NewSupervisor(config.Supervisor),
+		picks:      newPickStore(config.Pick),
+		leases:     newLeaseStore(config.Lease),
+	}
+
+	SetPrivacy(config.Privacy)
+	if config.Privacy.Enable {
+		log.Infof("privacy mode is enabled, client ips by %s, destinations by %s", Privacy().ClientIP, Privacy().Destination)
+
+		// learn the destinations by their eTLD+1 only, or not at all
+		if config.Bandit.Enable && config.Bandit.Key != "" && config.Bandit.Key != BanditKeyETLD1 {
+			log.Warnf("privacy mode is enabled, the destinations are learned by %s instead of %s", BanditKeyETLD1, config.Bandit.Key)
+		}
+		config.Bandit.Key = BanditKeyETLD1
+		if Privacy().Destination == PrivacyDestinationDrop {
+			config.Bandit.Enable = false
+		}
+
+		// the checks need the hosts the clients used, the eTLD+1 of them may not even resolve
+		if config.AdaptiveCheck.Enable {
+			log.Warn("privacy mode is enabled, the adaptive health check is disabled")
+			config.AdaptiveCheck.Enable = false
+		}
+
+		// the cache keeps the responses, it is the payload capture
+		if config.HTTPProxy.Cache.Enable {
+			log.Warn("privacy mode is enabled, the http proxy cache is disabled")
+			config.HTTPProxy.Cache.Enable = false
+		}
+	}
+
+	SetAdaptiveTimeout(config.AdaptiveTimeout)
+	if config.AdaptiveTimeout.Enable {
+		log.Infof("derive the backend timeouts from p%v latency x %v, between %ds and %ds", AdaptiveTimeout().Percentile,
+			AdaptiveTimeout().Factor, AdaptiveTimeout().Min, AdaptiveTimeout().Max)
+	}
+
+	if server.verifier, err = NewVerifier(config.Signature); err != nil {
+		return nil, fmt.Errorf("initial signature verifier failed, %v", err)
+	}
+
+	if config.Bandit.Enable {
+		log.Infof("learn the best backend for each destination by %s", config.Bandit.Key)
+		pool.SetBandit(NewBandit(config.Bandit))
+	}
+
+	if config.Diversity.Enable {
+		diversity := NewDiversity(config.Diversity)
+		log.Infof("spread the sessions of each user across the networks by %v", diversity.config.Keys)
+		pool.SetDiversity(diversity)
+	}
+
+	if len(config.Rules) > 0 {
+		var rules *Rules
+		if rules, err = NewRules(config.Rules); err != nil {
+			return nil, fmt.Errorf("initial routing rules failed, %v", err)
+		}
+		log.Infof("route the destinations by %d rules", len(config.Rules))
+		pool.SetRules(rules)
+	}
+
+	if len(config.Profiles) > 0 {
+		if server.profiles.profiles, err = newProfiles(config); err != nil {
+			return nil, fmt.Errorf("initial profiles failed, %v", err)
+		}
+
+		active := config.Profile
+		if active == "" {
+			active = config.Profiles[0].Name
+		}
+
+		if err = server.SwitchProfile(active); err != nil {
+			return nil, err
+		}
+		log.Infof("%d profiles are configured, %s is active", len(config.Profiles), active)
+	}
+
+	if config.AdaptiveCheck.Enable {
+		log.Info("check the most accessed destinations through the backends")
+		pool.SetCheckTargets(NewCheckTargets(config.AdaptiveCheck))
+	}
+
+	server.httpTransport = server.newHTTPProxyTransport()
+	if config.HTTPProxy.Cache.Enable {
+		if server.httpCache, err = NewHTTPCache(config.HTTPProxy.Cache); err != nil {
+			return nil, fmt.Errorf("initial http cache failed, %v", err)
+		}
+		log.Infof("http proxy cache is enabled, max size %d", server.httpCache.maxSize)
+	}
+
+	return
 }
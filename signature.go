@@ -0,0 +1,222 @@
+/**
+ * File: signature.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Monday, October 19th 2026, 4:48:31 pm
+ * Last Modified: Monday, October 19th 2026, 4:48:31 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	// minisignAlgorithm signs the payload itself, minisignAlgorithmHashed signs its blake2b-512 hash
+	minisignAlgorithm       = "Ed"
+	minisignAlgorithmHashed = "ED"
+
+	minisignCommentPrefix        = "untrusted comment:"
+	minisignTrustedCommentPrefix = "trusted comment: "
+
+	// maxSignatureSize is the limit of the detached signature
+	maxSignatureSize = 4096
+)
+
+type SignatureConfig struct {
+	// PublicKeys are the trusted Ed25519 public keys, in the minisign format like
+	// "RWQ...", or the raw 32 bytes in base64, the verification is disabled if empty
+	PublicKeys []string `yaml:"public_keys"`
+}
+
+// publicKey is a trusted Ed25519 public key with the minisign key id if any
+type publicKey struct {
+	id  []byte
+	key ed25519.PublicKey
+}
+
+// Verifier verifies the detached signatures of the remotely fetched payloads
+type Verifier struct {
+	keys []publicKey
+}
+
+// parsePublicKey parses the minisign public key, the content of the .pub file, or the raw key
+func parsePublicKey(s string) (key publicKey, err error) {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[len(lines)-1]))
+	if err != nil {
+		return key, fmt.Errorf("invalid public key %s, %v", s, err)
+	}
+
+	switch {
+	case len(data) == ed25519.PublicKeySize:
+		key.key = data
+	case len(data) == 2+8+ed25519.PublicKeySize && string(data[:2]) == minisignAlgorithm:
+		key.id, key.key = data[2:10], data[10:]
+	default:
+		return key, fmt.Errorf("invalid public key %s", s)
+	}
+
+	return
+}
+
+// verify returns true if the signature of the message is signed by the key
+func (v *Verifier) verify(keyID, message, signature []byte) bool {
+	for _, key := range v.keys {
+		if keyID != nil && key.id != nil && !bytes.Equal(keyID, key.id) {
+			continue
+		}
+
+		if ed25519.Verify(key.key, message, signature) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// verifyMinisign to verify the minisign signature file, including its trusted comment
+func (v *Verifier) verifyMinisign(payload []byte, lines []string) error {
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], minisignTrustedCommentPrefix) {
+		return errors.New("malformed minisign signature")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return errors.New("malformed minisign signature")
+	}
+
+	message := payload
+	switch string(sig[:2]) {
+	case minisignAlgorithm:
+	case minisignAlgorithmHashed:
+		sum := blake2b.Sum512(payload)
+		message = sum[:]
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", sig[:2])
+	}
+
+	keyID, signature := sig[2:10], sig[10:]
+	if !v.verify(keyID, message, signature) {
+		return errors.New("invalid signature")
+	}
+
+	// the trusted comment is signed together with the signature
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return errors.New("malformed minisign trusted comment signature")
+	}
+
+	comment := strings.TrimPrefix(lines[2], minisignTrustedCommentPrefix)
+	if !v.verify(keyID, append(append([]byte(nil), signature...), comment...), global) {
+		return errors.New("invalid trusted comment signature")
+	}
+
+	return nil
+}
+
+// Verify to check the detached signature of the payload, the minisign signature
+// file or the raw Ed25519 signature in base64
+func (v *Verifier) Verify(payload, signature []byte) error {
+	text := strings.TrimSpace(strings.ReplaceAll(string(signature), "\r\n", "\n"))
+	if text == "" {
+		return errors.New("the signature is empty")
+	}
+
+	if strings.HasPrefix(text, minisignCommentPrefix) {
+		return v.verifyMinisign(payload, strings.Split(text, "\n"))
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(text)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return errors.New("malformed signature")
+	}
+
+	if !v.verify(nil, payload, sig) {
+		return errors.New("invalid signature")
+	}
+
+	return nil
+}
+
+// NewVerifier returns the verifier of the trusted public keys, nil if there is no key
+func NewVerifier(config SignatureConfig) (verifier *Verifier, err error) {
+	if len(config.PublicKeys) == 0 {
+		return
+	}
+
+	verifier = &Verifier{}
+	for _, s := range config.PublicKeys {
+		key, err := parsePublicKey(s)
+		if err != nil {
+			return nil, err
+		}
+		verifier.keys = append(verifier.keys, key)
+	}
+
+	return
+}
+
+// fetchSignature to download the detached signature of the payload
+func fetchSignature(url, authHeader string) (signature []byte, err error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return
+	}
+
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+
+	client := &http.Client{Timeout: defaultRemoteConfigTimeout * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch the signature %s failed, unexpected status code %d", url, resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxSignatureSize))
+}
+
+// verifyRemotePayload to verify the payload fetched from the url by its detached
+// signature, the rejected payloads raise an event, always passes if no key is trusted
+func (s *Server) verifyRemotePayload(kind, url, signatureURL, authHeader string, payload []byte) (err error) {
+	if s.verifier == nil {
+		return
+	}
+
+	if signatureURL == "" {
+		signatureURL = url + ".minisig"
+	}
+
+	signature, err := fetchSignature(signatureURL, authHeader)
+	if err == nil {
+		err = s.verifier.Verify(payload, signature)
+	}
+
+	if err != nil {
+		RecordEvent("signature.rejected", "%s from %s is rejected, %v", kind, url, err)
+		return fmt.Errorf("verify the signature of %s failed, %v", url, err)
+	}
+
+	log.Debugf("the signature of %s from %s is verified", kind, url)
+	return
+}
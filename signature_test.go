@@ -0,0 +1,111 @@
+package socks5lb
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/blake2b"
+)
+
+// MinisignKey returns a new key pair with the minisign public key
+func MinisignKey(t *testing.T) (ed25519.PrivateKey, []byte, string) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	keyID := []byte("socks5lb")
+	return private, keyID, base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), public...))
+}
+
+// MinisignSign returns the minisign signature file of the payload, prehashed like minisign by default
+func MinisignSign(private ed25519.PrivateKey, keyID, payload []byte) string {
+	sum := blake2b.Sum512(payload)
+	signature := ed25519.Sign(private, sum[:])
+	comment := "timestamp:1760000000\tfile:config.yml"
+	global := ed25519.Sign(private, append(append([]byte(nil), signature...), comment...))
+
+	return fmt.Sprintf("untrusted comment: signature from socks5lb\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(append(append([]byte("ED"), keyID...), signature...)),
+		comment, base64.StdEncoding.EncodeToString(global))
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	private, keyID, public := MinisignKey(t)
+	verifier, err := NewVerifier(SignatureConfig{PublicKeys: []string{"untrusted comment: minisign public key\n" + public}})
+	assert.NoError(t, err)
+
+	payload := []byte("backends: []\n")
+	signature := MinisignSign(private, keyID, payload)
+	assert.NoError(t, verifier.Verify(payload, []byte(signature)))
+	assert.Error(t, verifier.Verify([]byte("backends: [evil]\n"), []byte(signature)))
+	assert.Error(t, verifier.Verify(payload, []byte(strings.Replace(signature, "file:config.yml", "file:other.yml", 1))))
+	assert.Error(t, verifier.Verify(payload, nil))
+
+	// the raw signature by the raw public key
+	raw, err := NewVerifier(SignatureConfig{PublicKeys: []string{base64.StdEncoding.EncodeToString(private.Public().(ed25519.PublicKey))}})
+	assert.NoError(t, err)
+	assert.NoError(t, raw.Verify(payload, []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(private, payload)))))
+
+	// the other key can not verify it
+	other, _, otherPublic := MinisignKey(t)
+	verifier, err = NewVerifier(SignatureConfig{PublicKeys: []string{otherPublic}})
+	assert.NoError(t, err)
+	assert.Error(t, verifier.Verify(payload, []byte(MinisignSign(other, []byte("another!"), []byte("x")))))
+	assert.Error(t, verifier.Verify(payload, []byte(signature)))
+
+	_, err = NewVerifier(SignatureConfig{PublicKeys: []string{"not a key"}})
+	assert.Error(t, err)
+}
+
+func TestServer_PullSignedRemoteConfig(t *testing.T) {
+	private, keyID, public := MinisignKey(t)
+	config := []byte("backends:\n  - addr: 10.70.0.1:1086\n")
+	signature := MinisignSign(private, keyID, config)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config.yml":
+			_, _ = w.Write(config)
+		case "/config.yml.minisig":
+			_, _ = w.Write([]byte(signature))
+		case "/tampered.yml":
+			_, _ = w.Write([]byte("backends:\n  - addr: 10.70.0.66:1086\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	pull := func(url, signatureURL string) (*Pool, error) {
+		serverConfig := ServerConfig{}
+		serverConfig.Signature.PublicKeys = []string{public}
+		serverConfig.RemoteConfig = RemoteConfig{URL: url, SignatureURL: signatureURL}
+
+		pool := &Pool{backends: make(map[string]*Backend)}
+		server, err := NewServer(pool, serverConfig)
+		assert.NoError(t, err)
+		return pool, server.PullRemoteConfig()
+	}
+
+	pool, err := pull(ts.URL+"/config.yml", "")
+	assert.NoError(t, err)
+	assert.NotNil(t, pool.Get("10.70.0.1:1086"))
+
+	// the tampered and the unsigned configurations are rejected
+	pool, err = pull(ts.URL+"/tampered.yml", ts.URL+"/config.yml.minisig")
+	assert.Error(t, err)
+	assert.Empty(t, pool.All())
+
+	pool, err = pull(ts.URL+"/tampered.yml", "")
+	assert.Error(t, err)
+	assert.Empty(t, pool.All())
+
+	events := RecentEvents()
+	assert.Equal(t, "signature.rejected", events[len(events)-1].Kind)
+}
@@ -11,13 +11,85 @@
 package socks5lb
 
 import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
 	log "github.com/sirupsen/logrus"
+	"github.com/txthinking/socks5"
 	"net"
+	"time"
 )
 
+const (
+	Socks5ModePassthrough = "passthrough"
+	Socks5ModeRelay       = "relay"
+)
+
+// socks5Handshake to negotiate with the socks5 server on the connection, then request to connect the address
+func socks5Handshake(conn net.Conn, username, password, addr string) (err error) {
+	method := socks5.MethodNone
+	if username != "" && password != "" {
+		method = socks5.MethodUsernamePassword
+	}
+
+	if _, err = socks5.NewNegotiationRequest([]byte{method}).WriteTo(conn); err != nil {
+		return
+	}
+
+	negotiation, err := socks5.NewNegotiationReplyFrom(conn)
+	if err != nil {
+		return
+	}
+
+	if negotiation.Method != method {
+		return errors.New("unsupported socks5 negotiation method")
+	}
+
+	if method == socks5.MethodUsernamePassword {
+		if _, err = socks5.NewUserPassNegotiationRequest([]byte(username), []byte(password)).WriteTo(conn); err != nil {
+			return
+		}
+
+		reply, err := socks5.NewUserPassNegotiationReplyFrom(conn)
+		if err != nil {
+			return err
+		}
+
+		if reply.Status != socks5.UserPassStatusSuccess {
+			return socks5.ErrUserPassAuth
+		}
+	}
+
+	atyp, host, port, err := socks5.ParseAddress(addr)
+	if err != nil {
+		return
+	}
+
+	// the length of the domain is prefixed by the request again
+	if atyp == socks5.ATYPDomain {
+		host = host[1:]
+	}
+
+	if _, err = socks5.NewRequest(socks5.CmdConnect, atyp, host, port).WriteTo(conn); err != nil {
+		return
+	}
+
+	reply, err := socks5.NewReplyFrom(conn)
+	if err != nil {
+		return
+	}
+
+	if reply.Rep != socks5.RepSuccess {
+		return fmt.Errorf("socks5 server replied %d when connecting %s", reply.Rep, addr)
+	}
+
+	return
+}
+
 // ListenSocks5 to listen on a specific address
 func (s *Server) ListenSocks5(addr string) (err error) {
 	s.socks5Listener, err = net.Listen("tcp", addr)
+	s.listening()
 	if err != nil {
 		log.Error(err)
 		return
@@ -32,25 +104,174 @@ func (s *Server) ListenSocks5(addr string) (err error) {
 			return
 		}
 
-		go func() {
-			defer socks5Conn.Close()
+		if s.socks5Mode() == Socks5ModeRelay {
+			go s.relaySocks5Conn(socks5Conn, nil, "", "")
+		} else {
+			go s.handleSocks5Conn(socks5Conn)
+		}
+	}
+}
+
+// socks5Reply to write the reply of the socks5 request to the client
+func socks5Reply(conn net.Conn, rep byte) error {
+	_, err := socks5.NewReply(rep, socks5.ATYPIPv4, []byte{0, 0, 0, 0}, []byte{0, 0}).WriteTo(conn)
+	return err
+}
+
+// clientUser returns the user of the client connection, the client ip by default
+// and anonymized in the privacy mode
+func clientUser(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return anonymizeClient(addr.String())
+	}
+
+	return anonymizeClient(host)
+}
+
+// portCredentials returns the random username and password of a dedicated port, like the ports of the picks and the leases
+func portCredentials() (username, password string, err error) {
+	if username, err = randomToken(); err != nil {
+		return
+	}
+
+	password, err = randomToken()
+	return username[:16], password, err
+}
+
+// socks5Negotiate to negotiate the method with the client, the username and the password
+// are required if not empty, or no authentication is required
+func socks5Negotiate(conn net.Conn, username, password string) error {
+	negotiation, err := socks5.NewNegotiationRequestFrom(conn)
+	if err != nil {
+		return err
+	}
+
+	wanted := socks5.MethodNone
+	if username != "" {
+		wanted = socks5.MethodUsernamePassword
+	}
+
+	method := socks5.MethodUnsupportAll
+	for _, m := range negotiation.Methods {
+		if m == wanted {
+			method = m
+		}
+	}
+
+	if _, err = socks5.NewNegotiationReply(method).WriteTo(conn); err != nil {
+		return err
+	}
+
+	if method == socks5.MethodUnsupportAll {
+		return errors.New("no acceptable method")
+	}
+
+	if method != socks5.MethodUsernamePassword {
+		return nil
+	}
+
+	request, err := socks5.NewUserPassNegotiationRequestFrom(conn)
+	if err != nil {
+		return err
+	}
+
+	status := socks5.UserPassStatusFailure
+	if subtle.ConstantTimeCompare(request.Uname, []byte(username)) == 1 &&
+		subtle.ConstantTimeCompare(request.Passwd, []byte(password)) == 1 {
+		status = socks5.UserPassStatusSuccess
+	}
+
+	if _, err = socks5.NewUserPassNegotiationReply(status).WriteTo(conn); err != nil {
+		return err
+	}
+
+	if status != socks5.UserPassStatusSuccess {
+		return socks5.ErrUserPassAuth
+	}
+
+	return nil
+}
+
+// relaySocks5Conn to handle the socks5 request by itself, then connect the destination
+// through the given backend, or the backend which is chosen for the destination if nil,
+// the clients are authenticated by the username and the password if not empty
+func (s *Server) relaySocks5Conn(conn net.Conn, backend *Backend, username, password string) {
+	defer conn.Close()
+
+	if err := socks5Negotiate(conn, username, password); err != nil {
+		log.Errorf("[socks5-relay] negotiation with %s failed, %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	request, err := socks5.NewRequestFrom(conn)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	if request.Cmd != socks5.CmdConnect {
+		_ = socks5Reply(conn, socks5.RepCommandNotSupported)
+		return
+	}
 
-			backend := s.Pool.Next()
-			if backend == nil {
-				log.Error("sorry, we don't have healthy backend, so close the connection")
-				return
-			}
+	dst, user := request.Address(), clientUser(conn.RemoteAddr())
+	var matched *rule
+	if backend == nil {
+		backend, matched = s.Pool.route(user, dst)
+	}
 
-			//log.Tracef("[socks5-tcp] %s -> %s", socks5Conn.RemoteAddr(), socks5Conn.LocalAddr())
-			backendConn, err := net.Dial("tcp", string(backend.Addr))
-			if err != nil {
-				log.Error(err)
-				return
-			}
-			defer backendConn.Close()
+	if backend == nil {
+		log.Error("sorry, we don't have healthy backend, so close the connection")
+		_ = socks5Reply(conn, socks5.RepServerFailure)
+		return
+	}
 
-			// transport the socket connection directly to the backend
-			s.Transport(socks5Conn, backendConn)
-		}()
+	log.Tracef("[socks5-relay] %s -> %s via %s", conn.RemoteAddr(), dst, backend.Addr)
+	start := time.Now()
+	backendConn, err := backend.DialFor(user, "tcp", dst, backend.Timeout())
+	s.Pool.Observe(dst, backend, time.Since(start), err)
+	if err != nil {
+		log.Error(err)
+		_ = socks5Reply(conn, socks5.RepHostUnreachable)
+		return
 	}
+	defer backendConn.Close()
+	backendConn = matched.track(backendConn)
+
+	if err = socks5Reply(conn, socks5.RepSuccess); err != nil {
+		log.Error(err)
+		return
+	}
+
+	s.Transport(conn, backendConn)
+}
+
+// handleSocks5Conn to transport the socks5 connection to a healthy backend
+func (s *Server) handleSocks5Conn(socks5Conn net.Conn) {
+	defer socks5Conn.Close()
+
+	// the request is not parsed, so only the client is known to choose the backend
+	backend := s.Pool.NextForUser(clientUser(socks5Conn.RemoteAddr()))
+	if backend == nil {
+		log.Error("sorry, we don't have healthy backend, so close the connection")
+		return
+	}
+
+	// there is no socks5 service to pass through for the direct, wireguard and http backends
+	if !backend.Socks5() {
+		s.relaySocks5Conn(socks5Conn, backend, "", "")
+		return
+	}
+
+	//log.Tracef("[socks5-tcp] %s -> %s", socks5Conn.RemoteAddr(), socks5Conn.LocalAddr())
+	backendConn, err := backend.Dial(backend.Timeout())
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	defer backendConn.Close()
+
+	// transport the socket connection directly to the backend
+	s.Transport(socks5Conn, backendConn)
 }
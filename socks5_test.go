@@ -0,0 +1,71 @@
+package socks5lb
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/txthinking/socks5"
+)
+
+// NewTestSocks5Server starts a socks5 server as the fake backend
+func NewTestSocks5Server(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	server, err := socks5.NewClassicServer(l.Addr().String(), "127.0.0.1", "", "", 5, 5)
+	assert.NoError(t, err)
+	go serveClassicSocks5(server, l)
+
+	return l.Addr().String()
+}
+
+// FreeAddr returns a free local tcp address for the listeners
+func FreeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+
+	return l.Addr().String()
+}
+
+func TestServer_Socks5Relay(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer target.Close()
+
+	pool := &Pool{backends: make(map[string]*Backend)}
+	backend := NewBackend(NewTestSocks5Server(t), BackendCheckConfig{InitialAlive: true, Timeout: 5})
+	assert.NoError(t, pool.Add(backend))
+
+	config := ServerConfig{}
+	config.Sock5.Mode = Socks5ModeRelay
+	config.Bandit.Enable = true
+	server, err := NewServer(pool, config)
+	assert.NoError(t, err)
+
+	addr := FreeAddr(t)
+	go server.ListenSocks5(addr)
+
+	client, err := socks5.NewClient(addr, "", "", 5, 5)
+	assert.NoError(t, err)
+
+	httpClient := &http.Client{Transport: &http.Transport{Dial: client.Dial}}
+	assert.Eventually(t, func() bool {
+		resp, err := httpClient.Get(target.URL)
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode == http.StatusNoContent
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// the relay learns from the session
+	arms := pool.Bandit().Snapshot()["127.0.0.1"]
+	assert.Contains(t, arms, backend.Addr)
+}
@@ -0,0 +1,267 @@
+/**
+ * File: supervisor.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Wednesday, October 28th 2026, 10:41:26 am
+ * Last Modified: Wednesday, October 28th 2026, 10:41:26 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	SubsystemRunning = "running"
+	SubsystemBackoff = "backoff"
+	SubsystemStopped = "stopped"
+
+	defaultSupervisorMaxBackoff = 60
+	// supervisorStableDuration resets the backoff and the failures if the subsystem runs longer than it
+	supervisorStableDuration = time.Minute
+)
+
+var (
+	// minSupervisorBackoff is the first delay to restart the failed subsystem
+	minSupervisorBackoff = time.Second
+	// supervisorExit exits the process by the exit policy
+	supervisorExit = os.Exit
+)
+
+// backoff is the doubling delay to restart, which starts over after a stable run
+type backoff struct {
+	min, max time.Duration
+	stable   time.Duration
+	delay    time.Duration
+}
+
+// next returns the delay to restart after the run lasted so long, and whether the run was stable
+func (b *backoff) next(ran time.Duration) (delay time.Duration, stable bool) {
+	if stable = ran > b.stable; stable || b.delay == 0 {
+		b.delay = b.min
+	}
+
+	delay = b.delay
+	if b.delay *= 2; b.delay > b.max {
+		b.delay = b.max
+	}
+
+	return
+}
+
+type SupervisorConfig struct {
+	// MaxBackoff is the max delay to restart the failed subsystem in seconds, 60 by default
+	MaxBackoff uint `yaml:"max_backoff"`
+	// ExitAfter to exit the process if a critical subsystem failed so many times in a row, never if zero
+	ExitAfter uint `yaml:"exit_after"`
+	// Critical are the names of the critical subsystems, like "socks5", all of them if empty
+	Critical []string `yaml:"critical"`
+}
+
+// SubsystemStatus is the state of a supervised subsystem
+type SubsystemStatus struct {
+	Name     string    `json:"name"`
+	State    string    `json:"state"`
+	Since    time.Time `json:"since"`
+	Restarts uint      `json:"restarts"`
+	// Failures is the number of the failures in a row
+	Failures  uint      `json:"failures"`
+	LastError string    `json:"last_error,omitempty"`
+	FailedAt  time.Time `json:"failed_at,omitempty"`
+}
+
+// Supervisor runs the subsystems of the server, restarts the failed ones with the backoff
+type Supervisor struct {
+	config SupervisorConfig
+
+	lock       sync.Mutex
+	subsystems map[string]*SubsystemStatus
+	stop       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewSupervisor returns the supervisor by the configuration
+func NewSupervisor(config SupervisorConfig) *Supervisor {
+	if config.MaxBackoff == 0 {
+		config.MaxBackoff = defaultSupervisorMaxBackoff
+	}
+
+	return &Supervisor{
+		config:     config,
+		subsystems: make(map[string]*SubsystemStatus),
+		stop:       make(chan struct{}),
+	}
+}
+
+// stopping returns true if the supervisor is stopped
+func (s *Supervisor) stopping() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// critical returns true if the process exits for the failures of the subsystem
+func (s *Supervisor) critical(name string) bool {
+	if len(s.config.Critical) == 0 {
+		return true
+	}
+
+	for _, critical := range s.config.Critical {
+		if critical == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// call to run the subsystem once, the panic is returned as the error
+func call(run func(stop <-chan struct{}) error, stop <-chan struct{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic, %v", r)
+		}
+	}()
+
+	return run(stop)
+}
+
+// Go to run the subsystem until the supervisor is stopped, the run function
+// returns when it failed, or the stop channel is closed
+func (s *Supervisor) Go(name string, run func(stop <-chan struct{}) error) {
+	s.lock.Lock()
+	status := &SubsystemStatus{Name: name, State: SubsystemRunning, Since: time.Now()}
+	s.subsystems[name] = status
+	s.lock.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		restart := backoff{
+			min:    minSupervisorBackoff,
+			max:    time.Duration(s.config.MaxBackoff) * time.Second,
+			stable: supervisorStableDuration,
+		}
+		for {
+			start := time.Now()
+			err := call(run, s.stop)
+			if s.stopping() {
+				s.update(func() {
+					status.State, status.Since = SubsystemStopped, time.Now()
+				})
+				return
+			}
+
+			if err == nil {
+				err = errors.New("exited")
+			}
+
+			// the stable subsystem starts over
+			delay, stable := restart.next(time.Since(start))
+			if stable {
+				s.update(func() {
+					status.Failures = 0
+				})
+			}
+
+			var failures uint
+			s.update(func() {
+				status.Failures++
+				status.State, status.Since = SubsystemBackoff, time.Now()
+				status.LastError, status.FailedAt = err.Error(), time.Now()
+				failures = status.Failures
+			})
+			RecordEvent("subsystem.failed", "subsystem %s failed %d times in a row, %v, restart in %v", name, failures, err, delay)
+
+			if s.config.ExitAfter > 0 && failures >= s.config.ExitAfter && s.critical(name) {
+				log.Errorf("the critical subsystem %s failed %d times in a row, exit", name, failures)
+				supervisorExit(1)
+				return
+			}
+
+			select {
+			case <-s.stop:
+				s.update(func() {
+					status.State, status.Since = SubsystemStopped, time.Now()
+				})
+				return
+			case <-time.After(delay):
+			}
+
+			s.update(func() {
+				status.Restarts++
+				status.State, status.Since = SubsystemRunning, time.Now()
+			})
+		}
+	}()
+}
+
+// update to change the status of the subsystem under the lock
+func (s *Supervisor) update(change func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	change()
+}
+
+// Stop to tell all the subsystems to stop, the failures after it are not restarted
+func (s *Supervisor) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.stopping() {
+		close(s.stop)
+	}
+}
+
+// Wait for all the subsystems to return after stopped
+func (s *Supervisor) Wait() {
+	s.wg.Wait()
+}
+
+// Done returns the channel closed after the supervisor is stopped
+func (s *Supervisor) Done() <-chan struct{} {
+	return s.stop
+}
+
+// Status returns the states of the subsystems sorted by name
+func (s *Supervisor) Status() []SubsystemStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	statuses := make([]SubsystemStatus, 0, len(s.subsystems))
+	for _, status := range s.subsystems {
+		statuses = append(statuses, *status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses
+}
+
+// Healthy returns true if all the subsystems are running
+func (s *Supervisor) Healthy() bool {
+	for _, status := range s.Status() {
+		if status.State != SubsystemRunning {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,170 @@
+package socks5lb
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisor_Restart(t *testing.T) {
+	backoff := minSupervisorBackoff
+	minSupervisorBackoff = 10 * time.Millisecond
+	t.Cleanup(func() { minSupervisorBackoff = backoff })
+
+	supervisor := NewSupervisor(SupervisorConfig{})
+
+	// fails twice, panics once, then runs until stopped
+	var runs int32
+	supervisor.Go("listener", func(stop <-chan struct{}) error {
+		switch atomic.AddInt32(&runs, 1) {
+		case 1, 2:
+			return errors.New("address already in use")
+		case 3:
+			panic("boom")
+		}
+
+		<-stop
+		return nil
+	})
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&runs) == 4 && supervisor.Healthy()
+	}, 5*time.Second, 5*time.Millisecond)
+
+	status := supervisor.Status()
+	if assert.Len(t, status, 1) {
+		assert.Equal(t, "listener", status[0].Name)
+		assert.Equal(t, SubsystemRunning, status[0].State)
+		assert.Equal(t, uint(3), status[0].Restarts)
+		assert.Equal(t, uint(3), status[0].Failures)
+		assert.Equal(t, "panic, boom", status[0].LastError)
+	}
+
+	supervisor.Stop()
+	supervisor.Wait()
+	assert.Equal(t, SubsystemStopped, supervisor.Status()[0].State)
+	assert.False(t, supervisor.Healthy())
+}
+
+func TestBackoff_Next(t *testing.T) {
+	restart := backoff{min: time.Second, max: 5 * time.Second, stable: time.Minute}
+
+	var delays []time.Duration
+	for i := 0; i < 5; i++ {
+		delay, stable := restart.next(time.Millisecond)
+		assert.False(t, stable)
+		delays = append(delays, delay)
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, delays)
+
+	// starts over after the stable run
+	delay, stable := restart.next(2 * time.Minute)
+	assert.True(t, stable)
+	assert.Equal(t, time.Second, delay)
+}
+
+func TestSupervisor_ExitPolicy(t *testing.T) {
+	backoff, exit := minSupervisorBackoff, supervisorExit
+	minSupervisorBackoff = time.Millisecond
+	t.Cleanup(func() { minSupervisorBackoff, supervisorExit = backoff, exit })
+
+	codes := make(chan int, 1)
+	supervisorExit = func(code int) { codes <- code }
+
+	supervisor := NewSupervisor(SupervisorConfig{ExitAfter: 3, Critical: []string{"socks5"}})
+	defer func() {
+		supervisor.Stop()
+		supervisor.Wait()
+	}()
+
+	failed := func(<-chan struct{}) error { return errors.New("failed") }
+
+	// the non-critical subsystem is restarted forever
+	var restarts int32
+	supervisor.Go("mdns", func(stop <-chan struct{}) error {
+		atomic.AddInt32(&restarts, 1)
+		return failed(stop)
+	})
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&restarts) > 5 }, 5*time.Second, time.Millisecond)
+	assert.Empty(t, codes)
+
+	supervisor.Go("socks5", failed)
+	select {
+	case code := <-codes:
+		assert.Equal(t, 1, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the process is not exited by the policy")
+	}
+
+	for _, status := range supervisor.Status() {
+		if status.Name == "socks5" {
+			assert.Equal(t, uint(3), status.Failures)
+		}
+	}
+}
+
+func TestServer_HTTPHealthz(t *testing.T) {
+	backoff := minSupervisorBackoff
+	minSupervisorBackoff = time.Hour
+	t.Cleanup(func() { minSupervisorBackoff = backoff })
+
+	server, err := NewServer(&Pool{backends: make(map[string]*Backend)}, ServerConfig{})
+	assert.NoError(t, err)
+	defer func() {
+		server.supervisor.Stop()
+		server.supervisor.Wait()
+	}()
+
+	router, err := server.newRouter()
+	assert.NoError(t, err)
+
+	healthz := func() (int, []SubsystemStatus) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/healthz", nil)
+		router.ServeHTTP(w, req)
+
+		var result struct {
+			Healthy    bool              `json:"healthy"`
+			Subsystems []SubsystemStatus `json:"subsystems"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.Equal(t, w.Code == http.StatusOK, result.Healthy)
+		return w.Code, result.Subsystems
+	}
+
+	server.supervisor.Go("health_check", func(stop <-chan struct{}) error {
+		<-stop
+		return nil
+	})
+	code, subsystems := healthz()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, subsystems, 1)
+
+	// the failed subsystem waits for the restart
+	server.supervisor.Go("socks5", func(<-chan struct{}) error {
+		return errors.New("listen tcp: address already in use")
+	})
+	assert.Eventually(t, func() bool {
+		code, _ := healthz()
+		return code == http.StatusServiceUnavailable
+	}, 5*time.Second, 5*time.Millisecond)
+
+	_, subsystems = healthz()
+	if assert.Len(t, subsystems, 2) {
+		assert.Equal(t, "socks5", subsystems[1].Name)
+		assert.Equal(t, SubsystemBackoff, subsystems[1].State)
+		assert.Equal(t, "listen tcp: address already in use", subsystems[1].LastError)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/subsystems", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"state":"backoff"`)
+}
@@ -0,0 +1,241 @@
+/**
+ * File: timeout.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Tuesday, October 27th 2026, 4:12:55 pm
+ * Last Modified: Tuesday, October 27th 2026, 4:12:55 pm
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeoutPercentile = 99
+	defaultTimeoutFactor     = 3
+	defaultTimeoutMin        = 1
+	defaultTimeoutMax        = 10
+	defaultTimeoutSamples    = 100
+	// minTimeoutSamples is the number of the samples required to adapt the timeout
+	minTimeoutSamples = 10
+	// maxTimeoutFailures is the number of the consecutive timeouts to fall back
+	// to the max timeout, until the next success
+	maxTimeoutFailures = 3
+)
+
+type AdaptiveTimeoutConfig struct {
+	Enable bool `yaml:"enable" json:"enable"`
+	// Percentile of the recent latencies, 99 by default
+	Percentile float64 `yaml:"percentile" json:"percentile"`
+	// Factor multiplies the percentile latency, 3 by default
+	Factor float64 `yaml:"factor" json:"factor"`
+	// Min and Max clamp the timeouts in seconds, 1 and 10 by default
+	Min uint `yaml:"min" json:"min"`
+	Max uint `yaml:"max" json:"max"`
+	// Samples is the number of the recent latencies kept for each backend, 100 by default
+	Samples int `yaml:"samples" json:"samples"`
+}
+
+// TimeoutStatus is the effective timeout of the backend
+type TimeoutStatus struct {
+	// Timeout is the connect and handshake timeout in seconds
+	Timeout  uint          `json:"timeout"`
+	Adaptive bool          `json:"adaptive"`
+	Latency  time.Duration `json:"latency,omitempty"`
+	Samples  int           `json:"samples"`
+	// Fallback is true if the adaptive timeout is too short for the recent checks or dials
+	Fallback bool `json:"fallback,omitempty"`
+}
+
+// latencySamples are the recent successful latencies of a backend
+type latencySamples struct {
+	lock    sync.Mutex
+	samples []time.Duration
+	next    int
+	// timeouts is the number of the consecutive timeouts
+	timeouts int
+}
+
+var (
+	adaptiveTimeout     AdaptiveTimeoutConfig
+	adaptiveTimeoutLock sync.RWMutex
+	latencyLock         sync.Mutex
+)
+
+// SetAdaptiveTimeout to derive the timeouts of the backends from their recent latencies
+func SetAdaptiveTimeout(config AdaptiveTimeoutConfig) {
+	if config.Percentile <= 0 || config.Percentile > 100 {
+		config.Percentile = defaultTimeoutPercentile
+	}
+
+	if config.Factor <= 0 {
+		config.Factor = defaultTimeoutFactor
+	}
+
+	if config.Min == 0 {
+		config.Min = defaultTimeoutMin
+	}
+
+	if config.Max == 0 {
+		config.Max = defaultTimeoutMax
+	}
+
+	if config.Max < config.Min {
+		config.Max = config.Min
+	}
+
+	if config.Samples <= 0 {
+		config.Samples = defaultTimeoutSamples
+	}
+
+	adaptiveTimeoutLock.Lock()
+	defer adaptiveTimeoutLock.Unlock()
+
+	adaptiveTimeout = config
+}
+
+// AdaptiveTimeout returns the adaptive timeout setting of the process
+func AdaptiveTimeout() AdaptiveTimeoutConfig {
+	adaptiveTimeoutLock.RLock()
+	defer adaptiveTimeoutLock.RUnlock()
+
+	return adaptiveTimeout
+}
+
+// latencySamples returns the latency samples of the backend, created if not exists
+func (b *Backend) latencySamples() *latencySamples {
+	latencyLock.Lock()
+	defer latencyLock.Unlock()
+
+	if b.latency == nil {
+		b.latency = &latencySamples{}
+	}
+
+	return b.latency
+}
+
+// add to keep the latency, the oldest one is replaced if it is full, the lock should be held
+func (s *latencySamples) add(latency time.Duration, max int) {
+	if len(s.samples) < max {
+		s.samples = append(s.samples, latency)
+		return
+	}
+
+	s.samples[s.next%len(s.samples)] = latency
+	s.next++
+}
+
+// recordLatency to keep the latency of the successful check or dial
+func (b *Backend) recordLatency(latency time.Duration) {
+	config := AdaptiveTimeout()
+	if !config.Enable {
+		return
+	}
+
+	samples := b.latencySamples()
+	samples.lock.Lock()
+	defer samples.lock.Unlock()
+
+	samples.add(latency, config.Samples)
+	samples.timeouts = 0
+}
+
+// recordFailure to keep the elapsed time of the timed out check or dial, which is a lower
+// bound of the latency, so the adaptive timeout grows with the latency of the backend
+func (b *Backend) recordFailure(latency time.Duration, err error) {
+	config := AdaptiveTimeout()
+	var netErr net.Error
+	if !config.Enable || !errors.As(err, &netErr) || !netErr.Timeout() {
+		return
+	}
+
+	samples := b.latencySamples()
+	samples.lock.Lock()
+	defer samples.lock.Unlock()
+
+	samples.add(latency, config.Samples)
+	samples.timeouts++
+}
+
+// percentile returns the latency at the percentile and the number of the samples
+func (s *latencySamples) percentile(p float64) (time.Duration, int) {
+	s.lock.Lock()
+	sorted := append([]time.Duration{}, s.samples...)
+	s.lock.Unlock()
+
+	if len(sorted) == 0 {
+		return 0, 0
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+
+	return sorted[i], len(sorted)
+}
+
+// TimeoutStatus returns the effective timeout of the backend, the configured
+// one until there are enough samples for the adaptive timeout
+func (b *Backend) TimeoutStatus() (status TimeoutStatus) {
+	status.Timeout = b.CheckConfig.Timeout
+
+	config := AdaptiveTimeout()
+	if !config.Enable {
+		return
+	}
+
+	latencyLock.Lock()
+	samples := b.latency
+	latencyLock.Unlock()
+
+	if samples == nil {
+		return
+	}
+
+	status.Latency, status.Samples = samples.percentile(config.Percentile)
+
+	// the timeout is too short for the backend now, give it the max one until it succeeds
+	samples.lock.Lock()
+	status.Fallback = samples.timeouts >= maxTimeoutFailures
+	samples.lock.Unlock()
+	if status.Fallback {
+		if status.Timeout < config.Max {
+			status.Timeout = config.Max
+		}
+		return
+	}
+
+	if status.Samples < minTimeoutSamples {
+		return
+	}
+
+	timeout := uint(math.Ceil((time.Duration(float64(status.Latency) * config.Factor)).Seconds()))
+	if timeout < config.Min {
+		timeout = config.Min
+	}
+	if timeout > config.Max {
+		timeout = config.Max
+	}
+
+	status.Timeout, status.Adaptive = timeout, true
+	return
+}
+
+// Timeout returns the effective connect and handshake timeout of the backend in seconds
+func (b *Backend) Timeout() int {
+	return int(b.TimeoutStatus().Timeout)
+}
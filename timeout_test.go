@@ -0,0 +1,97 @@
+package socks5lb
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_AdaptiveTimeout(t *testing.T) {
+	SetAdaptiveTimeout(AdaptiveTimeoutConfig{Enable: true, Samples: 20})
+	t.Cleanup(func() {
+		SetAdaptiveTimeout(AdaptiveTimeoutConfig{})
+	})
+
+	backend := NewBackend("10.90.0.1:1086", BackendCheckConfig{InitialAlive: true, Timeout: 3})
+	pool := &Pool{backends: make(map[string]*Backend)}
+	assert.NoError(t, pool.Add(backend))
+
+	// the configured timeout is used until there are enough samples
+	for i := 0; i < minTimeoutSamples-1; i++ {
+		pool.Observe("example.com:443", backend, 2*time.Second, nil)
+	}
+	pool.Observe("example.com:443", backend, time.Minute, errors.New("timeout"))
+	assert.Equal(t, TimeoutStatus{Timeout: 3, Latency: 2 * time.Second, Samples: minTimeoutSamples - 1}, backend.TimeoutStatus())
+
+	// p99 x 3
+	pool.Observe("example.com:443", backend, 2*time.Second, nil)
+	assert.Equal(t, 6, backend.Timeout())
+	assert.True(t, NewBackendView(backend).EffectiveTimeout.Adaptive)
+
+	// the old samples are replaced by the recent ones, clamped to the min
+	for i := 0; i < 20; i++ {
+		backend.recordLatency(50 * time.Millisecond)
+	}
+	assert.Equal(t, 1, backend.Timeout())
+
+	// the slow tail makes it longer, clamped to the max
+	backend.recordLatency(30 * time.Second)
+	assert.Equal(t, 10, backend.Timeout())
+
+	SetAdaptiveTimeout(AdaptiveTimeoutConfig{})
+	assert.Equal(t, 3, backend.Timeout())
+	assert.False(t, backend.TimeoutStatus().Adaptive)
+}
+
+func TestBackend_AdaptiveTimeoutFallback(t *testing.T) {
+	SetAdaptiveTimeout(AdaptiveTimeoutConfig{Enable: true, Samples: 20})
+	t.Cleanup(func() {
+		SetAdaptiveTimeout(AdaptiveTimeoutConfig{})
+	})
+
+	backend := NewBackend("10.90.0.2:1086", BackendCheckConfig{InitialAlive: true, Timeout: 3})
+	for i := 0; i < 20; i++ {
+		backend.recordLatency(50 * time.Millisecond)
+	}
+	assert.Equal(t, 1, backend.Timeout())
+
+	// the other failures say nothing about the latency
+	for i := 0; i < maxTimeoutFailures; i++ {
+		backend.recordFailure(time.Millisecond, errors.New("connection refused"))
+	}
+	assert.Equal(t, 1, backend.Timeout())
+
+	// the latency rises, the max timeout is used after the consecutive timeouts
+	for i := 0; i < maxTimeoutFailures-1; i++ {
+		backend.recordFailure(time.Second, os.ErrDeadlineExceeded)
+		assert.False(t, backend.TimeoutStatus().Fallback)
+	}
+	backend.recordFailure(time.Second, os.ErrDeadlineExceeded)
+	assert.Equal(t, TimeoutStatus{Timeout: 10, Latency: time.Second, Samples: 20, Fallback: true}, backend.TimeoutStatus())
+
+	// the timed out samples keep the timeout longer after the success
+	backend.recordLatency(time.Second)
+	assert.Equal(t, 3, backend.Timeout())
+	assert.True(t, backend.TimeoutStatus().Adaptive)
+}
+
+func TestLatencySamples_Percentile(t *testing.T) {
+	samples := &latencySamples{}
+	for i := 1; i <= 100; i++ {
+		samples.samples = append(samples.samples, time.Duration(i)*time.Millisecond)
+	}
+
+	for p, expected := range map[float64]time.Duration{
+		50:  50 * time.Millisecond,
+		99:  99 * time.Millisecond,
+		100: 100 * time.Millisecond,
+		0.1: time.Millisecond,
+	} {
+		latency, n := samples.percentile(p)
+		assert.Equal(t, expected, latency, p)
+		assert.Equal(t, 100, n)
+	}
+}
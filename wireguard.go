@@ -0,0 +1,257 @@
+/**
+ * File: wireguard.go
+ * Author: Ming Cheng<mingcheng@outlook.com>
+ *
+ * Created Date: Tuesday, October 20th 2026, 10:26:42 am
+ * Last Modified: Tuesday, October 20th 2026, 10:26:42 am
+ *
+ * http://www.opensource.org/licenses/MIT
+ */
+
+package socks5lb
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+const defaultWireGuardMTU = 1420
+
+type BackendWireGuardConfig struct {
+	// PrivateKey is the local private key in base64, like the output of `wg genkey`
+	PrivateKey string `yaml:"private_key" json:"private_key"`
+	// PublicKey is the public key of the peer, the peer endpoint is the backend address
+	PublicKey    string `yaml:"public_key" json:"public_key"`
+	PresharedKey string `yaml:"preshared_key" json:"preshared_key"`
+	// Addresses are the local addresses in the tunnel, like 10.0.0.2
+	Addresses []string `yaml:"addresses" json:"addresses"`
+	// DNS servers in the tunnel to resolve the destinations, resolve them locally if empty
+	DNS        []string `yaml:"dns" json:"dns"`
+	AllowedIPs []string `yaml:"allowed_ips" json:"allowed_ips"`
+	MTU        int      `yaml:"mtu" json:"mtu"`
+	// PersistentKeepalive is the keepalive interval in seconds, disabled if zero
+	PersistentKeepalive int `yaml:"persistent_keepalive" json:"persistent_keepalive"`
+}
+
+// wireguardTunnel is the userspace wireguard device with its tcp/ip stack
+type wireguardTunnel struct {
+	device *device.Device
+	net    *netstack.Net
+	dns    bool
+}
+
+var wireguardTunnelsLock sync.Mutex
+
+// wireguardKey converts the base64 key into the hex one used by the wireguard configuration
+func wireguardKey(key string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(data) != 32 {
+		return "", fmt.Errorf("invalid wireguard key %s", key)
+	}
+
+	return hex.EncodeToString(data), nil
+}
+
+// parseAddrs parses the addresses, also the prefixes like 10.0.0.2/32
+func parseAddrs(addrs []string) (parsed []netip.Addr, err error) {
+	for _, addr := range addrs {
+		if prefix, err := netip.ParsePrefix(addr); err == nil {
+			parsed = append(parsed, prefix.Addr())
+			continue
+		}
+
+		ip, err := netip.ParseAddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %s", addr)
+		}
+		parsed = append(parsed, ip)
+	}
+
+	return
+}
+
+// ipcConfig returns the wireguard configuration of the device in the uapi format
+func (c BackendWireGuardConfig) ipcConfig(endpoint string) (string, error) {
+	privateKey, err := wireguardKey(c.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	publicKey, err := wireguardKey(c.PublicKey)
+	if err != nil {
+		return "", err
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	var config strings.Builder
+	fmt.Fprintf(&config, "private_key=%s\npublic_key=%s\nendpoint=%s\n", privateKey, publicKey, addr)
+
+	if c.PresharedKey != "" {
+		presharedKey, err := wireguardKey(c.PresharedKey)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&config, "preshared_key=%s\n", presharedKey)
+	}
+
+	if c.PersistentKeepalive > 0 {
+		fmt.Fprintf(&config, "persistent_keepalive_interval=%d\n", c.PersistentKeepalive)
+	}
+
+	allowedIPs := c.AllowedIPs
+	if len(allowedIPs) == 0 {
+		allowedIPs = []string{"0.0.0.0/0", "::/0"}
+	}
+
+	for _, allowedIP := range allowedIPs {
+		fmt.Fprintf(&config, "allowed_ip=%s\n", allowedIP)
+	}
+
+	return config.String(), nil
+}
+
+// newWireGuardTunnel starts the userspace wireguard device to the peer
+func newWireGuardTunnel(endpoint string, config BackendWireGuardConfig) (tunnel *wireguardTunnel, err error) {
+	addrs, err := parseAddrs(config.Addresses)
+	if err != nil {
+		return
+	}
+
+	if len(addrs) == 0 {
+		return nil, errors.New("the addresses of the wireguard tunnel are required")
+	}
+
+	dns, err := parseAddrs(config.DNS)
+	if err != nil {
+		return
+	}
+
+	ipc, err := config.ipcConfig(endpoint)
+	if err != nil {
+		return
+	}
+
+	mtu := config.MTU
+	if mtu <= 0 {
+		mtu = defaultWireGuardMTU
+	}
+
+	tun, tnet, err := netstack.CreateNetTUN(addrs, dns, mtu)
+	if err != nil {
+		return
+	}
+
+	logger := &device.Logger{
+		Verbosef: func(format string, args ...interface{}) {
+			log.Tracef("[wireguard] "+format, args...)
+		},
+		Errorf: func(format string, args ...interface{}) {
+			log.Debugf("[wireguard] "+format, args...)
+		},
+	}
+
+	dev := device.NewDevice(tun, conn.NewDefaultBind(), logger)
+	if err = dev.IpcSet(ipc); err != nil {
+		dev.Close()
+		return nil, err
+	}
+
+	if err = dev.Up(); err != nil {
+		dev.Close()
+		return nil, err
+	}
+
+	log.Debugf("[wireguard] tunnel to %s is up, addresses %v", endpoint, addrs)
+	return &wireguardTunnel{device: dev, net: tnet, dns: len(dns) > 0}, nil
+}
+
+// DialContext to connect the destination through the tunnel
+func (t *wireguardTunnel) DialContext(ctx context.Context, network, addr string) (conn net.Conn, err error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return
+	}
+
+	// resolve the destination locally without the dns servers in the tunnel
+	if t.dns || net.ParseIP(host) != nil {
+		return t.net.DialContext(ctx, network, addr)
+	}
+
+	ips, err := Resolver().LookupIPAddr(ctx, host)
+	if err != nil {
+		return
+	}
+
+	for _, ip := range ips {
+		if conn, err = t.net.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port)); err == nil {
+			return
+		}
+	}
+
+	if err == nil {
+		err = fmt.Errorf("no address found for %s", host)
+	}
+
+	return
+}
+
+// wireguardTunnel returns the tunnel of the backend, starts it if not yet
+func (b *Backend) wireguardTunnel() (*wireguardTunnel, error) {
+	wireguardTunnelsLock.Lock()
+	defer wireguardTunnelsLock.Unlock()
+
+	if b.wireguard == nil {
+		tunnel, err := newWireGuardTunnel(b.Addr, b.WireGuard)
+		if err != nil {
+			return nil, err
+		}
+		b.wireguard = tunnel
+	}
+
+	return b.wireguard, nil
+}
+
+// dialWireGuard to connect the destination through the wireguard tunnel of the backend
+func (b *Backend) dialWireGuard(network, addr string, timeout int) (net.Conn, error) {
+	tunnel, err := b.wireguardTunnel()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+
+	return tunnel.DialContext(ctx, network, addr)
+}
+
+// closeWireGuard to stop the wireguard tunnel of the backend if it is started
+func (b *Backend) closeWireGuard() {
+	wireguardTunnelsLock.Lock()
+	defer wireguardTunnelsLock.Unlock()
+
+	if b.wireguard != nil {
+		b.wireguard.device.Close()
+		b.wireguard = nil
+	}
+}
@@ -0,0 +1,99 @@
+package socks5lb
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/curve25519"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// WireGuardKey returns a new wireguard key pair in base64
+func WireGuardKey(t *testing.T) (string, string) {
+	private := make([]byte, curve25519.ScalarSize)
+	_, err := rand.Read(private)
+	assert.NoError(t, err)
+	private[0] &= 248
+	private[31] = (private[31] & 127) | 64
+
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	assert.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(private), base64.StdEncoding.EncodeToString(public)
+}
+
+// NewTestWireGuardPeer starts the in-process wireguard peer serving http in the tunnel
+func NewTestWireGuardPeer(t *testing.T, addr, privateKey, peerPublicKey, peerAddr string) string {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	port := udp.LocalAddr().(*net.UDPAddr).Port
+	_ = udp.Close()
+
+	tun, tnet, err := netstack.CreateNetTUN([]netip.Addr{netip.MustParseAddr(addr)}, nil, defaultWireGuardMTU)
+	assert.NoError(t, err)
+
+	private, _ := base64.StdEncoding.DecodeString(privateKey)
+	public, _ := base64.StdEncoding.DecodeString(peerPublicKey)
+	dev := device.NewDevice(tun, conn.NewDefaultBind(), device.NewLogger(device.LogLevelSilent, ""))
+	assert.NoError(t, dev.IpcSet(fmt.Sprintf("private_key=%s\nlisten_port=%d\npublic_key=%s\nallowed_ip=%s/32\n",
+		hex.EncodeToString(private), port, hex.EncodeToString(public), peerAddr)))
+	assert.NoError(t, dev.Up())
+	t.Cleanup(dev.Close)
+
+	listener, err := tnet.ListenTCP(&net.TCPAddr{IP: net.ParseIP(addr), Port: 80})
+	assert.NoError(t, err)
+	go func() {
+		_ = http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("hello wireguard"))
+		}))
+	}()
+
+	return fmt.Sprintf("127.0.0.1:%d", port)
+}
+
+func TestBackend_WireGuard(t *testing.T) {
+	serverPrivate, serverPublic := WireGuardKey(t)
+	clientPrivate, clientPublic := WireGuardKey(t)
+	endpoint := NewTestWireGuardPeer(t, "10.99.0.2", serverPrivate, clientPublic, "10.99.0.1")
+
+	backend := NewBackend(endpoint, BackendCheckConfig{CheckURL: "http://10.99.0.2/", Timeout: 5})
+	backend.Protocol = ProtocolWireGuard
+	backend.WireGuard = BackendWireGuardConfig{
+		PrivateKey: clientPrivate,
+		PublicKey:  serverPublic,
+		Addresses:  []string{"10.99.0.1/32"},
+		AllowedIPs: []string{"10.99.0.0/24"},
+	}
+	defer backend.closeWireGuard()
+
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return backend.DialFor("", network, addr, 5)
+		},
+	}}
+
+	resp, err := client.Get("http://10.99.0.2/")
+	if assert.NoError(t, err) {
+		data, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(t, "hello wireguard", string(data))
+	}
+
+	assert.NoError(t, backend.Check())
+	assert.True(t, backend.Alive())
+
+	// the key is required
+	invalid := NewBackend(endpoint, BackendCheckConfig{})
+	invalid.Protocol = ProtocolWireGuard
+	_, err = invalid.DialFor("", "tcp", "10.99.0.2:80", 1)
+	assert.Error(t, err)
+}